@@ -0,0 +1,85 @@
+// # Alpine packages.
+//
+// Concatenated gzip streams of a control tar segment, holding `.PKGINFO` and the install
+// scripts, and of a data tar segment, as read by apk-tools 2. Packages are unsigned, so they
+// are installed with `apk add --allow-untrusted`.
+//
+// apk keeps local changes to files under /etc on upgrades by default.
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// apkVersion returns the Alpine version of a package, with a package release of 0.
+//
+// Pre-releases map to the suffixes apk orders before releases, e.g. "0.26.0_rc1-r0".
+func apkVersion(v *semver.Version) string {
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if pre := v.Prerelease(); pre != "" {
+		suffix := "pre"
+		for _, known := range []string{"alpha", "beta", "pre", "rc"} {
+			if strings.HasPrefix(pre, known) {
+				suffix = known
+				break
+			}
+		}
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.TrimPrefix(pre, suffix))
+		version += "_" + suffix + digits
+	}
+	return version + "-r0"
+}
+
+// writeAPK writes an Alpine package.
+func writeAPK(spec *packageSpec) ([]byte, error) {
+	// apk records the checksum of each file from the extended headers.
+	data, err := gzipTar(spec.WithParentDirs(), "", spec.BuildTime, true, func(f packageFile) map[string]string {
+		return map[string]string{"APK-TOOLS.checksum.SHA1": fmt.Sprintf("%x", sha1.Sum(f.Data))}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the data segment: %w", err)
+	}
+
+	var info strings.Builder
+	info.WriteString("# Generated by memos-builds\n")
+	fmt.Fprintf(&info, "pkgname = %s\n", spec.Name)
+	fmt.Fprintf(&info, "pkgver = %s\n", apkVersion(spec.Version))
+	fmt.Fprintf(&info, "pkgdesc = %s\n", packageSummary)
+	fmt.Fprintf(&info, "url = %s\n", packageHomepage)
+	fmt.Fprintf(&info, "builddate = %d\n", spec.BuildTime.Unix())
+	fmt.Fprintf(&info, "packager = %s\n", packageMaintainer)
+	fmt.Fprintf(&info, "size = %d\n", spec.InstalledSize())
+	fmt.Fprintf(&info, "arch = %s\n", spec.Arch)
+	fmt.Fprintf(&info, "origin = %s\n", spec.Name)
+	fmt.Fprintf(&info, "license = %s\n", packageLicense)
+	fmt.Fprintf(&info, "datahash = %x\n", sha256.Sum256(data))
+
+	script := func(body string) []byte {
+		return []byte("#!/bin/sh\n\n" + body + "exit 0\n")
+	}
+	control, err := gzipTar([]packageFile{
+		{Path: "/.PKGINFO", Mode: 0644, Data: []byte(info.String())},
+		{Path: "/.pre-install", Mode: 0755, Data: script(packagePreInstall)},
+		{Path: "/.pre-upgrade", Mode: 0755, Data: script(packagePreInstall)},
+		{Path: "/.post-install", Mode: 0755, Data: script(packagePostInstall)},
+		{Path: "/.post-upgrade", Mode: 0755, Data: script(packagePostInstall)},
+		{Path: "/.pre-deinstall", Mode: 0755, Data: script(packagePreRemove)},
+		{Path: "/.post-deinstall", Mode: 0755, Data: script(packagePostRemove)},
+	}, "", spec.BuildTime, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the control segment: %w", err)
+	}
+
+	return bytes.Join([][]byte{control, data}, nil), nil
+}
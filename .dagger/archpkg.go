@@ -0,0 +1,74 @@
+// # Arch Linux packages.
+//
+// A tar archive starting with `.PKGINFO` and the `.INSTALL` script, as read by pacman.
+// The archive is compressed with zstd by createPackages.
+//
+// pacman keeps local changes to the files listed as `backup`, saving new versions as `.pacnew`.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Characters not allowed in a pacman pkgver.
+var archVersionInvalid = regexp.MustCompile(`[^A-Za-z0-9._]`)
+
+// archVersion returns the pacman version of a package, with a package release of 1.
+//
+// Pre-releases are appended without a separator, which pacman orders before releases,
+// e.g. "0.26.0rc.1-1".
+func archVersion(v *semver.Version) string {
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if v.Prerelease() != "" {
+		version += archVersionInvalid.ReplaceAllString(v.Prerelease(), "_")
+	}
+	return version + "-1"
+}
+
+// writeArchPackage writes an uncompressed Arch Linux package.
+func writeArchPackage(spec *packageSpec) ([]byte, error) {
+	var info strings.Builder
+	info.WriteString("# Generated by memos-builds\n")
+	fmt.Fprintf(&info, "pkgname = %s\n", spec.Name)
+	fmt.Fprintf(&info, "pkgbase = %s\n", spec.Name)
+	info.WriteString("xdata = pkgtype=pkg\n")
+	fmt.Fprintf(&info, "pkgver = %s\n", archVersion(spec.Version))
+	fmt.Fprintf(&info, "pkgdesc = %s\n", packageSummary)
+	fmt.Fprintf(&info, "url = %s\n", packageHomepage)
+	fmt.Fprintf(&info, "builddate = %d\n", spec.BuildTime.Unix())
+	fmt.Fprintf(&info, "packager = %s\n", packageMaintainer)
+	fmt.Fprintf(&info, "size = %d\n", spec.InstalledSize())
+	fmt.Fprintf(&info, "arch = %s\n", spec.Arch)
+	fmt.Fprintf(&info, "license = %s\n", packageLicense)
+	for _, path := range spec.ConfigFiles() {
+		fmt.Fprintf(&info, "backup = %s\n", strings.TrimPrefix(path, "/"))
+	}
+
+	var install strings.Builder
+	for _, hook := range []struct{ name, body string }{
+		{"pre_install", packagePreInstall},
+		{"pre_upgrade", packagePreInstall},
+		{"post_install", packagePostInstall},
+		{"post_upgrade", packagePostInstall},
+		{"pre_remove", packagePreRemove},
+		{"post_remove", packagePostRemove},
+	} {
+		fmt.Fprintf(&install, "%s() {\n%s}\n\n", hook.name, indentScript(hook.body))
+	}
+
+	files := append([]packageFile{
+		{Path: "/.PKGINFO", Mode: 0644, Data: []byte(info.String())},
+		{Path: "/.INSTALL", Mode: 0644, Data: []byte(install.String())},
+	}, spec.WithParentDirs()...)
+
+	var b bytes.Buffer
+	if err := writeTar(&b, files, "", spec.BuildTime, true, nil); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,166 @@
+// # Authenticode signing.
+//
+// Windows binaries are signed with osslsigncode, an Authenticode implementation for Linux,
+// after the checks of the compiled binaries and before they are archived. Each signature
+// is verified right after signing, so a broken certificate chain never reaches a release.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Where the signing secrets are mounted in the signing container.
+const (
+	authenticodeCertificatePath = "/run/secrets/authenticode.crt"
+	authenticodeKeyPath         = "/run/secrets/authenticode.key"
+	authenticodePassphrasePath  = "/run/secrets/authenticode.pass"
+)
+
+// Signs /work/unsigned.exe as /work/signed.exe, with a SHA-256 digest and, when
+// TIMESTAMP_URL is set, an RFC 3161 timestamp.
+const authenticodeSignScript = `set -eu
+set -- -certs ` + authenticodeCertificatePath + ` -key ` + authenticodeKeyPath + `
+if [ -f ` + authenticodePassphrasePath + ` ]; then
+	set -- "$@" -readpass ` + authenticodePassphrasePath + `
+fi
+if [ -n "$TIMESTAMP_URL" ]; then
+	set -- "$@" -ts "$TIMESTAMP_URL"
+fi
+rm -f /work/signed.exe
+osslsigncode sign "$@" -h sha256 -n "$DESCRIPTION" -i "$HOMEPAGE" -in /work/unsigned.exe -out /work/signed.exe
+`
+
+// Verifies the signature of /work/signed.exe against the system CAs and the signing
+// certificate file, so chains ending in a private or self-signed root verify too.
+const authenticodeVerifyScript = `set -eu
+cat /etc/ssl/certs/ca-certificates.crt ` + authenticodeCertificatePath + ` > /tmp/ca.pem
+set -- -CAfile /tmp/ca.pem
+if [ -n "$TIMESTAMP_URL" ]; then
+	set -- "$@" -TSA-CAfile /etc/ssl/certs/ca-certificates.crt
+fi
+osslsigncode verify "$@" -in /work/signed.exe
+`
+
+// authenticodeOptions holds the signing settings of a build.
+type authenticodeOptions struct {
+	// PEM certificate, followed by its intermediates.
+	Certificate *dagger.Secret
+	// PEM private key of the certificate.
+	Key *dagger.Secret
+	// Passphrase of the key. May be nil.
+	Passphrase *dagger.Secret
+	// RFC 3161 timestamping service. Empty to leave signatures untimestamped.
+	TimestampURL string
+}
+
+// signResult is the outcome of signing the binary of a target.
+type signResult struct {
+	Target BuildMatrix
+	Binary *dagger.File
+	Err    error
+}
+
+// authenticodeTimestampURL returns the timestamping service of a build, validating it.
+func authenticodeTimestampURL(timestampURL string, skip bool) (string, error) {
+	if skip {
+		if timestampURL != "" {
+			return "", fmt.Errorf("a timestamp URL cannot be combined with skipping timestamps")
+		}
+		return "", nil
+	}
+	if timestampURL == "" {
+		return buildconsts.AUTHENTICODE_TIMESTAMP_URL, nil
+	}
+	u, err := url.Parse(timestampURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid timestamp URL %q", timestampURL)
+	}
+	return timestampURL, nil
+}
+
+// signWindowsBinaries signs and verifies the binary of every Windows target, in parallel.
+//
+// Results are returned in target order.
+func (m *MemosBuilds) signWindowsBinaries(
+	ctx context.Context,
+	build *buildResult,
+	signing *authenticodeOptions,
+	opts buildOptions,
+) ([]signResult, error) {
+	var windows []BuildMatrix
+	for _, t := range build.Targets {
+		if t.OS == "windows" {
+			windows = append(windows, t)
+		}
+	}
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	retry := opts.RetryPolicy()
+	var base *dagger.Container
+	err := retry.Do(ctx, "apk add (osslsigncode)", func() (err error) {
+		base, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "osslsigncode"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the signing container: %w", err)
+	}
+
+	description := "Memos"
+	if build.Prepared.Branding != nil {
+		description = build.Prepared.Branding.Title
+	}
+	base = base.
+		WithMountedSecret(authenticodeCertificatePath, signing.Certificate).
+		WithMountedSecret(authenticodeKeyPath, signing.Key).
+		WithEnvVariable("TIMESTAMP_URL", signing.TimestampURL).
+		WithEnvVariable("DESCRIPTION", description).
+		WithEnvVariable("HOMEPAGE", packageHomepage).
+		WithWorkdir("/work")
+	if signing.Passphrase != nil {
+		base = base.WithMountedSecret(authenticodePassphrasePath, signing.Passphrase)
+	}
+
+	maxConcurrent := opts.Concurrency
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultConcurrency()
+	}
+	results := make([]signResult, len(windows))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrent)
+	for i, t := range windows {
+		g.Go(func() error {
+			results[i] = signResult{Target: t}
+			ctr := base.WithFile("/work/unsigned.exe", build.Binaries.File(t.BinaryName()))
+			// Timestamping is a network call; signing is repeated along with it.
+			var signed *dagger.Container
+			err := retry.Do(gctx, "osslsigncode sign ("+t.BinaryName()+")", func() (err error) {
+				signed, err = ctr.WithExec([]string{"sh", "-c", authenticodeSignScript}).Sync(gctx)
+				return err
+			})
+			if err != nil {
+				results[i].Err = fmt.Errorf("failed to sign %s: %w", t.BinaryName(), err)
+				return nil
+			}
+			if _, err := signed.WithExec([]string{"sh", "-c", authenticodeVerifyScript}).Sync(gctx); err != nil {
+				results[i].Err = fmt.Errorf("signature of %s does not verify: %w", t.BinaryName(), err)
+				return nil
+			}
+			results[i].Binary = signed.File("/work/signed.exe")
+			// Failures are collected, so that every target gets signed.
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results, nil
+}
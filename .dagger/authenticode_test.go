@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestAuthenticodeTimestampURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		skip bool
+		want string
+	}{
+		{"", false, buildconsts.AUTHENTICODE_TIMESTAMP_URL},
+		{"", true, ""},
+		{"http://timestamp.sectigo.com", false, "http://timestamp.sectigo.com"},
+		{"https://tsa.example.com:8443/rfc3161", false, "https://tsa.example.com:8443/rfc3161"},
+	}
+	for _, tt := range tests {
+		got, err := authenticodeTimestampURL(tt.url, tt.skip)
+		if err != nil {
+			t.Errorf("authenticodeTimestampURL(%q, %v): %v", tt.url, tt.skip, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("authenticodeTimestampURL(%q, %v) = %q, want %q", tt.url, tt.skip, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		url  string
+		skip bool
+	}{
+		{"http://timestamp.digicert.com", true},
+		{"timestamp.digicert.com", false},
+		{"ftp://timestamp.digicert.com", false},
+		{"https://", false},
+		{"http://[::1", false},
+	} {
+		if _, err := authenticodeTimestampURL(tt.url, tt.skip); err == nil {
+			t.Errorf("authenticodeTimestampURL(%q, %v) succeeded, want an error", tt.url, tt.skip)
+		}
+	}
+}
+
+func TestBuildOptionsValidateAuthenticode(t *testing.T) {
+	// Secrets are only resolved when a container mounts them.
+	certificate := dag.SetSecret("authenticode-certificate", "certificate")
+	key := dag.SetSecret("authenticode-key", "key")
+	passphrase := dag.SetSecret("authenticode-passphrase", "passphrase")
+
+	tests := []struct {
+		name  string
+		opts  buildOptions
+		valid bool
+	}{
+		{"unsigned", buildOptions{}, true},
+		{"signed", buildOptions{AuthenticodeCertificate: certificate, AuthenticodeKey: key}, true},
+		{"signed with every option", buildOptions{
+			AuthenticodeCertificate: certificate,
+			AuthenticodeKey:         key,
+			AuthenticodePassphrase:  passphrase,
+			TimestampURL:            "http://timestamp.sectigo.com",
+		}, true},
+		{"signed without timestamps", buildOptions{AuthenticodeCertificate: certificate, AuthenticodeKey: key, SkipTimestamp: true}, true},
+		{"certificate without key", buildOptions{AuthenticodeCertificate: certificate}, false},
+		{"key without certificate", buildOptions{AuthenticodeKey: key}, false},
+		{"passphrase without certificate", buildOptions{AuthenticodePassphrase: passphrase}, false},
+		{"timestamp URL without certificate", buildOptions{TimestampURL: "http://timestamp.sectigo.com"}, false},
+		{"skip timestamp without certificate", buildOptions{SkipTimestamp: true}, false},
+		{"invalid timestamp URL", buildOptions{AuthenticodeCertificate: certificate, AuthenticodeKey: key, TimestampURL: "timestamp"}, false},
+		{"timestamp URL and skip", buildOptions{
+			AuthenticodeCertificate: certificate,
+			AuthenticodeKey:         key,
+			TimestampURL:            "http://timestamp.sectigo.com",
+			SkipTimestamp:           true,
+		}, false},
+	}
+	for _, tt := range tests {
+		err := tt.opts.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: validated, want an error", tt.name)
+		}
+	}
+
+	if signing := (buildOptions{}).Authenticode(); signing != nil {
+		t.Errorf("unsigned builds have signing settings %+v", signing)
+	}
+	signing := buildOptions{AuthenticodeCertificate: certificate, AuthenticodeKey: key}.Authenticode()
+	if signing == nil || signing.TimestampURL != buildconsts.AUTHENTICODE_TIMESTAMP_URL {
+		t.Errorf("signing settings %+v, want the default timestamp URL", signing)
+	}
+}
+
+// requireEngine skips a test unless a Dagger engine answers.
+func requireEngine(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := dag.Version(ctx); err != nil {
+		t.Skipf("no Dagger engine: %v", err)
+	}
+}
+
+// testFile returns a file with the given raw contents. Tests are not module functions, so
+// newFileFromBytes has no module working directory to write to; the small test binaries
+// are sent as base64 instead.
+func testFile(name string, data []byte) *dagger.File {
+	return dag.Container().
+		From(buildconsts.PRIMARY_IMAGE).
+		WithNewFile("/work/"+name+".b64", base64.StdEncoding.EncodeToString(data)).
+		WithExec([]string{"sh", "-c", `base64 -d "$0.b64" > "$0"`, "/work/" + name}).
+		File("/work/" + name)
+}
+
+// testCodeSigningCertificate returns a self-signed code signing certificate and its
+// private key, in PEM.
+func testCodeSigningCertificate(t *testing.T) (certificate, key string) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "memos-builds test signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &private.PublicKey, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+// Signs a Windows binary with osslsigncode, as release builds do, then checks that the
+// verification of the signing stage rejects a modified copy. Needs a Dagger engine.
+func TestSignWindowsBinaries(t *testing.T) {
+	requireEngine(t)
+	ctx := context.Background()
+
+	pe := buildTestProgram(t, "windows", map[string]string{
+		"go.mod":  "module signtest\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}, "-s -w")
+	var target BuildMatrix
+	for _, candidate := range TARGETS {
+		if candidate.OS == "windows" && candidate.Arch == "amd64" {
+			target = candidate
+		}
+	}
+	certificate, key := testCodeSigningCertificate(t)
+	signing := &authenticodeOptions{
+		Certificate: dag.SetSecret("authenticode-test-certificate", certificate),
+		Key:         dag.SetSecret("authenticode-test-key", key),
+	}
+	build := &buildResult{
+		Prepared: testPreparedSource(),
+		Targets:  []BuildMatrix{target},
+		Binaries: dag.Directory().WithFile(target.BinaryName(), testFile(target.BinaryName(), pe)),
+	}
+
+	results, err := (&MemosBuilds{}).signWindowsBinaries(ctx, build, signing, buildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("results = %+v, want one signed binary", results)
+	}
+	signed, err := readFileBytes(ctx, results[0].Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) <= len(pe) {
+		t.Errorf("signed binary is %d bytes, the unsigned one %d", len(signed), len(pe))
+	}
+
+	// Flip a byte of the code, past the PE headers.
+	tampered := append([]byte(nil), signed...)
+	tampered[len(pe)/2] ^= 0xff
+
+	verifier := dag.Container().
+		From(buildconsts.PRIMARY_IMAGE).
+		WithExec([]string{"apk", "add", "--no-cache", "osslsigncode"}).
+		WithMountedSecret(authenticodeCertificatePath, signing.Certificate).
+		WithEnvVariable("TIMESTAMP_URL", "")
+	verify := func(binary []byte) error {
+		_, err := verifier.
+			WithFile("/work/signed.exe", testFile("signed.exe", binary)).
+			WithExec([]string{"sh", "-c", authenticodeVerifyScript}).
+			Sync(ctx)
+		return err
+	}
+	if err := verify(signed); err != nil {
+		t.Fatalf("signed binary does not verify: %v", err)
+	}
+	if err := verify(tampered); err == nil {
+		t.Error("tampered binary verifies")
+	}
+}
@@ -0,0 +1,307 @@
+// # White-label branding.
+//
+// Re-skins the frontend (logo, favicon, app title, theme color) before it is built.
+package main
+
+import (
+	"bytes"
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Name of the manifest at the root of a branding directory.
+const brandingManifestFile = "branding.json"
+
+// Web app manifests that get the branded name and theme color, relative to `web/`.
+var webAppManifests = []string{"public/site.webmanifest", "public/manifest.json"}
+
+var (
+	brandingNamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	brandingColorPattern  = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	htmlTitlePattern      = regexp.MustCompile(`(?s)<title>.*?</title>`)
+	htmlIconPattern       = regexp.MustCompile(`<link[^>]*rel="(?:shortcut )?icon"[^>]*href="([^"]+)"`)
+	htmlThemeColorPattern = regexp.MustCompile(`(<meta[^>]*name="theme-color"[^>]*content=")[^"]*(")`)
+	htmlAppTitlePattern   = regexp.MustCompile(`(<meta[^>]*name="apple-mobile-web-app-title"[^>]*content=")[^"]*(")`)
+)
+
+// brandingManifest describes a branding directory.
+//
+// Example:
+//
+//	{
+//	  "name": "acme",
+//	  "title": "Acme Notes",
+//	  "themeColor": "#0f172a",
+//	  "files": {
+//	    "public/logo.webp": "logo.webp",
+//	    "public/full-logo.webp": "full-logo.webp"
+//	  }
+//	}
+type brandingManifest struct {
+	// Identifier appended to archive names and image tags. Lowercase letters, digits and dashes.
+	Name string `json:"name"`
+	// Application title shown by browsers.
+	Title string `json:"title"`
+	// Optional browser theme color, as #rgb or #rrggbb.
+	ThemeColor string `json:"themeColor,omitempty"`
+	// Maps upstream paths (relative to `web/`) to replacement files in the branding directory.
+	Files map[string]string `json:"files"`
+}
+
+// parseBrandingManifest decodes and validates the fields of a branding manifest.
+func parseBrandingManifest(data string) (*brandingManifest, error) {
+	var b brandingManifest
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", brandingManifestFile, err)
+	}
+
+	switch {
+	case !brandingNamePattern.MatchString(b.Name):
+		return nil, fmt.Errorf("branding name %q must match %s", b.Name, brandingNamePattern)
+	case strings.TrimSpace(b.Title) == "":
+		return nil, fmt.Errorf("branding title is required")
+	case b.ThemeColor != "" && !brandingColorPattern.MatchString(b.ThemeColor):
+		return nil, fmt.Errorf("branding theme color %q must be #rgb or #rrggbb", b.ThemeColor)
+	case len(b.Files) == 0:
+		return nil, fmt.Errorf("branding must replace at least the favicon")
+	}
+
+	for target := range b.Files {
+		if path.IsAbs(target) || strings.HasPrefix(path.Clean(target), "..") {
+			return nil, fmt.Errorf("branding target %q must be relative to web/", target)
+		}
+	}
+	return &b, nil
+}
+
+// iconTarget returns the favicon path referenced by index.html, relative to `web/`.
+//
+// Vite serves `public/` at the root, so "/logo.webp" maps to "public/logo.webp".
+func iconTarget(indexHTML string) (string, bool) {
+	match := htmlIconPattern.FindStringSubmatch(indexHTML)
+	if len(match) < 2 || strings.Contains(match[1], "://") {
+		return "", false
+	}
+	return path.Join("public", strings.TrimPrefix(match[1], "/")), true
+}
+
+// brandIndexHTML applies the title and theme color to index.html.
+func brandIndexHTML(indexHTML string, b *brandingManifest) string {
+	title := html.EscapeString(b.Title)
+	indexHTML = htmlTitlePattern.ReplaceAllLiteralString(indexHTML, "<title>"+title+"</title>")
+	indexHTML = htmlAppTitlePattern.ReplaceAllString(indexHTML, "${1}"+strings.ReplaceAll(title, "$", "$$")+"${2}")
+
+	if b.ThemeColor == "" {
+		return indexHTML
+	}
+	if htmlThemeColorPattern.MatchString(indexHTML) {
+		return htmlThemeColorPattern.ReplaceAllString(indexHTML, "${1}"+b.ThemeColor+"${2}")
+	}
+	meta := fmt.Sprintf(`<meta name="theme-color" content="%s" />`, b.ThemeColor)
+	return strings.Replace(indexHTML, "</head>", "  "+meta+"\n  </head>", 1)
+}
+
+// brandWebAppManifest applies the title and theme color to a web app manifest.
+func brandWebAppManifest(contents string, b *brandingManifest) (string, error) {
+	var manifest map[string]any
+	if err := json.Unmarshal([]byte(contents), &manifest); err != nil {
+		return "", err
+	}
+	manifest["name"] = b.Title
+	manifest["short_name"] = b.Title
+	if b.ThemeColor != "" {
+		manifest["theme_color"] = b.ThemeColor
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Image formats whose dimensions are validated. SVG images have no intrinsic size.
+var sizedImageExts = []string{".webp", ".ico", ".png", ".jpg", ".jpeg", ".gif"}
+
+// imageSize returns the pixel dimensions of a PNG, JPEG, GIF, WebP or ICO image.
+func imageSize(name string, data []byte) (int, int, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".webp":
+		return webpSize(data)
+	case ".ico":
+		return icoSize(data)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// webpSize reads the canvas size from a WebP header.
+//
+// See <https://developers.google.com/speed/webp/docs/riff_container>.
+func webpSize(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, fmt.Errorf("not a WebP image")
+	}
+
+	le24 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 }
+	switch string(data[12:16]) {
+	case "VP8X":
+		return le24(data[24:27]) + 1, le24(data[27:30]) + 1, nil
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(data[21:25])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, nil
+	case "VP8 ":
+		return int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff), nil
+	}
+	return 0, 0, fmt.Errorf("unsupported WebP encoding %q", data[12:16])
+}
+
+// icoSize returns the size of the largest image in an ICO file.
+func icoSize(data []byte) (int, int, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[2:4]) != 1 {
+		return 0, 0, fmt.Errorf("not an ICO file")
+	}
+
+	var w, h int
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	for i := range count {
+		entry := 6 + i*16
+		if len(data) < entry+16 {
+			return 0, 0, fmt.Errorf("truncated ICO file")
+		}
+		// A zero byte means 256 pixels.
+		ew, eh := int(data[entry]), int(data[entry+1])
+		if ew == 0 {
+			ew = 256
+		}
+		if eh == 0 {
+			eh = 256
+		}
+		if ew*eh > w*h {
+			w, h = ew, eh
+		}
+	}
+	return w, h, nil
+}
+
+// applyBranding validates a branding directory against the upstream `web/` sources and applies it.
+//
+// Every replaced file must exist upstream, images must keep the upstream dimensions,
+// and the favicon referenced by index.html must be replaced.
+func (m *MemosBuilds) applyBranding(
+	ctx context.Context,
+	branding *dagger.Directory,
+	source *dagger.Directory,
+) (*dagger.Directory, *brandingManifest, error) {
+	manifestContents, err := branding.File(brandingManifestFile).Contents(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("branding directory must contain %s: %w", brandingManifestFile, err)
+	}
+	b, err := parseBrandingManifest(manifestContents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	web := source.Directory("web")
+	indexHTML, err := web.File("index.html").Contents(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read web/index.html: %w", err)
+	}
+	if icon, ok := iconTarget(indexHTML); ok {
+		if _, ok := b.Files[icon]; !ok {
+			return nil, nil, fmt.Errorf("branding must replace the favicon %q referenced by web/index.html", icon)
+		}
+	}
+
+	targets := make([]string, 0, len(b.Files))
+	for target := range b.Files {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+
+	for _, target := range targets {
+		src := b.Files[target]
+		if ok, _ := branding.Exists(ctx, src, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType}); !ok {
+			return nil, nil, fmt.Errorf("branding file %q not found", src)
+		}
+		if ok, _ := web.Exists(ctx, target, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType}); !ok {
+			return nil, nil, fmt.Errorf("branding target web/%s does not exist upstream", target)
+		}
+		if path.Ext(src) != path.Ext(target) {
+			return nil, nil, fmt.Errorf("branding file %q must have the same format as web/%s", src, target)
+		}
+
+		if err := m.validateBrandingImage(ctx, branding.File(src), web.File(target), target); err != nil {
+			return nil, nil, err
+		}
+		web = web.WithFile(target, branding.File(src))
+	}
+
+	web = web.WithNewFile("index.html", brandIndexHTML(indexHTML, b))
+	for _, manifest := range webAppManifests {
+		if ok, _ := web.Exists(ctx, manifest); !ok {
+			continue
+		}
+		contents, err := web.File(manifest).Contents(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read web/%s: %w", manifest, err)
+		}
+		branded, err := brandWebAppManifest(contents, b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to brand web/%s: %w", manifest, err)
+		}
+		web = web.WithNewFile(manifest, branded)
+	}
+
+	return source.WithDirectory("web", web), b, nil
+}
+
+// validateBrandingImage checks that a replacement image has the same dimensions as the upstream one.
+func (m *MemosBuilds) validateBrandingImage(ctx context.Context, replacement *dagger.File, upstream *dagger.File, target string) error {
+	if !slices.Contains(sizedImageExts, strings.ToLower(path.Ext(target))) {
+		return nil
+	}
+
+	replacementData, err := readFileBytes(ctx, replacement)
+	if err != nil {
+		return fmt.Errorf("failed to read branding file for %s: %w", target, err)
+	}
+	upstreamData, err := readFileBytes(ctx, upstream)
+	if err != nil {
+		return fmt.Errorf("failed to read web/%s: %w", target, err)
+	}
+
+	w, h, err := imageSize(target, replacementData)
+	if err != nil {
+		return fmt.Errorf("invalid branding image for %s: %w", target, err)
+	}
+	uw, uh, err := imageSize(target, upstreamData)
+	if err != nil {
+		// Not an image we understand upstream; nothing to compare against.
+		return nil
+	}
+	if w != uw || h != uh {
+		return fmt.Errorf("branding image for %s is %dx%d, expected %dx%d", target, w, h, uw, uh)
+	}
+	return nil
+}
@@ -6,16 +6,56 @@ import (
 	"dagger/memos-builds/internal/dagger"
 	"fmt"
 	"os"
+	"path"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"golang.org/x/sync/errgroup"
 )
 
+// compileStages runs the toolchains behind compile. Tests replace it to record the calls.
+type compileStages interface {
+	// GenerateProto runs `buf generate` on a source. See generateProto.
+	GenerateProto(source *dagger.Directory, bufImage string) *dagger.Directory
+	// Frontend returns the frontend to embed. See resolveFrontend.
+	Frontend(ctx context.Context, source *dagger.Directory, nodeImage string, opts buildOptions) (*dagger.Directory, error)
+	// Target runs the `go build` command of a target in a container prepared by buildBackend,
+	// returning the container once the binary is written.
+	Target(ctx context.Context, ctr *dagger.Container, t BuildMatrix, args []string) (*dagger.Container, error)
+}
+
+// engineStages runs the compile stages on the Dagger engine.
+type engineStages struct {
+	m *MemosBuilds
+}
+
+func (s engineStages) GenerateProto(source *dagger.Directory, bufImage string) *dagger.Directory {
+	return s.m.generateProto(source, bufImage)
+}
+
+func (s engineStages) Frontend(ctx context.Context, source *dagger.Directory, nodeImage string, opts buildOptions) (*dagger.Directory, error) {
+	return s.m.resolveFrontend(ctx, source, nodeImage, opts)
+}
+
+func (s engineStages) Target(ctx context.Context, ctr *dagger.Container, t BuildMatrix, args []string) (*dagger.Container, error) {
+	return ctr.WithExec(args).Sync(ctx)
+}
+
+// compileStages returns the compile stages of the module.
+func (m *MemosBuilds) compileStages() compileStages {
+	if m.stages != nil {
+		return m.stages
+	}
+	return engineStages{m}
+}
+
 // Generate Proto code
-func (m *MemosBuilds) generateProto(source *dagger.Directory) *dagger.Directory {
+func (m *MemosBuilds) generateProto(source *dagger.Directory, bufImage string) *dagger.Directory {
 	return dag.Container().
-		From(buildconsts.BUF_IMAGE).
+		From(bufImage).
 		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
 		WithWorkdir("/src").
 		WithDirectory("/src", source).
@@ -26,64 +66,215 @@ func (m *MemosBuilds) generateProto(source *dagger.Directory) *dagger.Directory
 }
 
 // Build the frontend
-func (m *MemosBuilds) buildFrontend(source *dagger.Directory) *dagger.Directory {
-	return dag.Container().
-		From(buildconsts.NODE_BUILD_IMAGE).
-		WithExec([]string{"corepack", "enable"}).
-		WithMountedCache("/root/.local/share/pnpm/store", dag.CacheVolume("pnpm-store")).
-		WithDirectory("/app/web", source.Directory("web")).
-		WithWorkdir("/app/web").
-		WithMountedCache("/app/web/node_modules", dag.CacheVolume("node-modules")).
-		WithExec([]string{"pnpm", "install"}).
+//
+// Dependencies are installed first, with retries, as it is the only step that needs the network.
+// With sourceMaps, the dist also holds the `.map` files; see splitSourceMaps.
+func (m *MemosBuilds) buildFrontend(
+	ctx context.Context,
+	source *dagger.Directory,
+	nodeImage string,
+	sourceMaps bool,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
+	var installed *dagger.Container
+	err := retry.Do(ctx, "pnpm install", func() (err error) {
+		installed, err = dag.Container().
+			From(nodeImage).
+			WithExec([]string{"corepack", "enable"}).
+			WithMountedCache("/root/.local/share/pnpm/store", dag.CacheVolume("pnpm-store")).
+			WithDirectory("/app/web", source.Directory("web")).
+			WithWorkdir("/app/web").
+			WithMountedCache("/app/web/node_modules", dag.CacheVolume("node-modules")).
+			WithExec([]string{"pnpm", "install"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to install frontend dependencies: %w", err)
+	}
+
+	release := []string{"pnpm", "run", "release"}
+	if sourceMaps {
+		// Hidden source maps carry no reference in the bundles, so they can ship separately.
+		release = append(release, "--sourcemap", "hidden")
+	}
+	return installed.
 		WithWorkdir("/app/web").
-		WithExec([]string{"pnpm", "run", "release"}).
-		Directory("/app/server/router/frontend/dist")
+		WithExec(release).
+		Directory("/app/server/router/frontend/dist"), nil
+}
+
+// Frontend modes recorded in the build metadata.
+const (
+	frontendModeBuilt    = "built"
+	frontendModePrebuilt = "prebuilt"
+	frontendModeHeadless = "headless"
+)
+
+// Placeholder page embedded by headless builds.
+const headlessIndexHTML = `<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Memos</title>
+</head>
+<body>
+  <h1>Memos</h1>
+  <p>This is a headless build of Memos. The web interface is not included; use the API instead.</p>
+</body>
+</html>
+`
+
+// resolveFrontend returns the frontend to embed in the backend.
+//
+// Uses the prebuilt dist or the headless placeholder when requested, building it otherwise.
+func (m *MemosBuilds) resolveFrontend(
+	ctx context.Context,
+	source *dagger.Directory,
+	nodeImage string,
+	opts buildOptions,
+) (*dagger.Directory, error) {
+	switch opts.FrontendMode() {
+	case frontendModeHeadless:
+		return dag.Directory().WithNewFile("index.html", headlessIndexHTML), nil
+	case frontendModePrebuilt:
+		ok, err := opts.FrontendDist.Exists(ctx, "index.html", dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType})
+		if err != nil || !ok {
+			return nil, fmt.Errorf("frontend dist must contain an index.html file")
+		}
+		return opts.FrontendDist, nil
+	default:
+		return m.buildFrontend(ctx, source, nodeImage, opts.DebugSymbols, opts.RetryPolicy())
+	}
+}
+
+// targetTiming is the time taken to compile a single target.
+type targetTiming struct {
+	Target  string  `json:"target"`
+	Seconds float64 `json:"seconds"`
+}
+
+// defaultConcurrency returns how many targets are compiled at once when not overridden.
+//
+// NumCPU-1, or NumCPU when CI=true.
+func defaultConcurrency() int {
+	if os.Getenv("CI") == "true" {
+		return runtime.NumCPU()
+	}
+	return max(runtime.NumCPU()-1, 1)
+}
+
+// appVersion returns the version linked into the app for a build version.
+//
+// Memos migrations will fail if we override this field with gibberish,
+// so it is only set when the build version is a valid semantic version.
+func appVersion(buildVersion string) (string, bool) {
+	v, err := semver.NewVersion(buildVersion)
+	if err != nil {
+		return "", false
+	}
+	// Version shown in app intentionally excludes build metadata (commit hash).
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if pre := v.Prerelease(); pre != "" {
+		version += "-" + pre
+	}
+	return version, true
 }
 
 // Build the backend binaries for the given targets.
-// Builds are dispatched in batches to control resource usage.
-// Batch size defaults to NumCPU-1, or NumCPU when CI=true.
+// Builds run in parallel on a bounded worker pool to control resource usage.
+// Binaries and timings are returned in target order, regardless of completion order.
+//
+// With opts.KeepGoing, failing targets are recorded in the result instead of aborting the others.
+// With opts.DebugSymbols, each target is linked a second time without stripping; see debugBundle.
 func (m *MemosBuilds) buildBackend(
 	ctx context.Context,
 	source *dagger.Directory,
 	frontendDist *dagger.Directory,
-	version string,
-	commit string,
+	prepared *preparedSource,
 	targets []BuildMatrix,
-) (*dagger.Directory, error) {
-	maxConcurrent := max(runtime.NumCPU()-1, 1)
-	if os.Getenv("CI") == "true" {
-		maxConcurrent = runtime.NumCPU()
+	opts buildOptions,
+) (*buildResult, error) {
+	maxConcurrent := opts.Concurrency
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultConcurrency()
 	}
+	// Split the CPUs between the concurrent compilers.
+	goMaxProcs := max(runtime.NumCPU()/maxConcurrent, 1)
 
+	// Omit the symbol table and DWARF from release binaries.
+	stripFlags := []string{"-s", "-w"}
 	ldflags := []string{
-		"-s",
-		"-w",
 		"-extldflags '-static'",
 	}
+	if opts.Hardened {
+		// The external linker is never used without cgo; fail instead of falling back to it.
+		ldflags = []string{"-linkmode=internal"}
+	}
 
-	// Memos migrations will fail if we override this field with gibberish.
-	v, err := semver.NewVersion(version)
-	if err == nil {
-		// Version shown in app intentionally excludes build metadata (commit hash).
-		appVersion := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
-		if pre := v.Prerelease(); pre != "" {
-			appVersion += "-" + pre
-		}
-
+	if version, ok := appVersion(prepared.BuildVersion); ok {
 		// https://pkg.go.dev/cmd/link
-		ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", buildconsts.VERSION_IMPORT_PATH, appVersion))
+		ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", buildconsts.VERSION_IMPORT_PATH, version))
 	}
-	if short := shortCommitHash(commit); short != "" {
+	if short := shortCommitHash(prepared.Commit); short != "" {
 		ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", buildconsts.COMMIT_IMPORT_PATH, short))
 	}
 
-	buildOne := func(c *dagger.Container, t BuildMatrix) *dagger.File {
+	buildFlags := []string{
+		// go.mod and go.sum are already pinned by resolveDependencies.
+		"-mod=readonly",
+		"-trimpath",
+	}
+
+	base := dag.Container().
+		From(prepared.Toolchain.Go.Image).
+		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
+		WithMountedCache("/root/.cache/go-build", dag.CacheVolume("go-build")).
+		WithWorkdir("/src").
+		WithDirectory("/src", source).
+		WithDirectory("/src/server/router/frontend/dist", frontendDist).
+		WithDirectory("/out", dag.Directory())
+	base = prepared.GoProxy.Apply(base)
+
+	base, overlayFlags, err := prepared.Overlay.Mount(base, "/src")
+	if err != nil {
+		return nil, err
+	}
+	buildFlags = append(buildFlags, overlayFlags...)
+
+	if prepared.Coverage {
+		buildFlags = append(buildFlags, coverageBuildFlags()...)
+	}
+	if prepared.FIPS {
+		// Selects the frozen module instead of the toolchain's crypto, and defaults GODEBUG to fips140=on.
+		base = base.WithEnvVariable("GOFIPS140", buildconsts.FIPS_MODULE_VERSION)
+	}
+
+	if prepared.PGO != nil {
+		base = base.WithFile(pgoMountPath, prepared.PGO.File)
+		buildFlags = append(buildFlags, "-pgo="+pgoMountPath)
+	}
+
+	// Version information and icon of memos.exe, linked from the main package.
+	resources, err := m.windowsResourceObjects(ctx, prepared, targets, opts.RetryPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Windows resources: %w", err)
+	}
+	if resources != nil {
+		base = base.WithDirectory(path.Join("/src", buildconsts.APP_ENTRYPOINT), resources)
+	}
+
+	// Returns the container and command compiling a target.
+	buildOne := func(c *dagger.Container, t BuildMatrix, strip bool) (*dagger.Container, []string) {
 		name := t.BinaryName()
+		linkFlags := ldflags
+		if strip {
+			linkFlags = slices.Concat(stripFlags, ldflags)
+		}
 
 		// Set architecture-specific environment variables.
 		ctr := c.
-			WithEnvVariable("GOMAXPROCS", fmt.Sprint(maxConcurrent)).
+			WithEnvVariable("GOMAXPROCS", fmt.Sprint(goMaxProcs)).
 			WithEnvVariable("CGO_ENABLED", "0").
 			WithEnvVariable("GOOS", t.OS).
 			WithEnvVariable("GOARCH", t.Arch)
@@ -105,38 +296,82 @@ func (m *MemosBuilds) buildBackend(
 			ctr = ctr.WithEnvVariable("GORISCV64", goriscv64)
 		}
 
-		return ctr.
-			WithExec([]string{"go", "build",
-				"-trimpath",
-				"-ldflags", strings.Join(ldflags, " "),
-				"-tags", "netgo,osusergo",
-				"-o", "/out/" + name,
-				buildconsts.APP_ENTRYPOINT,
-			}).
-			File("/out/" + name)
+		targetFlags := buildFlags
+		if opts.Hardened {
+			targetFlags = append(slices.Clone(buildFlags), "-buildmode="+hardenedBuildMode(t))
+		}
+
+		args := slices.Concat([]string{"go", "build"}, targetFlags, []string{
+			"-ldflags", strings.Join(linkFlags, " "),
+			"-tags", "netgo,osusergo",
+			"-o", "/out/" + name,
+			buildconsts.APP_ENTRYPOINT,
+		})
+		return ctr, args
 	}
+	stages := m.compileStages()
 
-	base := dag.Container().
-		From(buildconsts.GOLANG_BUILD_IMAGE).
-		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
-		WithMountedCache("/root/.cache/go-build", dag.CacheVolume("go-build")).
-		WithWorkdir("/src").
-		WithDirectory("/src", source).
-		WithDirectory("/src/server/router/frontend/dist", frontendDist).
-		// Tidy is required as go.mod may have been patched at earlier steps.
-		WithExec([]string{"go", "mod", "tidy", "-go=" + buildconsts.GO_VERSION}).
-		WithDirectory("/out", dag.Directory())
+	binaries := make([]*dagger.File, len(targets))
+	debugSymbols := make([]*dagger.Directory, len(targets))
+	timings := make([]targetTiming, len(targets))
+	errs := make([]error, len(targets))
 
-	out := dag.Directory()
-	for _, t := range targets {
-		f := buildOne(base, t)
-		out = out.WithFile(t.BinaryName(), f)
-		// Sync forces this build to complete before starting the next.
-		out, err = out.Sync(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build %s: %w", t.BinaryName(), err)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrent)
+	for i, t := range targets {
+		g.Go(func() error {
+			start := time.Now()
+			// The build completes while holding a worker slot.
+			ctr, args := buildOne(base, t, true)
+			built, err := stages.Target(gctx, ctr, t, args)
+			var f *dagger.File
+			if err == nil {
+				f = built.File("/out/" + t.BinaryName())
+			}
+			if err == nil && opts.DebugSymbols {
+				// Only the link step differs, so the compiled packages come from the build cache.
+				var debugBuild *dagger.Container
+				debugCtr, debugArgs := buildOne(base, t, false)
+				debugBuild, err = stages.Target(gctx, debugCtr, t, debugArgs)
+				if err == nil {
+					debugSymbols[i], err = debugBundle(debugBuild, f, t).Sync(gctx)
+				}
+			}
+			timings[i] = targetTiming{
+				Target:  t.DockerPlatform(),
+				Seconds: time.Since(start).Round(100 * time.Millisecond).Seconds(),
+			}
+			if err != nil {
+				errs[i] = err
+				if opts.KeepGoing {
+					return nil
+				}
+				return fmt.Errorf("failed to build %s: %w", t.BinaryName(), err)
+			}
+			binaries[i] = f
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := &buildResult{Binaries: dag.Directory()}
+	if opts.DebugSymbols {
+		result.DebugSymbols = dag.Directory()
+	}
+	for i, t := range targets {
+		result.Timings = append(result.Timings, timings[i])
+		if errs[i] != nil {
+			result.Failures = append(result.Failures, newTargetFailure(t, failureStageCompile, errs[i]))
+			continue
+		}
+		result.Targets = append(result.Targets, t)
+		result.Binaries = result.Binaries.WithFile(t.BinaryName(), binaries[i])
+		if opts.DebugSymbols {
+			result.DebugSymbols = result.DebugSymbols.WithDirectory(t.BinaryName(), debugSymbols[i])
 		}
 	}
 
-	return out, nil
+	return result, nil
 }
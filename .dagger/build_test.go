@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingStages counts the calls of each compile stage, without running the toolchains.
+type recordingStages struct {
+	mu       sync.Mutex
+	proto    int
+	frontend int
+	// Calls by BuildMatrix.BinaryName.
+	targets map[string]int
+	// Errors returned by Target, by BuildMatrix.BinaryName.
+	fail map[string]error
+}
+
+func newRecordingStages() *recordingStages {
+	return &recordingStages{targets: map[string]int{}, fail: map[string]error{}}
+}
+
+func (s *recordingStages) GenerateProto(source *dagger.Directory, bufImage string) *dagger.Directory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proto++
+	return source
+}
+
+func (s *recordingStages) Frontend(ctx context.Context, source *dagger.Directory, nodeImage string, opts buildOptions) (*dagger.Directory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frontend++
+	return dag.Directory(), nil
+}
+
+func (s *recordingStages) Target(ctx context.Context, ctr *dagger.Container, t BuildMatrix, args []string) (*dagger.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets[t.BinaryName()]++
+	return ctr, s.fail[t.BinaryName()]
+}
+
+// testPreparedSource returns a prepared source that is never evaluated.
+func testPreparedSource() *preparedSource {
+	return &preparedSource{
+		Src:            dag.Directory(),
+		BuildVersion:   "0.25.3",
+		ReleaseVersion: "v0.25.3",
+		Commit:         strings.Repeat("a", 40),
+		Toolchain: &Toolchain{
+			Go:   toolchainImage{Image: "golang:1.26.2-alpine"},
+			Node: toolchainImage{Image: "node:24-alpine"},
+			Buf:  toolchainImage{Image: "bufbuild/buf:1.70.0"},
+		},
+	}
+}
+
+// nonWindowsTargets returns TARGETS without Windows, whose resources are read from the source.
+func nonWindowsTargets() []BuildMatrix {
+	return slices.DeleteFunc(slices.Clone(TARGETS), func(t BuildMatrix) bool { return t.OS == "windows" })
+}
+
+// Build, Publish and BuildContainers all compile through compile, and every later stage
+// (archives, packages, containers) works off its result.
+func TestCompileRunsEachStageOncePerTarget(t *testing.T) {
+	stages := newRecordingStages()
+	m := &MemosBuilds{stages: stages}
+	targets := nonWindowsTargets()
+
+	build, err := m.compile(context.Background(), testPreparedSource(), targets, buildOptions{Headless: true, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stages.proto != 1 {
+		t.Errorf("generateProto ran %d times, want 1", stages.proto)
+	}
+	if stages.frontend != 1 {
+		t.Errorf("frontend stage ran %d times, want 1", stages.frontend)
+	}
+	if len(stages.targets) != len(targets) {
+		t.Errorf("compiled %d targets, want %d", len(stages.targets), len(targets))
+	}
+	for _, target := range targets {
+		if n := stages.targets[target.BinaryName()]; n != 1 {
+			t.Errorf("%s compiled %d times, want 1", target.BinaryName(), n)
+		}
+	}
+	if !slices.Equal(build.Targets, targets) {
+		t.Errorf("built targets %v, want %v", build.Targets, targets)
+	}
+}
+
+func TestCompileKeepGoingRecordsFailedTargets(t *testing.T) {
+	stages := newRecordingStages()
+	m := &MemosBuilds{stages: stages}
+	targets := nonWindowsTargets()
+	failing := targets[1]
+	stages.fail[failing.BinaryName()] = errors.New("compiler crashed")
+
+	build, err := m.compile(context.Background(), testPreparedSource(), targets, buildOptions{Headless: true, KeepGoing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(build.Targets, failing) || len(build.Targets) != len(targets)-1 {
+		t.Errorf("built targets %v, want all but %s", build.Targets, failing.BinaryName())
+	}
+	if len(build.Failures) != 1 || build.Failures[0].Stage != failureStageCompile {
+		t.Fatalf("failures = %+v, want one at stage %s", build.Failures, failureStageCompile)
+	}
+	for _, target := range targets {
+		if n := stages.targets[target.BinaryName()]; n != 1 {
+			t.Errorf("%s compiled %d times, want 1", target.BinaryName(), n)
+		}
+	}
+
+	if _, err := m.compile(context.Background(), testPreparedSource(), targets, buildOptions{Headless: true}); err == nil {
+		t.Error("compile succeeded with a failing target outside keep-going mode")
+	}
+}
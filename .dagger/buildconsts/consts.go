@@ -15,17 +15,59 @@ const PRIMARY_IMAGE string = "alpine:3.24.0"
 // Note: The uclibc variant is smaller, but has issues with timezones.
 const ALTERNATE_IMAGE string = "arm32v5/busybox:1.38.0-glibc"
 
-// Container image to use for the Go build.
+// Container image to use for the Go build,
+// when the upstream go.mod declares neither `go` nor `toolchain`.
 const GOLANG_BUILD_IMAGE string = "golang:1.26.2-alpine"
 
-// Container image to use for frontend builds.
+// Go build image for a version detected from the upstream go.mod.
+const GOLANG_IMAGE_FORMAT string = "golang:%s-alpine"
+
+// Container image to use for frontend builds,
+// when the upstream web/package.json declares no `engines.node`.
 const NODE_BUILD_IMAGE string = "node:24-alpine"
 
-// Container image to use for proto builds.
+// Frontend build image for a Node.js major version.
+const NODE_IMAGE_FORMAT string = "node:%d-alpine"
+
+// Node.js major versions to pick from when matching `engines.node`, newest first.
+var NODE_MAJOR_VERSIONS = []int{24, 22, 20, 18}
+
+// Container image to use for proto builds,
+// when the upstream source has no proto/buf.yaml.
 const BUF_IMAGE string = "bufbuild/buf:1.70.0"
 
-// Passed to `go mod tidy`.
-const GO_VERSION string = "1.26.2"
+// Proto build image for a buf CLI version.
+const BUF_IMAGE_FORMAT string = "bufbuild/buf:%s"
+
+// buf CLI version for each pair of proto/buf.yaml and proto/buf.gen.yaml configuration
+// versions, as "<buf.yaml>/<buf.gen.yaml>". v1 configurations keep the last release
+// before v2 configurations (1.32.0), the one upstream generated them with.
+var BUF_VERSIONS = map[string]string{
+	"v1beta1/v1beta1": "1.31.0",
+	"v1beta1/v1":      "1.31.0",
+	"v1/v1beta1":      "1.31.0",
+	"v1/v1":           "1.31.0",
+	"v1/v2":           "1.70.0",
+	"v2/v1":           "1.70.0",
+	"v2/v2":           "1.70.0",
+}
+
+// Container image generating the APT repository, and installing from it in tests.
+const DEBIAN_IMAGE string = "debian:13-slim"
+
+// Container image generating the RPM repository, and installing from it in tests.
+const FEDORA_IMAGE string = "fedora:43"
+
+// WinSW release shipped as memos-service.exe in the Windows service archives.
+// The .NET Framework 4.6.1 build runs on every supported Windows release without extra runtimes.
+const WINSW_URL string = "https://github.com/winsw/winsw/releases/download/v2.12.0/WinSW-net461.exe"
+
+// RFC 3161 timestamping service of Authenticode signatures, unless overridden.
+const AUTHENTICODE_TIMESTAMP_URL string = "http://timestamp.digicert.com"
+
+// Go Cryptographic Module snapshot selected with GOFIPS140 for FIPS builds.
+// v1.0.0 is the validated module shipped with Go 1.24 and newer.
+const FIPS_MODULE_VERSION string = "v1.0.0"
 
 // Where the semantic version is defined in the source code.
 const VERSION_FILE string = "internal/version/version.go"
@@ -39,5 +81,53 @@ const COMMIT_IMPORT_PATH string = "github.com/usememos/memos/internal/version.Co
 // Passed to `go build` as the entrypoint of the application.
 const APP_ENTRYPOINT string = "./cmd/memos"
 
+// Module path of the upstream source, whose packages are instrumented in coverage builds.
+const APP_MODULE_PATH string = "github.com/usememos/memos"
+
 // String format for the checksum file.
 const CHECKSUM_FILE_FORMAT string = "memos-%s_SHA256SUMS.txt"
+
+// String format for the checksum file of the debug archives.
+const DEBUG_CHECKSUM_FILE_FORMAT string = "memos-%s_debug_SHA256SUMS.txt"
+
+// String format for the frontend source maps archive.
+const SOURCE_MAPS_ARCHIVE_FORMAT string = "memos-%s-web-debug.tar.gz"
+
+// String format for the dependency drift report.
+const DEPENDENCY_DRIFT_FILE_FORMAT string = "memos-%s_dependency-drift.txt"
+
+// String format for the build metadata file.
+const METADATA_FILE_FORMAT string = "memos-%s_build-metadata.json"
+
+// String format for the smoke test report.
+const SMOKE_TEST_FILE_FORMAT string = "memos-%s_smoke-tests.txt"
+
+// String format for the hardening check report.
+const HARDENING_FILE_FORMAT string = "memos-%s_hardening.txt"
+
+// String format for the JUnit report of the upstream tests on a platform (e.g. "linux-s390x").
+const TEST_REPORT_FILE_FORMAT string = "memos-%s_tests-%s.junit.xml"
+
+// String format for the summary of failed upstream tests.
+const TEST_FAILURES_FILE_FORMAT string = "memos-%s_test-failures.txt"
+
+// String format for the binary and archive sizes, comparable with later builds.
+const SIZES_FILE_FORMAT string = "memos-%s_sizes.json"
+
+// String format for the size comparison with the baseline.
+const SIZE_REPORT_FILE_FORMAT string = "memos-%s_sizes.txt"
+
+// String format for the keep-going failure summary.
+const FAILURES_FILE_FORMAT string = "memos-%s_build-failures.txt"
+
+// String format for the raw coverage data directory (GOCOVERDIR contents).
+const COVDATA_DIR_FORMAT string = "memos-%s_covdata"
+
+// String format for the coverage profile, in the `go test -coverprofile` format.
+const COVERAGE_PROFILE_FILE_FORMAT string = "memos-%s_coverage.out"
+
+// String format for the per-package and per-function coverage report.
+const COVERAGE_REPORT_FILE_FORMAT string = "memos-%s_coverage.txt"
+
+// String format for the annotated source coverage report.
+const COVERAGE_HTML_FILE_FORMAT string = "memos-%s_coverage.html"
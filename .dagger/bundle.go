@@ -0,0 +1,353 @@
+// # Release archive contents.
+//
+// Files shipped next to the binary in release archives: the license, a README,
+// a configuration example and service definitions for the target OS.
+//
+// The configuration example, the systemd, OpenRC and WinSW definitions and the firewall
+// rule of the Windows service scripts are extracted from `docs/`, so the archives never
+// drift from the guides.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// Guides the bundled files are extracted from, relative to the repository root.
+const (
+	configurationGuide  = "docs/configuration.md"
+	linuxServiceGuide   = "docs/service-linux.md"
+	windowsServiceGuide = "docs/service-windows.md"
+)
+
+// FreeBSD rc.d script, configured from rc.conf.
+const freebsdRcScript = `#!/bin/sh
+#
+# PROVIDE: memos
+# REQUIRE: LOGIN NETWORKING
+# KEYWORD: shutdown
+#
+# Add the following lines to /etc/rc.conf to enable Memos:
+#
+# memos_enable (bool):   Set to "YES" to enable Memos. Default: "NO".
+# memos_data (path):     Data directory, created on start. Default: "/var/db/memos".
+# memos_user (user):     User running the server. Default: "memos".
+# memos_port (port):     Port to listen on. Default: "5230".
+# memos_env_file (path): Optional file with more settings, e.g. "/usr/local/etc/memos/memos.env".
+# memos_env (str):       Optional extra environment, e.g. "MEMOS_ADDR=127.0.0.1".
+
+. /etc/rc.subr
+
+name="memos"
+rcvar="memos_enable"
+
+load_rc_config $name
+
+: ${memos_enable:="NO"}
+: ${memos_data:="/var/db/memos"}
+: ${memos_user:="memos"}
+: ${memos_port:="5230"}
+
+# rc.subr starts the command as memos_user with su(1), so daemon(8) runs unprivileged:
+# it cannot switch users itself, and writes its pidfile to a directory owned by that user.
+piddir="/var/run/${name}"
+pidfile="${piddir}/${name}.pid"
+procname="/usr/local/bin/memos"
+command="/usr/sbin/daemon"
+command_args="-f -r -R 5 -P ${pidfile} ${procname}"
+memos_env="${memos_env:+${memos_env} }MEMOS_DATA=${memos_data} MEMOS_PORT=${memos_port}"
+
+start_precmd="memos_prestart"
+
+memos_prestart()
+{
+	install -d -o "${memos_user}" -m 0750 "${memos_data}"
+	install -d -o "${memos_user}" -m 0755 "${piddir}"
+}
+
+run_rc_command "$1"
+`
+
+// launchd property list, installed as a system daemon.
+const launchdPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>com.usememos.memos</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>/usr/local/bin/memos</string>
+    </array>
+    <key>EnvironmentVariables</key>
+    <dict>
+        <key>MEMOS_PORT</key>
+        <string>5230</string>
+        <key>MEMOS_DATA</key>
+        <string>/usr/local/var/memos</string>
+    </dict>
+    <!-- Runs as root unless a user owning MEMOS_DATA is set:
+    <key>UserName</key>
+    <string>memos</string>
+    -->
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/usr/local/var/log/memos.log</string>
+    <key>StandardErrorPath</key>
+    <string>/usr/local/var/log/memos.log</string>
+</dict>
+</plist>
+`
+
+// bundledFile is a file shipped next to the binary.
+type bundledFile struct {
+	Name        string
+	Contents    string
+	Permissions int
+	// One-line summary listed in the README.
+	Description string
+}
+
+// archiveBundle holds the files shipped in release archives, shared by every target.
+type archiveBundle struct {
+	// Upstream license.
+	License *dagger.File
+	// Configuration example, with every setting commented out.
+	EnvExample   string
+	SystemdUnit  string
+	OpenRCScript string
+	WinSWConfig  string
+	// `New-NetFirewallRule` command allowing memos.exe through Windows Firewall.
+	FirewallRule string
+}
+
+// loadArchiveBundle extracts the bundled files from the guides in `docs/`.
+func (m *MemosBuilds) loadArchiveBundle(
+	ctx context.Context,
+	source *dagger.Directory,
+	prepared *preparedSource,
+) (*archiveBundle, error) {
+	if ok, _ := prepared.Src.Exists(ctx, "LICENSE", dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType}); !ok {
+		return nil, fmt.Errorf("upstream source has no LICENSE file")
+	}
+
+	guides := map[string]string{}
+	for _, name := range []string{configurationGuide, linuxServiceGuide, windowsServiceGuide} {
+		contents, err := source.File(name).Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		guides[name] = contents
+	}
+
+	env, err := fencedBlock(guides[configurationGuide], "## Environment variables", "sh")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", configurationGuide, err)
+	}
+	systemd, err := heredoc(guides[linuxServiceGuide], "tee /etc/systemd/system/memos.service")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", linuxServiceGuide, err)
+	}
+	openrc, err := heredoc(guides[linuxServiceGuide], "tee /etc/init.d/memos")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", linuxServiceGuide, err)
+	}
+	winsw, err := fencedBlock(guides[windowsServiceGuide], "### 2. Using [WinSW]", "xml")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", windowsServiceGuide, err)
+	}
+
+	firewall, err := fencedBlock(guides[windowsServiceGuide], "If you want the service to be reachable", "powershell")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", windowsServiceGuide, err)
+	}
+	rule, err := commandLine(firewall, "New-NetFirewallRule")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", windowsServiceGuide, err)
+	}
+
+	return &archiveBundle{
+		License:      prepared.Src.File("LICENSE"),
+		EnvExample:   envExample(env),
+		SystemdUnit:  systemd,
+		OpenRCScript: openrc,
+		WinSWConfig:  winsw,
+		FirewallRule: rule,
+	}, nil
+}
+
+// fencedBlock returns the first fenced code block of the given language after a heading.
+func fencedBlock(markdown, heading, lang string) (string, error) {
+	_, section, ok := strings.Cut(markdown, "\n"+heading)
+	if !ok {
+		return "", fmt.Errorf("no %q section", heading)
+	}
+	_, block, ok := strings.Cut(section, "```"+lang+"\n")
+	if !ok {
+		return "", fmt.Errorf("no %s code block under %q", lang, heading)
+	}
+	block, _, ok = strings.Cut(block, "```")
+	if !ok {
+		return "", fmt.Errorf("unterminated %s code block under %q", lang, heading)
+	}
+	return block, nil
+}
+
+// heredoc returns the body of the `<command> <<EOF` heredoc of a shell snippet.
+func heredoc(markdown, command string) (string, error) {
+	_, body, ok := strings.Cut(markdown, command+" <<EOF\n")
+	if !ok {
+		return "", fmt.Errorf("no %q heredoc", command+" <<EOF")
+	}
+	body, _, ok = strings.Cut(body, "\nEOF\n")
+	if !ok {
+		return "", fmt.Errorf("unterminated %q heredoc", command)
+	}
+	return body + "\n", nil
+}
+
+// commandLine returns the first line of a snippet running a command.
+func commandLine(snippet, command string) (string, error) {
+	for line := range strings.Lines(snippet) {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, command+" ") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no %q command", command)
+}
+
+// envExample turns the documented environment variables into a commented `memos.env` example.
+func envExample(documented string) string {
+	var b strings.Builder
+	b.WriteString("# Memos configuration.\n")
+	b.WriteString("#\n")
+	b.WriteString("# Uncomment a setting to override its default. Read by the bundled service files;\n")
+	b.WriteString("# see README.md for where to install it. Generated from " + configurationGuide + ".\n")
+	b.WriteString("# All options: https://usememos.com/docs/configuration\n")
+	b.WriteString("\n")
+	for line := range strings.Lines(documented) {
+		line = strings.TrimRight(line, "\n")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = "#" + line
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// ServiceFiles returns the service definitions for the OS of a target.
+func (b *archiveBundle) ServiceFiles(t BuildMatrix) []bundledFile {
+	switch t.OS {
+	case "linux":
+		return []bundledFile{
+			{Name: "memos.service", Contents: b.SystemdUnit, Permissions: 0644, Description: "systemd unit"},
+			{Name: "memos.openrc", Contents: b.OpenRCScript, Permissions: 0755, Description: "OpenRC script"},
+		}
+	case "freebsd":
+		return []bundledFile{
+			{Name: "memos.rc", Contents: freebsdRcScript, Permissions: 0755, Description: "rc.d script"},
+		}
+	case "darwin":
+		return []bundledFile{
+			{Name: "com.usememos.memos.plist", Contents: launchdPlist, Permissions: 0644, Description: "launchd daemon"},
+		}
+	case "windows":
+		return []bundledFile{
+			{Name: "memos-service.xml", Contents: b.WinSWConfig, Permissions: 0644, Description: "WinSW service configuration"},
+		}
+	}
+	return nil
+}
+
+// Contents returns the files of a target's release archive, without the top-level directory.
+func (b *archiveBundle) Contents(t BuildMatrix, version string, binary *dagger.File) *dagger.Directory {
+	binaryName := "memos"
+	if t.OS == "windows" {
+		binaryName = "memos.exe"
+	}
+	files := append([]bundledFile{
+		{Name: "memos.env", Contents: b.EnvExample, Permissions: 0644, Description: "configuration example, every setting commented out"},
+	}, b.ServiceFiles(t)...)
+
+	dir := dag.Directory().
+		WithFile(binaryName, binary, dagger.DirectoryWithFileOpts{Permissions: 0755}).
+		WithFile("LICENSE", b.License, dagger.DirectoryWithFileOpts{Permissions: 0644}).
+		WithNewFile("README.md", archiveReadme(t, version, binaryName, files), dagger.DirectoryWithNewFileOpts{Permissions: 0644})
+	for _, f := range files {
+		dir = dir.WithNewFile(f.Name, f.Contents, dagger.DirectoryWithNewFileOpts{Permissions: f.Permissions})
+	}
+	return dir
+}
+
+// archiveReadme returns the README of a target's release archive.
+func archiveReadme(t BuildMatrix, version, binaryName string, files []bundledFile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Memos %s (%s)\n\n", version, t.DockerPlatform())
+	b.WriteString("A privacy-first, lightweight note-taking service. <https://usememos.com>\n\n")
+	b.WriteString("Built by memos-builds: <https://github.com/memospot/memos-builds>\n\n")
+
+	b.WriteString("## Contents\n\n")
+	fmt.Fprintf(&b, "- `%s`: server binary.\n", binaryName)
+	b.WriteString("- `LICENSE`: Memos license.\n")
+	for _, f := range files {
+		fmt.Fprintf(&b, "- `%s`: %s.\n", f.Name, f.Description)
+	}
+
+	b.WriteString("\n## Quick start\n\n")
+	switch t.OS {
+	case "windows":
+		b.WriteString("```powershell\n.\\memos.exe --port 5230\n```\n\n")
+	default:
+		b.WriteString("```sh\n./memos --port 5230 --data ./data\n```\n\n")
+	}
+	b.WriteString("Then open <http://localhost:5230>.\n\n")
+
+	b.WriteString("## Running as a service\n\n")
+	switch t.OS {
+	case "linux":
+		b.WriteString("Create a `memos` user and the `/var/opt/memos` data directory as shown in the\n")
+		b.WriteString("[Linux service guide](https://github.com/memospot/memos-builds/blob/main/docs/service-linux.md), then:\n\n")
+		b.WriteString("```sh\n")
+		b.WriteString("sudo install -m 0755 memos /usr/local/bin/memos\n")
+		b.WriteString("sudo install -D -m 0640 memos.env /etc/memos/memos.env\n")
+		b.WriteString("# systemd\n")
+		b.WriteString("sudo install -m 0644 memos.service /etc/systemd/system/memos.service\n")
+		b.WriteString("sudo systemctl daemon-reload && sudo systemctl enable --now memos\n")
+		b.WriteString("# OpenRC\n")
+		b.WriteString("sudo install -m 0755 memos.openrc /etc/init.d/memos\n")
+		b.WriteString("sudo rc-update add memos && sudo rc-service memos start\n")
+		b.WriteString("```\n")
+	case "freebsd":
+		b.WriteString("```sh\n")
+		b.WriteString("install -m 0755 memos /usr/local/bin/memos\n")
+		b.WriteString("install -m 0555 memos.rc /usr/local/etc/rc.d/memos\n")
+		b.WriteString("pw useradd memos -d /var/db/memos -s /usr/sbin/nologin\n")
+		b.WriteString("sysrc memos_enable=YES\n")
+		b.WriteString("service memos start\n")
+		b.WriteString("```\n\n")
+		b.WriteString("The script reads `memos_data`, `memos_user`, `memos_port` and `memos_env_file` from rc.conf.\n")
+		b.WriteString("To use `memos.env`, install it as `/usr/local/etc/memos/memos.env` and set `memos_env_file` to that path.\n")
+		fmt.Fprintf(&b, "\nAlternatively, `pkg add %s`, released alongside this archive, sets all of this up.\n", t.PackageName(version, ".pkg"))
+	case "darwin":
+		b.WriteString("```sh\n")
+		b.WriteString("sudo install -m 0755 memos /usr/local/bin/memos\n")
+		b.WriteString("sudo mkdir -p /usr/local/var/memos /usr/local/var/log\n")
+		b.WriteString("sudo install -m 0644 com.usememos.memos.plist /Library/LaunchDaemons/\n")
+		b.WriteString("sudo launchctl bootstrap system /Library/LaunchDaemons/com.usememos.memos.plist\n")
+		b.WriteString("```\n\n")
+		b.WriteString("launchd does not read `memos.env`; add settings to the `EnvironmentVariables` of the plist instead.\n")
+	case "windows":
+		b.WriteString("Download `WinSW-net461.exe` from <https://github.com/winsw/winsw/releases/latest>,\n")
+		b.WriteString("save it as `memos-service.exe` next to `memos.exe` and `memos-service.xml`, then, as an administrator:\n\n")
+		b.WriteString("```powershell\n.\\memos-service.exe install\n.\\memos-service.exe start\n```\n\n")
+		b.WriteString("WinSW does not read `memos.env`; add settings as `<env>` entries of `memos-service.xml` instead.\n")
+		b.WriteString("See the [Windows service guide](https://github.com/memospot/memos-builds/blob/main/docs/service-windows.md).\n")
+		fmt.Fprintf(&b, "\nAlternatively, `%s`, released alongside this archive, bundles WinSW with install scripts.\n", t.ServiceArchiveName(version))
+	}
+	return b.String()
+}
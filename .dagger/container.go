@@ -6,8 +6,12 @@ import (
 	"dagger/memos-builds/buildconsts"
 	"dagger/memos-builds/internal/dagger"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // addContainerAnnotations adds OCI labels to a container.
@@ -44,25 +48,36 @@ func (m *MemosBuilds) addContainerEnv(c *dagger.Container) *dagger.Container {
 
 // buildContainer creates a container for a specific platform using the built binary.
 func (m *MemosBuilds) buildContainer(
+	ctx context.Context,
 	// The specific binary file for this platform
 	binary *dagger.File,
 	// Platform string (e.g. linux/amd64, linux/arm/v5)
 	platform string,
 	source *dagger.Directory,
-) *dagger.Container {
-	// ARMv5 requires special handling:
-	// 	- It's only supported on BusyBox.
-	// 	- BusyBox lacks package manager, tz-data and needs a pre-built su-exec.
+	// Version profile environment defaults
+	profileEnv map[string]string,
+	retry retryPolicy,
+) (*dagger.Container, error) {
+	var ctr *dagger.Container
 	if platform == "linux/arm/v5" {
-		ctr := m.buildBusyBoxARMv5Container(binary, platform, source)
-		ctr = m.ensurePlatformVariant(ctr, platform)
-		return m.addContainerAnnotations(ctr)
+		// ARMv5 requires special handling:
+		// 	- It's only supported on BusyBox.
+		// 	- BusyBox lacks package manager, tz-data and needs a pre-built su-exec.
+		ctr = m.buildBusyBoxARMv5Container(binary, platform, source)
+	} else {
+		// All other platforms use a standard Alpine-based container.
+		var err error
+		ctr, err = m.buildAlpineContainer(ctx, binary, platform, source, retry)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// All other platforms use a standard Alpine-based container.
-	ctr := m.buildAlpineContainer(binary, platform, source)
+	for _, k := range slices.Sorted(maps.Keys(profileEnv)) {
+		ctr = ctr.WithEnvVariable(k, profileEnv[k])
+	}
 	ctr = m.ensurePlatformVariant(ctr, platform)
-	return m.addContainerAnnotations(ctr)
+	return m.addContainerAnnotations(ctr), nil
 }
 
 // ensurePlatformVariant preserves amd64 microarchitecture variants.
@@ -84,18 +99,32 @@ func (m *MemosBuilds) ensurePlatformVariant(
 }
 
 // buildAlpineContainer creates an Alpine-based container.
+//
+// Packages are installed first, with retries, as it is the only step that needs the network.
 func (m *MemosBuilds) buildAlpineContainer(
+	ctx context.Context,
 	binary *dagger.File,
 	platform string,
 	source *dagger.Directory,
-) *dagger.Container {
+	retry retryPolicy,
+) (*dagger.Container, error) {
 
 	entrypoint := source.Directory("container").File("entrypoint.sh")
 	newFilePerms := dagger.ContainerWithFileOpts{Permissions: 0755}
 
-	return dag.Container(dagger.ContainerOpts{Platform: dagger.Platform(platform)}).
-		From(buildconsts.PRIMARY_IMAGE).
-		WithExec([]string{"apk", "add", "--no-cache", "tzdata", "ca-certificates", "su-exec"}).
+	var base *dagger.Container
+	err := retry.Do(ctx, "apk add ("+platform+")", func() (err error) {
+		base, err = dag.Container(dagger.ContainerOpts{Platform: dagger.Platform(platform)}).
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "tzdata", "ca-certificates", "su-exec"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to install container packages for %s: %w", platform, err)
+	}
+
+	return base.
 		WithDirectory("/var/opt/memos", dag.Directory()).
 		WithWorkdir("/usr/local/bin").
 		WithFile("/usr/local/bin/memos", binary, newFilePerms).
@@ -108,7 +137,7 @@ func (m *MemosBuilds) buildAlpineContainer(
 		WithExposedPort(5230).
 		WithUser("root").
 		WithEntrypoint([]string{"/init"}).
-		WithDefaultArgs([]string{"/usr/local/bin/memos"})
+		WithDefaultArgs([]string{"/usr/local/bin/memos"}), nil
 }
 
 // buildBusyBoxARMv5Container creates a BusyBox-based container.
@@ -159,33 +188,28 @@ func (m *MemosBuilds) buildBusyBoxARMv5Container(
 		WithDefaultArgs([]string{"/usr/local/bin/memos"})
 }
 
-// buildContainers creates multiple containers for the specified targets.
-// It shares the build logic (proto, frontend, backend) to avoid redundancy.
-func (m *MemosBuilds) buildContainers(
+// assembleContainers creates a container for each target from already-compiled binaries.
+func (m *MemosBuilds) assembleContainers(
 	ctx context.Context,
 	source *dagger.Directory,
-	gitSrc *dagger.Directory,
-	version string,
-	commit string,
+	build *buildResult,
 	targets []BuildMatrix,
+	retry retryPolicy,
 ) ([]*dagger.Container, error) {
-	// 1. Generate proto and build frontend (shared across all targets)
-	gitSrc = m.generateProto(gitSrc)
-	frontendDist := m.buildFrontend(gitSrc)
-
-	// 3. Build backend binaries for all requested targets
-	binaries, err := m.buildBackend(ctx, gitSrc, frontendDist, version, commit, targets)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build binaries: %w", err)
+	containers := make([]*dagger.Container, len(targets))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, t := range targets {
+		g.Go(func() (err error) {
+			binary := build.Binaries.File(t.BinaryName())
+			containers[i], err = m.buildContainer(gctx, binary, t.DockerPlatform(), source, build.Prepared.ContainerEnv(), retry)
+			if err == nil && build.Prepared.Coverage {
+				containers[i] = withCoverageDir(containers[i])
+			}
+			return err
+		})
 	}
-
-	// 4. Create container instances for each target
-	var containers []*dagger.Container
-	for _, t := range targets {
-		binary := binaries.File(t.BinaryName())
-		ctr := m.buildContainer(binary, t.DockerPlatform(), source)
-		containers = append(containers, ctr)
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-
 	return containers, nil
 }
@@ -0,0 +1,219 @@
+// # Coverage builds.
+//
+// Builds instrumented with `go build -cover` for end-to-end tests, and collection of
+// their coverage data under a scripted workload.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Label added to artifact names and image tags of coverage builds.
+const coverageLabel = "coverage"
+
+// Where coverage images write their data, through GOCOVERDIR.
+//
+// World-writable, as the entrypoint drops privileges to a configurable PUID.
+const coverageDir = "/var/opt/memos-coverage"
+
+// User the image entrypoint runs Memos as by default (PUID:PGID).
+const coverageOwner = "10001:10001"
+
+// coverageBuildFlags returns the `go build` flags instrumenting the upstream packages.
+//
+// Dependencies are left out, so reports only cover code maintained upstream.
+func coverageBuildFlags() []string {
+	return []string{
+		"-cover",
+		"-covermode=atomic",
+		"-coverpkg=" + buildconsts.APP_MODULE_PATH + "/...",
+	}
+}
+
+// withCoverageDir prepares a container image to collect coverage data.
+func withCoverageDir(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithExec([]string{"mkdir", "-p", "-m", "1777", coverageDir}).
+		WithEnvVariable("GOCOVERDIR", coverageDir)
+}
+
+// coverageReport converts raw coverage data to text and HTML reports.
+//
+// The upstream source is needed to render the HTML report.
+func (m *MemosBuilds) coverageReport(
+	ctx context.Context,
+	prepared *preparedSource,
+	covdata *dagger.Directory,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
+	platform, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the engine platform: %w", err)
+	}
+	ctr, err := m.goModuleContainer(ctx, prepared, string(platform), retry)
+	if err != nil {
+		return nil, err
+	}
+
+	v := prepared.ArtifactVersion()
+	covdataDir := fmt.Sprintf(buildconsts.COVDATA_DIR_FORMAT, v)
+	profile := fmt.Sprintf(buildconsts.COVERAGE_PROFILE_FILE_FORMAT, v)
+	report := fmt.Sprintf(buildconsts.COVERAGE_REPORT_FILE_FORMAT, v)
+	html := fmt.Sprintf(buildconsts.COVERAGE_HTML_FILE_FORMAT, v)
+
+	out, err := ctr.
+		WithDirectory("/out/"+covdataDir, covdata).
+		WithEnvVariable("COVDATA", "/out/"+covdataDir).
+		WithEnvVariable("PROFILE", "/out/"+profile).
+		WithEnvVariable("REPORT", "/out/"+report).
+		WithEnvVariable("HTML", "/out/"+html).
+		WithEnvVariable("VERSION", v).
+		WithExec([]string{"sh", "-c", `
+			set -eu
+			if [ -z "$(ls -A "$COVDATA")" ]; then
+				echo "no coverage data was written" >&2
+				exit 1
+			fi
+			go tool covdata textfmt -i="$COVDATA" -o "$PROFILE"
+			{
+				echo "Coverage $VERSION"
+				echo
+				echo "Packages:"
+				go tool covdata percent -i="$COVDATA" | sed 's/^/    /'
+				echo
+				echo "Functions:"
+				go tool cover -func="$PROFILE" | sed 's/^/    /'
+			} > "$REPORT"
+			go tool cover -html="$PROFILE" -o "$HTML"
+		`}).
+		Directory("/out").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the coverage report: %w", err)
+	}
+	return out, nil
+}
+
+// CollectCoverage builds a coverage-instrumented image for the engine's platform, runs it as a
+// service under a scripted workload and returns its coverage data with text and HTML reports.
+//
+// The workload reaches the server at MEMOS_URL; any end-to-end suite packaged as a script works.
+func (m *MemosBuilds) CollectCoverage(
+	ctx context.Context,
+	source *dagger.Directory,
+	// +optional
+	version string,
+	// Workload script, run with MEMOS_URL and DURATION (seconds) set. Defaults to `pgo/workload.sh`.
+	// +optional
+	workload *dagger.File,
+	// How long the workload runs, in seconds. Defaults to 60.
+	// +optional
+	duration int,
+	// Retry policy for network-bound steps. See `build`.
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads. See `build`.
+	// +optional
+	goproxy string,
+	// Local module proxy tree. See `build`.
+	// +optional
+	goproxyDir *dagger.Directory,
+) (*dagger.Directory, error) {
+	if version == "" {
+		version = "nightly"
+	}
+	if source == nil {
+		return nil, fmt.Errorf("source directory must be passed explicitly by the user")
+	}
+	if workload == nil {
+		workload = source.File(path.Join(pgoDir, "workload.sh"))
+	}
+	if duration <= 0 {
+		duration = 60
+	}
+
+	platform, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the engine platform: %w", err)
+	}
+	targets, err := filterTargets(string(platform))
+	if err != nil {
+		return nil, fmt.Errorf("cannot collect coverage on %s: %w", platform, err)
+	}
+
+	// Headless builds keep the workload on the server; the frontend is static files.
+	opts := buildOptions{
+		Headless:   true,
+		Coverage:   true,
+		Retry:      retry,
+		GoProxy:    goproxy,
+		GoProxyDir: goproxyDir,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, err
+	}
+	build, err := m.compile(ctx, prepared, targets, opts)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := m.assembleContainers(ctx, source, build, targets, opts.RetryPolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	// A fresh volume per run, so data of previous runs is never merged in.
+	runID := fmt.Sprint(time.Now().UnixNano())
+	covdata := dag.CacheVolume("memos-coverage-" + runID)
+	svc, err := containers[0].
+		WithMountedCache(coverageDir, covdata, dagger.ContainerWithMountedCacheOpts{Owner: coverageOwner}).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true}).
+		Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the coverage build: %w", err)
+	}
+
+	var runner *dagger.Container
+	err = opts.RetryPolicy().Do(ctx, "apk add (coverage)", func() (err error) {
+		runner, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "curl"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the workload container: %w", err)
+	}
+
+	_, err = runner.
+		WithServiceBinding("memos", svc).
+		WithFile("/workload.sh", workload, dagger.ContainerWithFileOpts{Permissions: 0755}).
+		WithEnvVariable("MEMOS_URL", "http://memos:5230").
+		WithEnvVariable("DURATION", fmt.Sprint(duration)).
+		WithEnvVariable("COVERAGE_RUN", runID).
+		WithExec([]string{"/workload.sh"}).
+		Sync(ctx)
+	// Counters are written when the server exits, so it is stopped gracefully either way.
+	if _, stopErr := svc.Stop(ctx); stopErr != nil && err == nil {
+		err = fmt.Errorf("failed to stop the coverage build: %w", stopErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("coverage workload failed: %w", err)
+	}
+
+	collected := dag.Container().
+		From(buildconsts.PRIMARY_IMAGE).
+		WithMountedCache("/covdata", covdata).
+		WithEnvVariable("COVERAGE_RUN", runID).
+		WithExec([]string{"cp", "-R", "/covdata/.", "/collected"}).
+		Directory("/collected")
+	return m.coverageReport(ctx, prepared, collected, opts.RetryPolicy())
+}
@@ -219,7 +219,168 @@ func invoke(ctx context.Context, parentJSON []byte, parentName string, fnName st
 					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg platforms", err))
 				}
 			}
-			return (*MemosBuilds).Build(&parent, ctx, source, version, platforms)
+			var frontendDist *dagger.Directory
+			if inputArgs["frontendDist"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["frontendDist"]), &frontendDist)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg frontendDist", err))
+				}
+			}
+			var headless bool
+			if inputArgs["headless"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["headless"]), &headless)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg headless", err))
+				}
+			}
+			var branding *dagger.Directory
+			if inputArgs["branding"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["branding"]), &branding)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg branding", err))
+				}
+			}
+			var toolchain string
+			if inputArgs["toolchain"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["toolchain"]), &toolchain)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg toolchain", err))
+				}
+			}
+			var concurrency int
+			if inputArgs["concurrency"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["concurrency"]), &concurrency)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg concurrency", err))
+				}
+			}
+			var keepGoing bool
+			if inputArgs["keepGoing"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["keepGoing"]), &keepGoing)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg keepGoing", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			var pgo *dagger.File
+			if inputArgs["pgo"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["pgo"]), &pgo)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg pgo", err))
+				}
+			}
+			var debugSymbols bool
+			if inputArgs["debugSymbols"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["debugSymbols"]), &debugSymbols)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg debugSymbols", err))
+				}
+			}
+			var smokeTest bool
+			if inputArgs["smokeTest"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["smokeTest"]), &smokeTest)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg smokeTest", err))
+				}
+			}
+			var sizeBaseline *dagger.File
+			if inputArgs["sizeBaseline"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["sizeBaseline"]), &sizeBaseline)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg sizeBaseline", err))
+				}
+			}
+			var sizeBudget string
+			if inputArgs["sizeBudget"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["sizeBudget"]), &sizeBudget)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg sizeBudget", err))
+				}
+			}
+			var hardened bool
+			if inputArgs["hardened"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["hardened"]), &hardened)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg hardened", err))
+				}
+			}
+			var fips bool
+			if inputArgs["fips"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["fips"]), &fips)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg fips", err))
+				}
+			}
+			var coverage bool
+			if inputArgs["coverage"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["coverage"]), &coverage)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg coverage", err))
+				}
+			}
+			var packages bool
+			if inputArgs["packages"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["packages"]), &packages)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg packages", err))
+				}
+			}
+			var authenticodeCertificate *dagger.Secret
+			if inputArgs["authenticodeCertificate"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodeCertificate"]), &authenticodeCertificate)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodeCertificate", err))
+				}
+			}
+			var authenticodeKey *dagger.Secret
+			if inputArgs["authenticodeKey"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodeKey"]), &authenticodeKey)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodeKey", err))
+				}
+			}
+			var authenticodePassphrase *dagger.Secret
+			if inputArgs["authenticodePassphrase"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodePassphrase"]), &authenticodePassphrase)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodePassphrase", err))
+				}
+			}
+			var timestampUrl string
+			if inputArgs["timestampUrl"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["timestampUrl"]), &timestampUrl)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg timestampUrl", err))
+				}
+			}
+			var skipTimestamp bool
+			if inputArgs["skipTimestamp"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["skipTimestamp"]), &skipTimestamp)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg skipTimestamp", err))
+				}
+			}
+			return (*MemosBuilds).Build(&parent, ctx, source, version, platforms, frontendDist, headless, branding, toolchain, concurrency, keepGoing, retry, goproxy, goproxyDir, pgo, debugSymbols, smokeTest, sizeBaseline, sizeBudget, hardened, fips, coverage, packages, authenticodeCertificate, authenticodeKey, authenticodePassphrase, timestampUrl, skipTimestamp)
 		case "BuildContainers":
 			var parent MemosBuilds
 			err = json.Unmarshal(parentJSON, &parent)
@@ -247,7 +408,350 @@ func invoke(ctx context.Context, parentJSON []byte, parentName string, fnName st
 					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg platforms", err))
 				}
 			}
-			return (*MemosBuilds).BuildContainers(&parent, ctx, source, version, platforms)
+			var frontendDist *dagger.Directory
+			if inputArgs["frontendDist"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["frontendDist"]), &frontendDist)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg frontendDist", err))
+				}
+			}
+			var headless bool
+			if inputArgs["headless"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["headless"]), &headless)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg headless", err))
+				}
+			}
+			var branding *dagger.Directory
+			if inputArgs["branding"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["branding"]), &branding)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg branding", err))
+				}
+			}
+			var toolchain string
+			if inputArgs["toolchain"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["toolchain"]), &toolchain)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg toolchain", err))
+				}
+			}
+			var concurrency int
+			if inputArgs["concurrency"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["concurrency"]), &concurrency)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg concurrency", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			var pgo *dagger.File
+			if inputArgs["pgo"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["pgo"]), &pgo)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg pgo", err))
+				}
+			}
+			var fips bool
+			if inputArgs["fips"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["fips"]), &fips)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg fips", err))
+				}
+			}
+			var coverage bool
+			if inputArgs["coverage"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["coverage"]), &coverage)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg coverage", err))
+				}
+			}
+			return (*MemosBuilds).BuildContainers(&parent, ctx, source, version, platforms, frontendDist, headless, branding, toolchain, concurrency, retry, goproxy, goproxyDir, pgo, fips, coverage)
+		case "CollectCoverage":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var source *dagger.Directory
+			if inputArgs["source"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["source"]), &source)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg source", err))
+				}
+			}
+			var version string
+			if inputArgs["version"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["version"]), &version)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg version", err))
+				}
+			}
+			var workload *dagger.File
+			if inputArgs["workload"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["workload"]), &workload)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg workload", err))
+				}
+			}
+			var duration int
+			if inputArgs["duration"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["duration"]), &duration)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg duration", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			return (*MemosBuilds).CollectCoverage(&parent, ctx, source, version, workload, duration, retry, goproxy, goproxyDir)
+		case "CollectPgoProfile":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var source *dagger.Directory
+			if inputArgs["source"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["source"]), &source)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg source", err))
+				}
+			}
+			var version string
+			if inputArgs["version"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["version"]), &version)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg version", err))
+				}
+			}
+			var workload *dagger.File
+			if inputArgs["workload"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["workload"]), &workload)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg workload", err))
+				}
+			}
+			var duration int
+			if inputArgs["duration"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["duration"]), &duration)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg duration", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			return (*MemosBuilds).CollectPgoProfile(&parent, ctx, source, version, workload, duration, retry, goproxy, goproxyDir)
+		case "PackageRepository":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var packages []*dagger.Directory
+			if inputArgs["packages"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["packages"]), &packages)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg packages", err))
+				}
+			}
+			var signingKey *dagger.Secret
+			if inputArgs["signingKey"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["signingKey"]), &signingKey)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg signingKey", err))
+				}
+			}
+			var signingPassphrase *dagger.Secret
+			if inputArgs["signingPassphrase"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["signingPassphrase"]), &signingPassphrase)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg signingPassphrase", err))
+				}
+			}
+			var url string
+			if inputArgs["url"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["url"]), &url)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg url", err))
+				}
+			}
+			var suite string
+			if inputArgs["suite"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["suite"]), &suite)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg suite", err))
+				}
+			}
+			var skipTest bool
+			if inputArgs["skipTest"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["skipTest"]), &skipTest)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg skipTest", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			return (*MemosBuilds).PackageRepository(&parent, ctx, packages, signingKey, signingPassphrase, url, suite, skipTest, retry)
+		case "Packages":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var source *dagger.Directory
+			if inputArgs["source"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["source"]), &source)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg source", err))
+				}
+			}
+			var version string
+			if inputArgs["version"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["version"]), &version)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg version", err))
+				}
+			}
+			var platforms string
+			if inputArgs["platforms"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["platforms"]), &platforms)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg platforms", err))
+				}
+			}
+			var frontendDist *dagger.Directory
+			if inputArgs["frontendDist"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["frontendDist"]), &frontendDist)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg frontendDist", err))
+				}
+			}
+			var headless bool
+			if inputArgs["headless"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["headless"]), &headless)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg headless", err))
+				}
+			}
+			var branding *dagger.Directory
+			if inputArgs["branding"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["branding"]), &branding)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg branding", err))
+				}
+			}
+			var toolchain string
+			if inputArgs["toolchain"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["toolchain"]), &toolchain)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg toolchain", err))
+				}
+			}
+			var concurrency int
+			if inputArgs["concurrency"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["concurrency"]), &concurrency)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg concurrency", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			var pgo *dagger.File
+			if inputArgs["pgo"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["pgo"]), &pgo)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg pgo", err))
+				}
+			}
+			var fips bool
+			if inputArgs["fips"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["fips"]), &fips)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg fips", err))
+				}
+			}
+			return (*MemosBuilds).Packages(&parent, ctx, source, version, platforms, frontendDist, headless, branding, toolchain, concurrency, retry, goproxy, goproxyDir, pgo, fips)
 		case "Publish":
 			var parent MemosBuilds
 			err = json.Unmarshal(parentJSON, &parent)
@@ -296,7 +800,252 @@ func invoke(ctx context.Context, parentJSON []byte, parentName string, fnName st
 					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg ghcrPassword", err))
 				}
 			}
-			return (*MemosBuilds).Publish(&parent, ctx, source, version, dockerHubUser, dockerHubPassword, ghcrUser, ghcrPassword)
+			var branding *dagger.Directory
+			if inputArgs["branding"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["branding"]), &branding)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg branding", err))
+				}
+			}
+			var toolchain string
+			if inputArgs["toolchain"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["toolchain"]), &toolchain)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg toolchain", err))
+				}
+			}
+			var concurrency int
+			if inputArgs["concurrency"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["concurrency"]), &concurrency)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg concurrency", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			var pgo *dagger.File
+			if inputArgs["pgo"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["pgo"]), &pgo)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg pgo", err))
+				}
+			}
+			var debugSymbols bool
+			if inputArgs["debugSymbols"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["debugSymbols"]), &debugSymbols)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg debugSymbols", err))
+				}
+			}
+			var smokeTest bool
+			if inputArgs["smokeTest"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["smokeTest"]), &smokeTest)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg smokeTest", err))
+				}
+			}
+			var sizeBaseline *dagger.File
+			if inputArgs["sizeBaseline"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["sizeBaseline"]), &sizeBaseline)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg sizeBaseline", err))
+				}
+			}
+			var sizeBudget string
+			if inputArgs["sizeBudget"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["sizeBudget"]), &sizeBudget)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg sizeBudget", err))
+				}
+			}
+			var hardened bool
+			if inputArgs["hardened"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["hardened"]), &hardened)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg hardened", err))
+				}
+			}
+			var fips bool
+			if inputArgs["fips"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["fips"]), &fips)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg fips", err))
+				}
+			}
+			var coverage bool
+			if inputArgs["coverage"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["coverage"]), &coverage)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg coverage", err))
+				}
+			}
+			var packages bool
+			if inputArgs["packages"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["packages"]), &packages)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg packages", err))
+				}
+			}
+			var authenticodeCertificate *dagger.Secret
+			if inputArgs["authenticodeCertificate"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodeCertificate"]), &authenticodeCertificate)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodeCertificate", err))
+				}
+			}
+			var authenticodeKey *dagger.Secret
+			if inputArgs["authenticodeKey"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodeKey"]), &authenticodeKey)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodeKey", err))
+				}
+			}
+			var authenticodePassphrase *dagger.Secret
+			if inputArgs["authenticodePassphrase"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["authenticodePassphrase"]), &authenticodePassphrase)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg authenticodePassphrase", err))
+				}
+			}
+			var timestampUrl string
+			if inputArgs["timestampUrl"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["timestampUrl"]), &timestampUrl)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg timestampUrl", err))
+				}
+			}
+			var skipTimestamp bool
+			if inputArgs["skipTimestamp"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["skipTimestamp"]), &skipTimestamp)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg skipTimestamp", err))
+				}
+			}
+			var test bool
+			if inputArgs["test"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["test"]), &test)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg test", err))
+				}
+			}
+			return (*MemosBuilds).Publish(&parent, ctx, source, version, dockerHubUser, dockerHubPassword, ghcrUser, ghcrPassword, branding, toolchain, concurrency, retry, goproxy, goproxyDir, pgo, debugSymbols, smokeTest, sizeBaseline, sizeBudget, hardened, fips, coverage, packages, authenticodeCertificate, authenticodeKey, authenticodePassphrase, timestampUrl, skipTimestamp, test)
+		case "Test":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var source *dagger.Directory
+			if inputArgs["source"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["source"]), &source)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg source", err))
+				}
+			}
+			var version string
+			if inputArgs["version"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["version"]), &version)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg version", err))
+				}
+			}
+			var platforms string
+			if inputArgs["platforms"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["platforms"]), &platforms)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg platforms", err))
+				}
+			}
+			var toolchain string
+			if inputArgs["toolchain"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["toolchain"]), &toolchain)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg toolchain", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			return (*MemosBuilds).Test(&parent, ctx, source, version, platforms, toolchain, retry, goproxy, goproxyDir)
+		case "UpdateSqliteLibcMap":
+			var parent MemosBuilds
+			err = json.Unmarshal(parentJSON, &parent)
+			if err != nil {
+				panic(fmt.Errorf("%s: %w", "failed to unmarshal parent object", err))
+			}
+			var source *dagger.Directory
+			if inputArgs["source"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["source"]), &source)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg source", err))
+				}
+			}
+			var versions string
+			if inputArgs["versions"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["versions"]), &versions)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg versions", err))
+				}
+			}
+			var goproxy string
+			if inputArgs["goproxy"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxy"]), &goproxy)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxy", err))
+				}
+			}
+			var goproxyDir *dagger.Directory
+			if inputArgs["goproxyDir"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["goproxyDir"]), &goproxyDir)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg goproxyDir", err))
+				}
+			}
+			var retry string
+			if inputArgs["retry"] != nil {
+				err = json.Unmarshal([]byte(inputArgs["retry"]), &retry)
+				if err != nil {
+					panic(fmt.Errorf("%s: %w", "failed to unmarshal input arg retry", err))
+				}
+			}
+			return (*MemosBuilds).UpdateSqliteLibcMap(&parent, ctx, source, versions, goproxy, goproxyDir, retry)
 		default:
 			return nil, fmt.Errorf("unknown function %s", fnName)
 		}
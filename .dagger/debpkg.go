@@ -0,0 +1,98 @@
+// # Debian packages.
+//
+// An ar archive holding `debian-binary`, `control.tar.gz` and `data.tar.gz`, as read by dpkg.
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// debVersion returns the Debian version of a package, with a Debian revision of 1.
+//
+// Pre-releases sort before releases with "~", e.g. "0.26.0~rc.1-1".
+func debVersion(v *semver.Version) string {
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if v.Prerelease() != "" {
+		version += "~" + v.Prerelease()
+	}
+	if v.Metadata() != "" {
+		version += "+" + v.Metadata()
+	}
+	return version + "-1"
+}
+
+// debScript wraps a maintainer script body, run only for the given actions.
+func debScript(body string, actions ...string) []byte {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\nset -e\n\n")
+	fmt.Fprintf(&b, "case \"$1\" in\n%s)\n", strings.Join(actions, "|"))
+	b.WriteString(indentScript(body))
+	b.WriteString("\t;;\nesac\n")
+	return []byte(b.String())
+}
+
+// writeDeb writes a Debian package.
+func writeDeb(spec *packageSpec) ([]byte, error) {
+	files := spec.WithParentDirs()
+
+	var control strings.Builder
+	fmt.Fprintf(&control, "Package: %s\n", spec.Name)
+	fmt.Fprintf(&control, "Version: %s\n", debVersion(spec.Version))
+	fmt.Fprintf(&control, "Architecture: %s\n", spec.Arch)
+	fmt.Fprintf(&control, "Maintainer: %s\n", packageMaintainer)
+	fmt.Fprintf(&control, "Installed-Size: %d\n", (spec.InstalledSize()+1023)/1024)
+	control.WriteString("Section: web\n")
+	control.WriteString("Priority: optional\n")
+	fmt.Fprintf(&control, "Homepage: %s\n", packageHomepage)
+	fmt.Fprintf(&control, "Description: %s\n", packageSummary)
+	for line := range strings.Lines(packageDescription) {
+		fmt.Fprintf(&control, " %s\n", strings.TrimRight(line, "\n"))
+	}
+
+	var md5sums strings.Builder
+	for _, f := range files {
+		if !f.IsDir() {
+			fmt.Fprintf(&md5sums, "%x  %s\n", md5.Sum(f.Data), strings.TrimPrefix(f.Path, "/"))
+		}
+	}
+
+	controlFiles := []packageFile{
+		{Path: "/control", Mode: 0644, Data: []byte(control.String())},
+		{Path: "/md5sums", Mode: 0644, Data: []byte(md5sums.String())},
+		{Path: "/conffiles", Mode: 0644, Data: []byte(strings.Join(spec.ConfigFiles(), "\n") + "\n")},
+		{Path: "/preinst", Mode: 0755, Data: debScript(packagePreInstall, "install", "upgrade")},
+		{Path: "/postinst", Mode: 0755, Data: debScript(packagePostInstall, "configure")},
+		{Path: "/prerm", Mode: 0755, Data: debScript(packagePreRemove, "remove")},
+		{Path: "/postrm", Mode: 0755, Data: debScript(packagePostRemove, "remove", "purge")},
+	}
+	controlTar, err := gzipTar(controlFiles, "./", spec.BuildTime, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write control.tar.gz: %w", err)
+	}
+	dataTar, err := gzipTar(files, "./", spec.BuildTime, true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write data.tar.gz: %w", err)
+	}
+
+	var ar bytes.Buffer
+	ar.WriteString("!<arch>\n")
+	writeArMember(&ar, "debian-binary", []byte("2.0\n"), spec.BuildTime)
+	writeArMember(&ar, "control.tar.gz", controlTar, spec.BuildTime)
+	writeArMember(&ar, "data.tar.gz", dataTar, spec.BuildTime)
+	return ar.Bytes(), nil
+}
+
+// writeArMember appends a member to a common-format ar archive.
+func writeArMember(ar *bytes.Buffer, name string, data []byte, mtime time.Time) {
+	fmt.Fprintf(ar, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, mtime.Unix(), 0, 0, "100644", len(data))
+	ar.Write(data)
+	if len(data)%2 != 0 {
+		ar.WriteByte('\n')
+	}
+}
@@ -0,0 +1,133 @@
+// # Debug artifacts.
+//
+// Unstripped binaries and frontend source maps, shipped apart from the release
+// archives so that crash reports can be symbolized.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// Name of the file tying a debug build to its release binary.
+const debugInfoFile = "BUILD_INFO.txt"
+
+// debugBundle collects an unstripped build of a target, along with the build IDs
+// and checksum of the stripped release binary it matches.
+//
+// Both are linked from the same objects with `-s -w` as the only difference,
+// so code addresses are identical and the debug binary symbolizes release stack traces.
+func debugBundle(debugBuild *dagger.Container, release *dagger.File, t BuildMatrix) *dagger.Directory {
+	return debugBuild.
+		WithFile("/release/"+t.BinaryName(), release).
+		WithEnvVariable("NAME", t.BinaryName()).
+		WithEnvVariable("BINARY", debugBinaryName(t)).
+		WithExec([]string{"sh", "-euc", `
+			mkdir -p /debug
+			cp "/out/$NAME" "/debug/$BINARY"
+			{
+				echo "binary: $NAME"
+				echo "release-build-id: $(go tool buildid "/release/$NAME")"
+				echo "release-sha256: $(sha256sum "/release/$NAME" | cut -d ' ' -f 1)"
+				echo "debug-build-id: $(go tool buildid "/out/$NAME")"
+			} > /debug/` + debugInfoFile + `
+		`}).
+		Directory("/debug")
+}
+
+// debugBinaryName returns the name of the debug binary in a debug bundle.
+func debugBinaryName(t BuildMatrix) string {
+	if t.OS == "windows" {
+		return "memos.exe"
+	}
+	return "memos"
+}
+
+// rebindDebugBundle points a debug bundle to a release binary that changed after
+// compiling, such as a signed one, by rewriting its BUILD_INFO.txt.
+func rebindDebugBundle(bundle *dagger.Directory, release *dagger.File, t BuildMatrix, goImage string) *dagger.Directory {
+	debugBuild := dag.Container().
+		From(goImage).
+		WithFile("/out/"+t.BinaryName(), bundle.File(debugBinaryName(t)))
+	return debugBundle(debugBuild, release, t)
+}
+
+// splitSourceMaps separates the `.map` files from a frontend dist, so they are not embedded.
+func splitSourceMaps(dist *dagger.Directory) (stripped, sourceMaps *dagger.Directory) {
+	return dist.Filter(dagger.DirectoryFilterOpts{Exclude: []string{"**/*.map"}}),
+		dist.Filter(dagger.DirectoryFilterOpts{Include: []string{"**/*.map"}})
+}
+
+// archiveTools returns the container that creates archives, with zip installed.
+func (m *MemosBuilds) archiveTools(ctx context.Context, retry retryPolicy) (*dagger.Container, error) {
+	var archiver *dagger.Container
+	err := retry.Do(ctx, "apk add (archives)", func() (err error) {
+		archiver, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "zip"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the archiver: %w", err)
+	}
+	return archiver, nil
+}
+
+// createDirectoryArchive creates a tar.gz or zip archive holding the contents of a directory,
+// in the container of archiveTools.
+func (m *MemosBuilds) createDirectoryArchive(
+	dir *dagger.Directory,
+	archiveName string,
+	archiver *dagger.Container,
+) *dagger.File {
+	ctr := archiver.
+		WithDirectory("/work/content", dir).
+		WithWorkdir("/work/content")
+
+	if strings.HasSuffix(archiveName, ".zip") {
+		ctr = ctr.WithExec([]string{"zip", "-9", "-r", "../" + archiveName, "."})
+	} else {
+		ctr = ctr.WithExec([]string{"sh", "-c", "tar -czvf ../" + archiveName + " *"})
+	}
+
+	return ctr.File("/work/" + archiveName)
+}
+
+// createDebugArchives creates the debug archive of every built target, plus the
+// source maps archive when the frontend was built.
+// Returns nil when the build has no debug symbols.
+func (m *MemosBuilds) createDebugArchives(build *buildResult, version string, archiver *dagger.Container) *dagger.Directory {
+	if build.DebugSymbols == nil {
+		return nil
+	}
+
+	out := dag.Directory()
+	for _, t := range build.Targets {
+		archiveName := t.DebugArchiveName(version)
+		out = out.WithFile(archiveName, m.createDirectoryArchive(build.DebugSymbols.Directory(t.BinaryName()), archiveName, archiver))
+	}
+	if build.SourceMaps != nil {
+		archiveName := fmt.Sprintf(buildconsts.SOURCE_MAPS_ARCHIVE_FORMAT, version)
+		out = out.WithFile(archiveName, m.createDirectoryArchive(build.SourceMaps, archiveName, archiver))
+	}
+	return out
+}
+
+// syncDebugArchive creates the debug archive of a single target, reporting failures.
+func (m *MemosBuilds) syncDebugArchive(
+	ctx context.Context,
+	build *buildResult,
+	t BuildMatrix,
+	version string,
+	archiver *dagger.Container,
+) error {
+	if build.DebugSymbols == nil {
+		return nil
+	}
+	_, err := m.createDirectoryArchive(build.DebugSymbols.Directory(t.BinaryName()), t.DebugArchiveName(version), archiver).Sync(ctx)
+	return err
+}
@@ -0,0 +1,331 @@
+// # Dependency handling.
+//
+// Keeps the upstream module graph intact, except where our patches require changes.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// dependencyChange describes a module whose selected version differs from upstream.
+type dependencyChange struct {
+	Path     string
+	Upstream string // empty when the module was added
+	Final    string // empty when the module was removed
+	Reason   string // empty when the change is unexpected
+}
+
+// Reasons for expected dependency changes.
+const (
+	driftReasonPinned   = "pinned by patches"
+	driftReasonRequired = "required by pinned modules"
+	driftReasonDropped  = "no longer required"
+)
+
+// goModuleJSON is the subset of `go list -m -json` and `go mod edit -json` output we need.
+type goModuleJSON struct {
+	Path    string
+	Version string
+	Replace *goModuleJSON
+}
+
+// goModEditJSON is the subset of `go mod edit -json` output we need.
+type goModEditJSON struct {
+	Require []goModuleJSON
+}
+
+// moduleVersionString returns the effective version of a module, accounting for replacements.
+func moduleVersionString(m goModuleJSON) string {
+	if m.Replace == nil {
+		return m.Version
+	}
+	if m.Replace.Version == "" {
+		return "=> " + m.Replace.Path
+	}
+	return fmt.Sprintf("=> %s %s", m.Replace.Path, m.Replace.Version)
+}
+
+// parseModuleList parses the concatenated JSON objects printed by `go list -m -json all`.
+func parseModuleList(output string) (map[string]string, error) {
+	modules := map[string]string{}
+	dec := json.NewDecoder(strings.NewReader(output))
+	for {
+		var m goModuleJSON
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse module list: %w", err)
+		}
+		modules[m.Path] = moduleVersionString(m)
+	}
+	return modules, nil
+}
+
+// parseRequirements parses `go mod edit -json` output into a path → version map.
+func parseRequirements(output string) (map[string]string, error) {
+	var mod goModEditJSON
+	if err := json.Unmarshal([]byte(output), &mod); err != nil {
+		return nil, fmt.Errorf("failed to parse go.mod: %w", err)
+	}
+	reqs := make(map[string]string, len(mod.Require))
+	for _, r := range mod.Require {
+		reqs[r.Path] = r.Version
+	}
+	return reqs, nil
+}
+
+// pinnedRequirements returns requirements whose versions were changed or added by patches.
+func pinnedRequirements(upstream map[string]string, patched map[string]string) map[string]string {
+	pins := map[string]string{}
+	for path, version := range patched {
+		if upstream[path] != version {
+			pins[path] = version
+		}
+	}
+	return pins
+}
+
+// parseModuleGraph parses `go mod graph` output into a node → requirements map.
+//
+// The main module (the only node without a version) is skipped, as its requirements
+// are rewritten by `go get` and would otherwise justify any change.
+func parseModuleGraph(output string) map[string][]string {
+	graph := map[string][]string{}
+	for line := range strings.Lines(output) {
+		from, to, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || !strings.Contains(from, "@") {
+			continue
+		}
+		graph[from] = append(graph[from], to)
+	}
+	return graph
+}
+
+// reachableModules returns the paths of the modules reachable from the given nodes
+// (`path@version`) of a module graph, the nodes' own included.
+func reachableModules(graph map[string][]string, roots []string) map[string]bool {
+	paths := map[string]bool{}
+	seen := map[string]bool{}
+	for len(roots) > 0 {
+		node := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		path, _, _ := strings.Cut(node, "@")
+		paths[path] = true
+		roots = append(roots, graph[node]...)
+	}
+	return paths
+}
+
+// diffDependencies compares the upstream and final module lists and classifies each change.
+//
+// A change is expected when the module is pinned by patches, when it is required at its
+// final version by another module that changed for an expected reason, or when it was
+// dropped after pinning: upstream, it was reachable from the replaced versions of the pinned
+// modules, and in the final graph it is no longer reachable from the pinned modules.
+func diffDependencies(
+	upstream map[string]string,
+	final map[string]string,
+	pins map[string]string,
+	upstreamGraph map[string][]string,
+	graph map[string][]string,
+) []dependencyChange {
+	var changes []dependencyChange
+	for path, version := range final {
+		if upstream[path] != version {
+			changes = append(changes, dependencyChange{Path: path, Upstream: upstream[path], Final: version})
+		}
+	}
+	for path, version := range upstream {
+		if _, ok := final[path]; !ok {
+			changes = append(changes, dependencyChange{Path: path, Upstream: version})
+		}
+	}
+	slices.SortFunc(changes, func(a, b dependencyChange) int { return strings.Compare(a.Path, b.Path) })
+
+	// Raising pinned modules may prune the parts of the graph only their old versions required.
+	var replaced, pinned []string
+	for path, version := range pins {
+		if v, ok := upstream[path]; ok {
+			replaced = append(replaced, path+"@"+v)
+		}
+		pinned = append(pinned, path+"@"+version)
+	}
+	requiredBefore := reachableModules(upstreamGraph, replaced)
+	requiredAfter := reachableModules(graph, pinned)
+
+	for i, c := range changes {
+		if _, ok := pins[c.Path]; ok {
+			changes[i].Reason = driftReasonPinned
+			continue
+		}
+		if c.Final == "" && requiredBefore[c.Path] && !requiredAfter[c.Path] {
+			changes[i].Reason = driftReasonDropped
+		}
+	}
+
+	// Propagate through the graph until no more changes can be explained.
+	for progress := true; progress; {
+		progress = false
+		for i, c := range changes {
+			if c.Reason != "" || c.Final == "" {
+				continue
+			}
+			node := c.Path + "@" + c.Final
+			for _, e := range changes {
+				if e.Reason == "" || e.Final == "" {
+					continue
+				}
+				if slices.Contains(graph[e.Path+"@"+e.Final], node) {
+					changes[i].Reason = driftReasonRequired
+					progress = true
+					break
+				}
+			}
+		}
+	}
+
+	return changes
+}
+
+// unexpectedDependencies returns the changes that could not be explained by patches.
+func unexpectedDependencies(changes []dependencyChange) []dependencyChange {
+	var unexpected []dependencyChange
+	for _, c := range changes {
+		if c.Reason == "" {
+			unexpected = append(unexpected, c)
+		}
+	}
+	return unexpected
+}
+
+// formatDependencyDrift renders a human-readable dependency drift report.
+func formatDependencyDrift(changes []dependencyChange) string {
+	if len(changes) == 0 {
+		return "No dependency drift from upstream.\n"
+	}
+
+	orNone := func(s string) string {
+		if s == "" {
+			return "(none)"
+		}
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteString("Dependency drift from upstream:\n\n")
+	for _, c := range changes {
+		reason := c.Reason
+		if reason == "" {
+			reason = "UNEXPECTED"
+		}
+		fmt.Fprintf(&b, "  %s %s -> %s (%s)\n", c.Path, orNone(c.Upstream), orNone(c.Final), reason)
+	}
+	return b.String()
+}
+
+// resolveDependencies updates go.mod and go.sum only for modules changed by our patches,
+// so that the backend can be built with `-mod=readonly`.
+//
+// Returns the updated source and a dependency drift report.
+// Fails if any module other than the pinned ones and their requirements changed.
+func (m *MemosBuilds) resolveDependencies(
+	ctx context.Context,
+	goImage string,
+	proxy *goProxy,
+	upstream *dagger.Directory,
+	patched *dagger.Directory,
+) (*dagger.Directory, string, error) {
+	base := proxy.Apply(dag.Container().
+		From(goImage).
+		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")))
+
+	upstreamCtr := base.
+		WithWorkdir("/upstream").
+		WithFile("/upstream/go.mod", upstream.File("go.mod")).
+		WithFile("/upstream/go.sum", upstream.File("go.sum"))
+
+	upstreamReqsJSON, err := upstreamCtr.WithExec([]string{"go", "mod", "edit", "-json"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read upstream go.mod: %w", err)
+	}
+	upstreamListJSON, err := upstreamCtr.WithExec([]string{"go", "list", "-m", "-json", "all"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list upstream modules: %w", err)
+	}
+	upstreamGraphOutput, err := upstreamCtr.WithExec([]string{"go", "mod", "graph"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read upstream module graph: %w", err)
+	}
+
+	ctr := base.
+		WithWorkdir("/src").
+		WithDirectory("/src", patched)
+
+	patchedReqsJSON, err := ctr.WithExec([]string{"go", "mod", "edit", "-json"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read patched go.mod: %w", err)
+	}
+
+	upstreamReqs, err := parseRequirements(upstreamReqsJSON)
+	if err != nil {
+		return nil, "", err
+	}
+	patchedReqs, err := parseRequirements(patchedReqsJSON)
+	if err != nil {
+		return nil, "", err
+	}
+	pins := pinnedRequirements(upstreamReqs, patchedReqs)
+
+	// `go get` adds the missing go.sum entries and raises requirements of pinned modules.
+	// It is a no-op for go.mod lines that already match.
+	paths := make([]string, 0, len(pins))
+	for path := range pins {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		ctr = ctr.WithExec([]string{"go", "get", path + "@" + pins[path]})
+	}
+
+	// Listing in the default readonly mode also ensures go.mod and go.sum are consistent.
+	finalListJSON, err := ctr.WithExec([]string{"go", "list", "-m", "-json", "all"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list final modules: %w", err)
+	}
+	graphOutput, err := ctr.WithExec([]string{"go", "mod", "graph"}).Stdout(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read module graph: %w", err)
+	}
+
+	upstreamModules, err := parseModuleList(upstreamListJSON)
+	if err != nil {
+		return nil, "", err
+	}
+	finalModules, err := parseModuleList(finalListJSON)
+	if err != nil {
+		return nil, "", err
+	}
+
+	changes := diffDependencies(upstreamModules, finalModules, pins, parseModuleGraph(upstreamGraphOutput), parseModuleGraph(graphOutput))
+	report := formatDependencyDrift(changes)
+	if unexpected := unexpectedDependencies(changes); len(unexpected) > 0 {
+		return nil, report, fmt.Errorf("%d module(s) changed unexpectedly:\n%s", len(unexpected), formatDependencyDrift(unexpected))
+	}
+
+	return patched.
+		WithFile("go.mod", ctr.File("/src/go.mod")).
+		WithFile("go.sum", ctr.File("/src/go.sum")), report, nil
+}
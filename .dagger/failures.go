@@ -0,0 +1,126 @@
+// # Keep-going failure reporting.
+//
+// Collects per-target failures so a matrix build can ship whatever succeeded.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pipeline stages a target can fail at.
+const (
+	failureStageCompile   = "compile"
+	failureStageHardening = "hardening"
+	failureStageSmokeTest = "smoke-test"
+	failureStageSigning   = "signing"
+	failureStageArchive   = "archive"
+	failureStagePackage   = "package"
+)
+
+// Number of trailing error lines kept in the failure summary.
+const failureExcerptLines = 20
+
+// targetFailure records why a target produced no artifact.
+type targetFailure struct {
+	Target  string
+	Stage   string
+	Excerpt string
+}
+
+// newTargetFailure summarises an error, keeping the tail of the command output when available.
+func newTargetFailure(t BuildMatrix, stage string, err error) targetFailure {
+	text := err.Error()
+	var execErr *dagger.ExecError
+	if errors.As(err, &execErr) && strings.TrimSpace(execErr.Stderr) != "" {
+		text = execErr.Stderr
+	}
+	return targetFailure{
+		Target:  t.DockerPlatform(),
+		Stage:   stage,
+		Excerpt: tailLines(text, failureExcerptLines),
+	}
+}
+
+// tailLines returns the last n non-empty lines of s.
+func tailLines(s string, n int) string {
+	var lines []string
+	for line := range strings.Lines(s) {
+		if line = strings.TrimRight(line, "\r\n"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatFailureSummary renders failures as a plain-text report.
+func formatFailureSummary(version string, failures []targetFailure, succeeded int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Build %s: %d target(s) failed, %d succeeded.\n", version, len(failures), succeeded)
+	for _, f := range failures {
+		fmt.Fprintf(&b, "\n== %s (%s)\n", f.Target, f.Stage)
+		for line := range strings.Lines(f.Excerpt) {
+			b.WriteString("    " + strings.TrimRight(line, "\n") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// archiveEach creates the release archive of every built target separately,
+// so that a failing archive does not discard the others.
+//
+// Targets that fail are moved from build.Targets to build.Failures.
+// FreeBSD packages and Windows service archives are created along; debug archives are
+// checked along, and created from the cache by createDebugArchives.
+func (m *MemosBuilds) archiveEach(
+	ctx context.Context,
+	build *buildResult,
+	bundle *archiveBundle,
+	version string,
+	archiver *dagger.Container,
+) *dagger.Directory {
+	out := dag.Directory()
+	buildTime := time.Now().UTC().Truncate(time.Second)
+	var archived []BuildMatrix
+	for _, t := range build.Targets {
+		binary := build.Binaries.File(t.BinaryName())
+		archive, err := m.createArchive(binary, bundle, t, version, archiver).Sync(ctx)
+		// Companion of the archive: the FreeBSD package or the Windows service archive.
+		var companion *dagger.File
+		var companionName string
+		if err == nil {
+			switch t.OS {
+			case "freebsd":
+				companionName = t.PackageName(version, ".pkg")
+				companion, err = m.createFreeBSDPackage(ctx, build.Prepared, bundle, t, binary, buildTime)
+			case "windows":
+				companionName = t.ServiceArchiveName(version)
+				companion, err = m.createServiceArchive(binary, bundle, t, version, archiver)
+				if err == nil {
+					companion, err = companion.Sync(ctx)
+				}
+			}
+		}
+		if err == nil {
+			err = m.syncDebugArchive(ctx, build, t, version, archiver)
+		}
+		if err != nil {
+			build.Failures = append(build.Failures, newTargetFailure(t, failureStageArchive, err))
+			continue
+		}
+		archived = append(archived, t)
+		out = out.WithFile(t.ArchiveName(version), archive)
+		if companion != nil {
+			out = out.WithFile(companionName, companion)
+		}
+	}
+	build.Targets = archived
+	return out
+}
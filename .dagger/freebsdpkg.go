@@ -0,0 +1,292 @@
+// # FreeBSD packages.
+//
+// A gzip-compressed tar archive starting with the `+COMPACT_MANIFEST` and `+MANIFEST`
+// entries, followed by the files under their absolute paths, as read by pkg(8).
+// Install with `pkg add`.
+//
+// The package installs the rc.d script of the release archives, reading its settings
+// from rc.conf, creates a `memos` user and keeps local changes to memos.env on upgrades.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Paths of the FreeBSD installation, under the ports prefix.
+const (
+	freebsdPrefix  = "/usr/local"
+	freebsdBinary  = freebsdPrefix + "/bin/memos"
+	freebsdRcPath  = freebsdPrefix + "/etc/rc.d/memos"
+	freebsdEnvFile = freebsdPrefix + "/etc/memos/memos.env"
+	// Default of memos_data in the rc.d script.
+	freebsdDataDir = "/var/db/memos"
+)
+
+// pkg(8) hooks. The user is created before files are unpacked, so the data directory
+// can be given to it; the service is stopped before the rc.d script is removed.
+const (
+	freebsdPreInstall = `if ! pw usershow memos >/dev/null 2>&1; then
+	pw useradd memos -c "Memos" -d /var/db/memos -s /usr/sbin/nologin
+fi
+`
+	freebsdPreDeinstall = `if [ -x /usr/local/etc/rc.d/memos ]; then
+	/usr/local/etc/rc.d/memos onestop >/dev/null 2>&1 || true
+fi
+`
+	freebsdMessage = `Memos is installed, but not enabled. Run it as a service with:
+
+  sysrc memos_enable=YES
+  service memos start
+
+Settings are read from /usr/local/etc/memos/memos.env, and memos_data, memos_user
+and memos_port in rc.conf. Data is kept in /var/db/memos when the package is removed.
+`
+)
+
+// pkg ABI of each FreeBSD architecture. The binaries are static, so the packages
+// install on any FreeBSD release.
+var freebsdABIs = map[string]string{
+	"amd64": "FreeBSD:*:amd64",
+	"arm64": "FreeBSD:*:aarch64",
+}
+
+// freebsdVersion returns the pkg version of a package.
+//
+// Pre-releases become an alphabetic component, which pkg orders before releases,
+// e.g. "0.26.0.rc1".
+func freebsdVersion(v *semver.Version) string {
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if pre := v.Prerelease(); pre != "" {
+		version += "." + strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			if r >= 'A' && r <= 'Z' {
+				return r - 'A' + 'a'
+			}
+			return -1
+		}, pre)
+	}
+	return version
+}
+
+// freebsdManifestFile is a file entry of a pkg manifest.
+type freebsdManifestFile struct {
+	Uname string `json:"uname"`
+	Gname string `json:"gname"`
+	Perm  string `json:"perm"`
+	Sum   string `json:"sum"`
+}
+
+// freebsdManifestDir is a directory entry of a pkg manifest.
+type freebsdManifestDir struct {
+	Uname string `json:"uname"`
+	Gname string `json:"gname"`
+	Perm  string `json:"perm"`
+	// Whether pkg may fail to remove the directory. Data directories are rarely empty.
+	Try bool `json:"try"`
+}
+
+// freebsdManifest is a pkg manifest. The compact manifest leaves out the contents.
+type freebsdManifest struct {
+	Name         string            `json:"name"`
+	Origin       string            `json:"origin"`
+	Version      string            `json:"version"`
+	Comment      string            `json:"comment"`
+	Maintainer   string            `json:"maintainer"`
+	WWW          string            `json:"www"`
+	ABI          string            `json:"abi"`
+	Prefix       string            `json:"prefix"`
+	Flatsize     int               `json:"flatsize"`
+	Licenselogic string            `json:"licenselogic"`
+	Licenses     []string          `json:"licenses"`
+	Desc         string            `json:"desc"`
+	Categories   []string          `json:"categories"`
+	Users        []string          `json:"users,omitempty"`
+	Groups       []string          `json:"groups,omitempty"`
+	Messages     []map[string]any  `json:"messages,omitempty"`
+	Scripts      map[string]string `json:"scripts,omitempty"`
+
+	Files       map[string]freebsdManifestFile `json:"files,omitempty"`
+	Directories map[string]freebsdManifestDir  `json:"directories,omitempty"`
+	Config      []string                       `json:"config,omitempty"`
+}
+
+// freebsdOwner returns the user and group owning a file. The root group is "wheel".
+func freebsdOwner(f packageFile) (string, string) {
+	if f.Owner == "" {
+		return "root", "wheel"
+	}
+	return f.Owner, f.Owner
+}
+
+// freebsdRcScriptForPackage adapts the rc.d script of the release archives to read the
+// packaged memos.env by default.
+func freebsdRcScriptForPackage() (string, error) {
+	anchor := `: ${memos_port:="5230"}` + "\n"
+	if !strings.Contains(freebsdRcScript, anchor) {
+		return "", fmt.Errorf("rc.d script does not default memos_port")
+	}
+	return strings.Replace(freebsdRcScript, anchor, anchor+`: ${memos_env_file:="`+freebsdEnvFile+`"}`+"\n", 1), nil
+}
+
+// writeFreeBSDPackage writes a FreeBSD package.
+func writeFreeBSDPackage(spec *packageSpec) ([]byte, error) {
+	manifest := freebsdManifest{
+		Name:         spec.Name,
+		Origin:       "www/" + spec.Name,
+		Version:      freebsdVersion(spec.Version),
+		Comment:      packageSummary,
+		Maintainer:   packageMaintainer,
+		WWW:          packageHomepage,
+		ABI:          spec.Arch,
+		Prefix:       freebsdPrefix,
+		Flatsize:     spec.InstalledSize(),
+		Licenselogic: "single",
+		Licenses:     []string{packageLicense},
+		Desc:         packageDescription,
+		Categories:   []string{"www"},
+		Users:        []string{"memos"},
+		Groups:       []string{"memos"},
+		Messages:     []map[string]any{{"message": freebsdMessage}},
+	}
+	compact, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write +COMPACT_MANIFEST: %w", err)
+	}
+
+	manifest.Scripts = map[string]string{
+		"pre-install":   freebsdPreInstall,
+		"pre-deinstall": freebsdPreDeinstall,
+	}
+	manifest.Files = map[string]freebsdManifestFile{}
+	manifest.Directories = map[string]freebsdManifestDir{}
+	for _, f := range spec.Files {
+		uname, gname := freebsdOwner(f)
+		perm := fmt.Sprintf("%04o", f.Mode.Perm())
+		if f.IsDir() {
+			manifest.Directories[f.Path] = freebsdManifestDir{Uname: uname, Gname: gname, Perm: perm, Try: true}
+			continue
+		}
+		manifest.Files[f.Path] = freebsdManifestFile{
+			Uname: uname,
+			Gname: gname,
+			Perm:  perm,
+			Sum:   fmt.Sprintf("1$%x", sha256.Sum256(f.Data)),
+		}
+	}
+	manifest.Config = spec.ConfigFiles()
+	full, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write +MANIFEST: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gz)
+	// pkg reads the manifests first, so they lead the archive.
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{"+COMPACT_MANIFEST", compact}, {"+MANIFEST", full}} {
+		h := &tar.Header{
+			Name:     entry.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(entry.data)),
+			Uname:    "root",
+			Gname:    "wheel",
+			ModTime:  spec.BuildTime,
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range spec.Files {
+		h := f.TarHeader("/", spec.BuildTime)
+		h.Uname, h.Gname = freebsdOwner(f)
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// createFreeBSDPackage returns the package of a FreeBSD target.
+func (m *MemosBuilds) createFreeBSDPackage(
+	ctx context.Context,
+	prepared *preparedSource,
+	bundle *archiveBundle,
+	t BuildMatrix,
+	binary *dagger.File,
+	buildTime time.Time,
+) (*dagger.File, error) {
+	abi, ok := freebsdABIs[t.Arch]
+	if !ok {
+		return nil, fmt.Errorf("no FreeBSD ABI for %s", t.Arch)
+	}
+	license, err := bundle.License.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the license: %w", err)
+	}
+	data, err := readFileBytes(ctx, binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", t.BinaryName(), err)
+	}
+	rcScript, err := freebsdRcScriptForPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	name := prepared.ArtifactName()
+	files := []packageFile{
+		{Path: freebsdBinary, Mode: 0755, Data: data},
+		{Path: freebsdRcPath, Mode: 0755, Data: []byte(rcScript)},
+		{Path: path.Dir(freebsdEnvFile), Mode: fs.ModeDir | 0755},
+		{Path: freebsdEnvFile, Mode: 0640, Data: []byte(bundle.EnvExample), Config: true},
+		{Path: path.Join(freebsdPrefix, "share/licenses", name, "LICENSE"), Mode: 0644, Data: []byte(license), License: true},
+		{Path: freebsdDataDir, Mode: fs.ModeDir | 0750, Owner: "memos"},
+	}
+	slices.SortFunc(files, func(a, b packageFile) int { return strings.Compare(a.Path, b.Path) })
+
+	pkg, err := writeFreeBSDPackage(&packageSpec{
+		Name:      name,
+		Version:   packageVersion(prepared.BuildVersion),
+		Arch:      abi,
+		BuildTime: buildTime,
+		Files:     files,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newFileFromBytes(ctx, t.PackageName(prepared.ArtifactVersion(), ".pkg"), pkg)
+}
@@ -0,0 +1,228 @@
+// # Go module proxy client.
+//
+// Minimal implementation of the GOPROXY protocol, enough to fetch `go.mod`
+// files of upstream dependencies without cloning their repositories.
+//
+// See <https://go.dev/ref/mod#goproxy-protocol>.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Used when GOPROXY is not set, matching the Go toolchain default.
+const defaultGoProxy = "https://proxy.golang.org,direct"
+
+// Where a local proxy tree (`--goproxy-dir`) is mounted in Go containers, and the
+// `file://` URL that refers to it in GOPROXY lists.
+const (
+	goProxyMountPath = "/goproxy"
+	goProxyDirURL    = "file://" + goProxyMountPath
+)
+
+// goProxyClient fetches from HTTP(S) proxies, giving up on unresponsive ones.
+var goProxyClient = &http.Client{Timeout: 30 * time.Second}
+
+// goProxy is the module proxy configuration of a build, shared by the go.mod fetches of
+// the pipeline and the Go containers.
+//
+// The pipeline runs in the Dagger module runtime, which neither inherits the host's
+// environment nor sees its files, so both are passed explicitly.
+type goProxy struct {
+	// GOPROXY list. Empty selects defaultGoProxy, or goProxyDirURL when Dir is set.
+	List string
+	// Local proxy tree, served as goProxyDirURL. May be nil.
+	Dir *dagger.Directory
+}
+
+// newGoProxy validates a GOPROXY list and a local proxy tree.
+//
+// `file://` entries can only refer to the local proxy tree.
+func newGoProxy(list string, dir *dagger.Directory) (*goProxy, error) {
+	list = strings.TrimSpace(list)
+	if list == "" && dir != nil {
+		list = goProxyDirURL
+	}
+	for _, entry := range parseGoProxy(list) {
+		if !strings.HasPrefix(entry.URL, "file:") {
+			continue
+		}
+		if dir == nil {
+			return nil, fmt.Errorf("GOPROXY entry %q needs --goproxy-dir: host paths are not visible to the pipeline", entry.URL)
+		}
+		if strings.TrimSuffix(entry.URL, "/") != goProxyDirURL {
+			return nil, fmt.Errorf("GOPROXY entry %q must be %s, where --goproxy-dir is mounted", entry.URL, goProxyDirURL)
+		}
+	}
+	return &goProxy{List: list, Dir: dir}, nil
+}
+
+// Apply configures a Go container to download modules through the proxies.
+//
+// Containers keep the toolchain default when no proxy was given.
+func (p *goProxy) Apply(ctr *dagger.Container) *dagger.Container {
+	if p == nil || p.List == "" {
+		return ctr
+	}
+	ctr = ctr.WithEnvVariable("GOPROXY", p.List)
+	if p.Dir != nil {
+		ctr = ctr.WithMountedDirectory(goProxyMountPath, p.Dir)
+	}
+	return ctr
+}
+
+// errModuleNotFound is returned by a proxy that does not serve the requested module version.
+// It allows falling through to the next proxy on comma-separated lists.
+var errModuleNotFound = errors.New("module version not found")
+
+// goProxyEntry is a single element of a GOPROXY list.
+type goProxyEntry struct {
+	URL string
+	// FallbackOnError is true when the entry is followed by a pipe ("|"),
+	// meaning any error falls through to the next entry, not only not-found ones.
+	FallbackOnError bool
+}
+
+// parseGoProxy splits a GOPROXY value into its entries.
+//
+// Empty values resolve to defaultGoProxy.
+func parseGoProxy(goproxy string) []goProxyEntry {
+	goproxy = strings.TrimSpace(goproxy)
+	if goproxy == "" {
+		goproxy = defaultGoProxy
+	}
+
+	var entries []goProxyEntry
+	for goproxy != "" {
+		idx := strings.IndexAny(goproxy, ",|")
+		if idx == -1 {
+			entries = append(entries, goProxyEntry{URL: strings.TrimSpace(goproxy)})
+			break
+		}
+		if u := strings.TrimSpace(goproxy[:idx]); u != "" {
+			entries = append(entries, goProxyEntry{URL: u, FallbackOnError: goproxy[idx] == '|'})
+		}
+		goproxy = goproxy[idx+1:]
+	}
+	return entries
+}
+
+// escapeModulePath applies the case-encoding used by module proxies,
+// replacing every uppercase letter with "!" followed by its lowercase form.
+//
+// E.g. "github.com/Masterminds/semver" -> "github.com/!masterminds/semver".
+func escapeModulePath(path string) (string, error) {
+	var b strings.Builder
+	for _, r := range path {
+		if r == '!' || r >= unicode.MaxASCII {
+			return "", fmt.Errorf("invalid module path %q", path)
+		}
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// fetchGoMod retrieves the go.mod file of a module version from the configured proxies.
+//
+// Honors `off`, `direct`, HTTP(S) and local proxy tree entries. As this pipeline never talks
+// to version control directly, `direct` entries are reported as errors. A nil proxy selects
+// defaultGoProxy.
+func fetchGoMod(ctx context.Context, client *http.Client, proxy *goProxy, modulePath string, version string) (string, error) {
+	if proxy == nil {
+		proxy = &goProxy{}
+	}
+	escPath, err := escapeModulePath(modulePath)
+	if err != nil {
+		return "", err
+	}
+	escVersion, err := escapeModulePath(version)
+	if err != nil {
+		return "", err
+	}
+	suffix := fmt.Sprintf("%s/@v/%s.mod", escPath, escVersion)
+
+	var errs []error
+	for _, entry := range parseGoProxy(proxy.List) {
+		var contents string
+		switch entry.URL {
+		case "off":
+			err = fmt.Errorf("module lookup disabled by GOPROXY=off")
+		case "direct":
+			err = fmt.Errorf("direct module fetching is not supported")
+		default:
+			contents, err = fetchFromProxy(ctx, client, proxy.Dir, entry.URL, suffix)
+		}
+		if err == nil {
+			return contents, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", entry.URL, err))
+		if !entry.FallbackOnError && !errors.Is(err, errModuleNotFound) {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("failed to fetch %s@%s go.mod: %w", modulePath, version, errors.Join(errs...))
+}
+
+// fetchFromProxy fetches a single file from a proxy base URL.
+//
+// `file://` URLs are read from the local proxy tree, dir.
+func fetchFromProxy(ctx context.Context, client *http.Client, dir *dagger.Directory, base string, suffix string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if dir == nil || path.Clean(u.Path) != goProxyMountPath {
+			return "", fmt.Errorf("%s is not the local proxy tree (%s)", base, goProxyDirURL)
+		}
+		if ok, _ := dir.Exists(ctx, suffix, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType}); !ok {
+			return "", errModuleNotFound
+		}
+		return dir.File(suffix).Contents(ctx)
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/"+suffix, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", errModuleNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
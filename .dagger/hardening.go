@@ -0,0 +1,266 @@
+// # Hardened builds.
+//
+// Link settings of the hardened mode, and verification of the properties of the
+// produced executables with Go's `debug/*` packages.
+package main
+
+import (
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Paths of the build containers that must not end up in binaries built with -trimpath.
+var buildPathMarkers = []string{
+	"/go/pkg/mod/",
+	"/root/.cache/go-build",
+	"/usr/local/go/src/",
+	"/src/cmd/memos",
+	"/src/server/",
+	"/src/store/",
+}
+
+// Windows DLL characteristics of a hardened executable: ASLR, DEP and Terminal Server awareness.
+const peHardenedCharacteristics = pe.IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE |
+	pe.IMAGE_DLLCHARACTERISTICS_NX_COMPAT |
+	pe.IMAGE_DLLCHARACTERISTICS_TERMINAL_SERVER_AWARE
+
+// hardenedBuildMode returns the `go build -buildmode` of a target in hardened mode.
+//
+// Without cgo, the Go linker only produces position-independent executables for Windows and macOS.
+// Linux and FreeBSD PIEs need the external linker and a dynamic loader, so they stay static executables.
+func hardenedBuildMode(t BuildMatrix) string {
+	if t.OS == "windows" || t.OS == "darwin" {
+		return "pie"
+	}
+	return "exe"
+}
+
+// archLevelSetting returns the architecture level variable of a target and its value, if any.
+func archLevelSetting(t BuildMatrix) (string, string) {
+	switch {
+	case t.GoAmd64() != "":
+		return "GOAMD64", t.GoAmd64()
+	case t.GoArm() != "":
+		return "GOARM", t.GoArm()
+	case t.Go386() != "":
+		return "GO386", t.Go386()
+	case t.GoPpc64() != "":
+		return "GOPPC64", t.GoPpc64()
+	case t.GoRiscv64() != "":
+		return "GORISCV64", t.GoRiscv64()
+	}
+	return "", ""
+}
+
+// hardeningCheck is the outcome of a single property check.
+type hardeningCheck struct {
+	Name    string
+	Problem string // empty when the check passed
+}
+
+// verifyHardening checks the properties of a hardened binary of a target.
+func verifyHardening(t BuildMatrix, data []byte) []hardeningCheck {
+	var checks []hardeningCheck
+	check := func(name string, problem string) {
+		checks = append(checks, hardeningCheck{Name: name, Problem: problem})
+	}
+
+	// Executable format, machine type and linking.
+	r := bytes.NewReader(data)
+	switch t.OS {
+	case "windows":
+		f, err := pe.NewFile(r)
+		if err != nil {
+			check("format", fmt.Sprintf("not a PE executable: %s", err))
+			return checks
+		}
+		defer f.Close()
+		check("machine", problemIf(f.Machine != peMachines[t.Arch], "machine is %#x, expected %#x", f.Machine, peMachines[t.Arch]))
+
+		var characteristics, want uint16
+		switch h := f.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			characteristics, want = h.DllCharacteristics, peHardenedCharacteristics
+		case *pe.OptionalHeader64:
+			characteristics, want = h.DllCharacteristics, peHardenedCharacteristics|pe.IMAGE_DLLCHARACTERISTICS_HIGH_ENTROPY_VA
+		}
+		check("aslr+dep", problemIf(characteristics&want != want, "DLL characteristics are %#x, expected %#x set", characteristics, want))
+
+	case "darwin":
+		f, err := macho.NewFile(r)
+		if err != nil {
+			check("format", fmt.Sprintf("not a Mach-O executable: %s", err))
+			return checks
+		}
+		defer f.Close()
+		check("machine", problemIf(f.Cpu != machoCPUs[t.Arch], "CPU is %s, expected %s", f.Cpu, machoCPUs[t.Arch]))
+		check("pie", problemIf(f.Flags&macho.FlagPIE == 0, "MH_PIE flag is not set"))
+		// macOS has no static executables; only system libraries may be linked.
+		libs, err := f.ImportedLibraries()
+		var foreign []string
+		for _, lib := range libs {
+			if !strings.HasPrefix(lib, "/usr/lib/") && !strings.HasPrefix(lib, "/System/Library/") {
+				foreign = append(foreign, lib)
+			}
+		}
+		check("system-libraries-only", problemIf(err != nil || len(foreign) > 0, "links non-system libraries %v (%v)", foreign, err))
+
+	default:
+		f, err := elf.NewFile(r)
+		if err != nil {
+			check("format", fmt.Sprintf("not an ELF executable: %s", err))
+			return checks
+		}
+		defer f.Close()
+		check("machine", problemIf(f.Machine != elfMachines[t.Arch], "machine is %s, expected %s", f.Machine, elfMachines[t.Arch]))
+		if t.OS == "freebsd" {
+			check("os-abi", problemIf(f.OSABI != elf.ELFOSABI_FREEBSD, "OS/ABI is %s, expected %s", f.OSABI, elf.ELFOSABI_FREEBSD))
+		}
+		interp := false
+		for _, p := range f.Progs {
+			interp = interp || p.Type == elf.PT_INTERP
+		}
+		needed, _ := f.ImportedLibraries()
+		check("static", problemIf(interp || len(needed) > 0, "dynamically linked (PT_INTERP: %t, DT_NEEDED: %v)", interp, needed))
+	}
+
+	// Build paths must have been trimmed.
+	var leaked []string
+	for _, marker := range buildPathMarkers {
+		if bytes.Contains(data, []byte(marker)) {
+			leaked = append(leaked, marker)
+		}
+	}
+	check("no-build-paths", problemIf(len(leaked) > 0, "contains build paths %v", leaked))
+
+	// Build settings recorded by the Go toolchain.
+	info, err := buildinfo.Read(bytes.NewReader(data))
+	if err != nil {
+		check("buildinfo", fmt.Sprintf("no Go build information: %s", err))
+		return checks
+	}
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	want := map[string]string{
+		"CGO_ENABLED": "0",
+		"-trimpath":   "true",
+		"-buildmode":  hardenedBuildMode(t),
+		"GOOS":        t.OS,
+		"GOARCH":      t.Arch,
+	}
+	if key, value := archLevelSetting(t); key != "" {
+		want[key] = value
+	}
+	var mismatches []string
+	for _, key := range []string{"CGO_ENABLED", "-trimpath", "-buildmode", "GOOS", "GOARCH", "GOAMD64", "GOARM", "GO386", "GOPPC64", "GORISCV64"} {
+		if value, ok := want[key]; ok && settings[key] != value {
+			mismatches = append(mismatches, fmt.Sprintf("%s=%q (expected %q)", key, settings[key], value))
+		}
+	}
+	check("buildinfo", problemIf(len(mismatches) > 0, "%s", strings.Join(mismatches, ", ")))
+	return checks
+}
+
+// problemIf returns the formatted problem when failed is true, and an empty string otherwise.
+func problemIf(failed bool, format string, args ...any) string {
+	if !failed {
+		return ""
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// hardeningResult is the outcome of the hardening checks of a single target.
+type hardeningResult struct {
+	Target BuildMatrix
+	Checks []hardeningCheck
+	// Set when the binary could not be read.
+	Err error
+}
+
+// Failed returns the problems found, or nil.
+func (r hardeningResult) Failed() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	var problems []string
+	for _, c := range r.Checks {
+		if c.Problem != "" {
+			problems = append(problems, c.Name+": "+c.Problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(problems, "\n"))
+}
+
+// verifyHardenedBuild checks every built binary, in parallel. Results are returned in target order.
+func (m *MemosBuilds) verifyHardenedBuild(
+	ctx context.Context,
+	build *buildResult,
+	opts buildOptions,
+) []hardeningResult {
+	maxConcurrent := opts.Concurrency
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultConcurrency()
+	}
+
+	results := make([]hardeningResult, len(build.Targets))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrent)
+	for i, t := range build.Targets {
+		g.Go(func() error {
+			results[i] = hardeningResult{Target: t}
+			data, err := readFileBytes(gctx, build.Binaries.File(t.BinaryName()))
+			if err != nil {
+				results[i].Err = fmt.Errorf("failed to read %s: %w", t.BinaryName(), err)
+				return nil
+			}
+			results[i].Checks = verifyHardening(t, data)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results
+}
+
+// formatHardeningReport renders hardening check results as a plain-text report.
+func formatHardeningReport(version string, results []hardeningResult) string {
+	failed := 0
+	for _, r := range results {
+		if r.Failed() != nil {
+			failed++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hardening checks %s: %d passed, %d failed.\n", version, len(results)-failed, failed)
+	for _, r := range results {
+		status := "PASS"
+		if r.Failed() != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "\n%s %s (-buildmode=%s)\n", status, r.Target.DockerPlatform(), hardenedBuildMode(r.Target))
+		if r.Err != nil {
+			fmt.Fprintf(&b, "    error: %s\n", r.Err)
+		}
+		for _, c := range r.Checks {
+			if c.Problem == "" {
+				fmt.Fprintf(&b, "    ok    %s\n", c.Name)
+			} else {
+				fmt.Fprintf(&b, "    FAIL  %s: %s\n", c.Name, c.Problem)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+// # JUnit reports.
+//
+// Converts `go test -json` output to the JUnit XML format understood by CI systems.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// testEvent is a line of `go test -json` output.
+//
+// See <https://pkg.go.dev/cmd/test2json>.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+	// Set on "build-output" events, e.g. "pkg [pkg.test]".
+	ImportPath string
+	// Set on package failures caused by a build failure, matching ImportPath.
+	FailedBuild string
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// Name of the test case reporting package-level failures, such as build errors.
+const junitPackageCase = "(package)"
+
+// parseTestEvents converts `go test -json` output to JUnit test suites, one per package.
+//
+// Lines that are not JSON, such as build output of older Go versions, are ignored.
+func parseTestEvents(name string, data []byte) *junitTestSuites {
+	type caseState struct {
+		junitTestCase
+		output strings.Builder
+	}
+	type suiteState struct {
+		suite  junitTestSuite
+		cases  []*caseState
+		byName map[string]*caseState
+		output strings.Builder
+		failed bool
+	}
+
+	var order []string
+	suites := map[string]*suiteState{}
+	buildOutput := map[string]*strings.Builder{}
+	suiteFor := func(pkg string) *suiteState {
+		s, ok := suites[pkg]
+		if !ok {
+			s = &suiteState{suite: junitTestSuite{Name: pkg}, byName: map[string]*caseState{}}
+			suites[pkg] = s
+			order = append(order, pkg)
+		}
+		return s
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Action == "build-output" {
+			if buildOutput[e.ImportPath] == nil {
+				buildOutput[e.ImportPath] = &strings.Builder{}
+			}
+			buildOutput[e.ImportPath].WriteString(e.Output)
+			continue
+		}
+		if e.Package == "" {
+			continue
+		}
+		s := suiteFor(e.Package)
+		if e.Test == "" {
+			switch e.Action {
+			case "output":
+				s.output.WriteString(e.Output)
+			case "fail":
+				s.failed = true
+				s.suite.Time = fmt.Sprintf("%.3f", e.Elapsed)
+				if b := buildOutput[e.FailedBuild]; e.FailedBuild != "" && b != nil {
+					s.output.WriteString(b.String())
+				}
+			case "pass", "skip":
+				s.suite.Time = fmt.Sprintf("%.3f", e.Elapsed)
+			}
+			continue
+		}
+
+		c, ok := s.byName[e.Test]
+		if !ok {
+			c = &caseState{junitTestCase: junitTestCase{Name: e.Test, Classname: e.Package}}
+			s.byName[e.Test] = c
+			s.cases = append(s.cases, c)
+		}
+		switch e.Action {
+		case "output":
+			c.output.WriteString(e.Output)
+		case "pass":
+			c.Time = fmt.Sprintf("%.3f", e.Elapsed)
+		case "fail":
+			c.Time = fmt.Sprintf("%.3f", e.Elapsed)
+			c.Failure = &junitMessage{Message: "Failed"}
+		case "skip":
+			c.Time = fmt.Sprintf("%.3f", e.Elapsed)
+			c.Skipped = &junitMessage{Message: "Skipped"}
+		}
+	}
+
+	report := &junitTestSuites{Name: name}
+	for _, pkg := range order {
+		s := suites[pkg]
+		packageFailed := s.failed
+		for _, c := range s.cases {
+			switch {
+			case c.Failure != nil:
+				c.Failure.Text = c.output.String()
+				s.suite.Failures++
+				packageFailed = false
+			case c.Skipped != nil:
+				c.Skipped.Text = c.output.String()
+				s.suite.Skipped++
+			}
+			s.suite.Cases = append(s.suite.Cases, c.junitTestCase)
+		}
+		// A package failing without a failed test did not build, or crashed outside of a test.
+		if packageFailed {
+			s.suite.Cases = append(s.suite.Cases, junitTestCase{
+				Name:      junitPackageCase,
+				Classname: pkg,
+				Time:      s.suite.Time,
+				Failure:   &junitMessage{Message: "Failed", Text: s.output.String()},
+			})
+			s.suite.Failures++
+		}
+		// Packages without tests only report "? pkg [no test files]".
+		if len(s.suite.Cases) == 0 {
+			continue
+		}
+		s.suite.Tests = len(s.suite.Cases)
+		report.Tests += s.suite.Tests
+		report.Failures += s.suite.Failures
+		report.Skipped += s.suite.Skipped
+		report.Suites = append(report.Suites, s.suite)
+	}
+	return report
+}
+
+// FailedTests returns the failing test cases, as "package.Test".
+func (r *junitTestSuites) FailedTests() []string {
+	var failed []string
+	for _, s := range r.Suites {
+		for _, c := range s.Cases {
+			if c.Failure != nil {
+				failed = append(failed, s.Name+"."+c.Name)
+			}
+		}
+	}
+	return failed
+}
+
+// XML returns the report serialised for writing to the output directory.
+func (r *junitTestSuites) XML() (string, error) {
+	data, err := xml.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data) + "\n", nil
+}
@@ -5,6 +5,8 @@ import (
 	"dagger/memos-builds/buildconsts"
 	"dagger/memos-builds/internal/dagger"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"slices"
 	"strings"
@@ -16,15 +18,6 @@ import (
 var (
 	versionVarPattern     = regexp.MustCompile(`var Version = "([^"]+)"`)
 	nightlyVersionPattern = regexp.MustCompile(`^nightly-\d{8}-[0-9a-fA-F]{9}$`)
-
-	// knownVersionCommits maps versions to specific commit hashes.
-	// Used to address upstream inconsistencies in tagged releases.
-	knownVersionCommits = map[string]string{
-		"0.25.2": "bfad0708e2c8062664e852f6f18223fd943ad5f5",
-		"0.25.3": "07a030ddfdbe5ac8a22c235be7b5771cc01f8498",
-		"0.26.0": "43b5a51ec73214d3c56aa48c82783ccfeec1a127",
-		"0.26.1": "b623162d37f87f9f174d8f6cd8e54c7034cfc789",
-	}
 )
 
 type BuildMatrix struct {
@@ -140,6 +133,35 @@ func (m *BuildMatrix) ArchiveName(version string) string {
 	return fmt.Sprintf("memos-%s-%s-%s.%s", version, m.OS, arch, ext)
 }
 
+// ArchiveDir returns the top-level directory of this target's release archive
+// (e.g., "memos-v0.25.3-linux-x86_64", "memos-v0.25.3-windows-x86_64")
+func (m *BuildMatrix) ArchiveDir(version string) string {
+	name := m.ArchiveName(version)
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".tar.gz"), ".zip")
+}
+
+// PackageName returns the filename of this target's native package, given the package extension
+// (e.g., "memos-v0.25.3-linux-x86_64.deb", "memos-v0.25.3-linux-armv7l.pkg.tar.zst")
+func (m *BuildMatrix) PackageName(version string, ext string) string {
+	return m.ArchiveDir(version) + ext
+}
+
+// DebugArchiveName returns the filename of the archive holding this target's debug build
+// (e.g., "memos-v0.25.3-linux-x86_64-debug.tar.gz", "memos-v0.25.3-windows-x86_64-debug.zip")
+func (m *BuildMatrix) DebugArchiveName(version string) string {
+	ext := ".tar.gz"
+	if m.OS == "windows" {
+		ext = ".zip"
+	}
+	return strings.TrimSuffix(m.ArchiveName(version), ext) + "-debug" + ext
+}
+
+// ServiceArchiveName returns the filename of the archive holding this target's Windows service
+// (e.g., "memos-v0.25.3-windows-x86_64-service.zip")
+func (m *BuildMatrix) ServiceArchiveName(version string) string {
+	return m.ArchiveDir(version) + "-service.zip"
+}
+
 // filterTargets returns a subset of TARGETS matching the given platforms string.
 //
 // Accepted formats:
@@ -215,6 +237,41 @@ func filterLinuxTargets(targets []BuildMatrix) []BuildMatrix {
 	return linux
 }
 
+// readFileBytes returns the raw contents of a file.
+//
+// The file is exported into the module runtime and read from its filesystem, so large
+// binaries are not sent through the API.
+func readFileBytes(ctx context.Context, f *dagger.File) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "memos-builds-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	if _, err := f.Export(ctx, path); err != nil {
+		return nil, fmt.Errorf("failed to export the file: %w", err)
+	}
+	return os.ReadFile(path)
+}
+
+// newFileFromBytes returns a file with the given raw contents, the counterpart of readFileBytes.
+//
+// The contents are written to the module's working directory and loaded from there. The
+// file is synced before returning, since the working directory only lives as long as the
+// function call.
+func newFileFromBytes(ctx context.Context, name string, data []byte) (*dagger.File, error) {
+	dir, err := os.MkdirTemp(".", "bytes-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filepath.Base(name))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, err
+	}
+	return dag.CurrentModule().WorkdirFile(path).Sync(ctx)
+}
+
 // extractVersionFromSource reads version from upstream source code.
 func (m *MemosBuilds) extractVersionFromSource(ctx context.Context, src *dagger.Directory) string {
 	contents, err := src.File(buildconsts.VERSION_FILE).Contents(ctx)
@@ -271,42 +328,67 @@ func nightlyReleaseTag(version string, shortSHA string) (string, bool) {
 func (m *MemosBuilds) resolveVersion(
 	ctx context.Context,
 	version string,
+	retry retryPolicy,
 ) (gitSrc *dagger.Directory, buildVersion string, releaseVersion string, commit string, err error) {
 	git := dag.Git("https://github.com/usememos/memos.git")
 	treeOpts := dagger.GitRefTreeOpts{Depth: 1}
 
+	// clone resolves the commit of a ref and fetches its tree.
+	clone := func(ref *dagger.GitRef) (*dagger.Directory, string, error) {
+		var tree *dagger.Directory
+		var commit string
+		err := retry.Do(ctx, "git clone", func() (err error) {
+			commit, _ = ref.Commit(ctx)
+			tree, err = ref.Tree(treeOpts).Sync(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to clone upstream: %w", err)
+		}
+		return tree, commit, nil
+	}
+
 	if v, err := semver.NewVersion(version); err == nil {
 		verStr := v.String()
-		// Check if this version has a known commit hash to use instead of the tag
-		if commitHash, ok := knownVersionCommits[verStr]; ok {
-			gitSrc = git.Commit(commitHash).Tree(treeOpts)
+		// Check if the version profiles pin a commit to use instead of the tag
+		if commitHash, ok := pinnedCommit(versionProfiles, verStr); ok {
+			gitSrc, _, err = clone(git.Commit(commitHash))
+			if err != nil {
+				return nil, "", "", "", err
+			}
 			srcVersion := m.extractVersionFromSource(ctx, gitSrc)
 			return gitSrc, srcVersion, "v" + verStr, commitHash, nil
 		}
-		ref := git.Tag("v" + verStr)
-		commit, _ = ref.Commit(ctx)
-		gitSrc = ref.Tree(treeOpts)
+		gitSrc, commit, err = clone(git.Tag("v" + verStr))
+		if err != nil {
+			return nil, "", "", "", err
+		}
 		return gitSrc, "v" + verStr, "v" + verStr, commit, nil
 	}
 
 	if after, ok := strings.CutPrefix(version, "release/"); ok {
 		ver := strings.TrimPrefix(after, "v")
-		ref := git.Ref("heads/release/" + ver)
-		commit, _ = ref.Commit(ctx)
-		gitSrc = ref.Tree(treeOpts)
+		gitSrc, commit, err = clone(git.Ref("heads/release/" + ver))
+		if err != nil {
+			return nil, "", "", "", err
+		}
 		return gitSrc, "v" + ver, "v" + ver, commit, nil
 	}
 
 	if commitHashPattern.MatchString(version) {
-		gitSrc = git.Commit(version).Tree(treeOpts)
+		gitSrc, _, err = clone(git.Commit(version))
+		if err != nil {
+			return nil, "", "", "", err
+		}
 		srcVersion := m.extractVersionFromSource(ctx, gitSrc)
 		return gitSrc, srcVersion, srcVersion, version, nil
 	}
 
 	// Use nightly as default version.
-	ref := git.Branch("main")
-	commit, _ = ref.Commit(ctx)
-	gitSrc = ref.Tree(treeOpts)
+	gitSrc, commit, err = clone(git.Branch("main"))
+	if err != nil {
+		return nil, "", "", "", err
+	}
 
 	shortSHA := shortCommitHash(commit)
 	nightlyVer := m.generateNightlyVersion(shortSHA)
@@ -12,7 +12,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -59,7 +61,10 @@ var TARGETS []BuildMatrix = []BuildMatrix{
 var commitHashPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
 var shortCommitHashPattern = regexp.MustCompile(`^[0-9a-fA-F]{9}$`)
 
-type MemosBuilds struct{}
+type MemosBuilds struct {
+	// Replaces engineStages, for tests. Unexported fields are not part of the module's state.
+	stages compileStages
+}
 
 func shortCommitHash(commit string) string {
 	if len(commit) < 9 {
@@ -78,33 +83,282 @@ func (m *MemosBuilds) generateNightlyVersion(shortSHA string) *semver.Version {
 	return nightlyBuildVersion(time.Now().UTC(), shortSHA)
 }
 
-// prepareSource resolves version and applies all patches.
+// preparedSource is the patched upstream source along with its resolved versions.
+type preparedSource struct {
+	Src            *dagger.Directory
+	BuildVersion   string
+	ReleaseVersion string
+	Commit         string
+	// Human-readable comparison of the final module graph with upstream's.
+	DependencyReport string
+	// Go files replacing or extending the source at build time. May be nil.
+	Overlay *sourceOverlay
+	// White-label branding applied to `web/`. May be nil.
+	Branding *brandingManifest
+	// Build images selected for this source.
+	Toolchain *Toolchain
+	// Module proxies of the Go containers.
+	GoProxy *goProxy
+	// Quirks of the upstream release, from the version profiles.
+	Profile *activeProfile
+	// CPU profile for `go build -pgo`. May be nil.
+	PGO *pgoProfile
+	// Built against the Go Cryptographic Module, in FIPS 140-3 mode.
+	FIPS bool
+	// Instrumented with `go build -cover`.
+	Coverage bool
+}
+
+// Labels returns the identifiers that distinguish this build from stock ones.
+func (p *preparedSource) Labels() []string {
+	var labels []string
+	if p.Branding != nil {
+		labels = append(labels, p.Branding.Name)
+	}
+	if p.FIPS {
+		labels = append(labels, fipsLabel)
+	}
+	if p.Coverage {
+		labels = append(labels, coverageLabel)
+	}
+	return labels
+}
+
+// ContainerEnv returns the environment defaults of containers running the binaries.
+func (p *preparedSource) ContainerEnv() map[string]string {
+	if p.FIPS {
+		return withFIPSGoDebug(p.Profile.ContainerEnv)
+	}
+	return p.Profile.ContainerEnv
+}
+
+// ArtifactVersion returns the version used in artifact file names, prefixed with the build labels.
+//
+// E.g. "v0.25.3", "acme-v0.25.3" for a build branded as "acme", or "fips-v0.25.3" for a FIPS build.
+func (p *preparedSource) ArtifactVersion() string {
+	return strings.Join(append(p.Labels(), p.BuildVersion), "-")
+}
+
+// ArtifactName returns the base name of container artifacts.
+//
+// E.g. "memos", or "memos-acme" for a build branded as "acme".
+func (p *preparedSource) ArtifactName() string {
+	return strings.Join(append([]string{"memos"}, p.Labels()...), "-")
+}
+
+// buildOptions holds optional inputs of the build entrypoints.
+type buildOptions struct {
+	// Prebuilt frontend replacing buildFrontend's output. May be nil.
+	FrontendDist *dagger.Directory
+	// Embed a placeholder page instead of the frontend, for API-only deployments.
+	Headless bool
+	// White-label branding directory, applied to `web/` before building the frontend. May be nil.
+	Branding *dagger.Directory
+	// Build image overrides, as comma-separated `tool=image` pairs (tools: go, node, buf).
+	Toolchain string
+	// Number of targets compiled at once. Zero selects defaultConcurrency.
+	Concurrency int
+	// Build every target possible and report failures instead of stopping at the first one.
+	KeepGoing bool
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs.
+	Retry string
+	// GOPROXY list of the module downloads. Empty keeps the Go toolchain default.
+	GoProxy string
+	// Local module proxy tree, served to the module downloads. May be nil.
+	GoProxyDir *dagger.Directory
+	// CPU profile for `go build -pgo`, overriding the ones under `pgo/`. May be nil.
+	PGO *dagger.File
+	// Also produce unstripped binaries and frontend source maps, shipped as separate archives.
+	DebugSymbols bool
+	// Run or inspect every binary before archiving it.
+	SmokeTest bool
+	// Sizes to compare with, overriding the committed baseline. May be nil.
+	SizeBaseline *dagger.File
+	// Allowed size growth, as comma-separated `key=value` pairs (warn, fail).
+	SizeBudget string
+	// Build position-independent or static executables with the internal linker, and verify them.
+	Hardened bool
+	// Build against the Go Cryptographic Module, with FIPS 140-3 mode on by default.
+	FIPS bool
+	// Instrument the upstream packages with `go build -cover`, for end-to-end coverage.
+	Coverage bool
+	// Also produce native packages of the Linux targets (.deb, .rpm, .apk, .pkg.tar.zst).
+	Packages bool
+	// Authenticode certificate and key signing the Windows binaries. Both nil when unsigned.
+	AuthenticodeCertificate *dagger.Secret
+	AuthenticodeKey         *dagger.Secret
+	// Passphrase of the Authenticode key. May be nil.
+	AuthenticodePassphrase *dagger.Secret
+	// RFC 3161 timestamping service. Empty selects AUTHENTICODE_TIMESTAMP_URL.
+	TimestampURL string
+	// Leave Authenticode signatures untimestamped, for offline builds.
+	SkipTimestamp bool
+}
+
+// validate reports conflicting options.
+func (o buildOptions) validate() error {
+	if o.Headless && o.FrontendDist != nil {
+		return fmt.Errorf("headless builds cannot use a prebuilt frontend dist")
+	}
+	if o.Branding != nil && o.FrontendMode() != frontendModeBuilt {
+		return fmt.Errorf("branding requires building the frontend from source")
+	}
+	if _, err := parseToolchainOverrides(o.Toolchain); err != nil {
+		return err
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if _, err := parseRetryPolicy(o.Retry); err != nil {
+		return err
+	}
+	if _, err := newGoProxy(o.GoProxy, o.GoProxyDir); err != nil {
+		return err
+	}
+	if _, err := parseSizeBudget(o.SizeBudget); err != nil {
+		return err
+	}
+	if (o.AuthenticodeCertificate == nil) != (o.AuthenticodeKey == nil) {
+		return fmt.Errorf("authenticode signing requires both a certificate and a key")
+	}
+	if o.AuthenticodeCertificate == nil && (o.AuthenticodePassphrase != nil || o.TimestampURL != "" || o.SkipTimestamp) {
+		return fmt.Errorf("authenticode options require a certificate and a key")
+	}
+	if _, err := authenticodeTimestampURL(o.TimestampURL, o.SkipTimestamp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetryPolicy returns the parsed retry policy. Options must have been validated.
+func (o buildOptions) RetryPolicy() retryPolicy {
+	p, _ := parseRetryPolicy(o.Retry)
+	return p
+}
+
+// ModuleProxy returns the module proxy configuration. Options must have been validated.
+func (o buildOptions) ModuleProxy() *goProxy {
+	p, _ := newGoProxy(o.GoProxy, o.GoProxyDir)
+	return p
+}
+
+// Authenticode returns the signing settings of Windows binaries, or nil when they are unsigned.
+// Options must have been validated.
+func (o buildOptions) Authenticode() *authenticodeOptions {
+	if o.AuthenticodeCertificate == nil {
+		return nil
+	}
+	timestampURL, _ := authenticodeTimestampURL(o.TimestampURL, o.SkipTimestamp)
+	return &authenticodeOptions{
+		Certificate:  o.AuthenticodeCertificate,
+		Key:          o.AuthenticodeKey,
+		Passphrase:   o.AuthenticodePassphrase,
+		TimestampURL: timestampURL,
+	}
+}
+
+// SizeLimits returns the parsed size budget. Options must have been validated.
+func (o buildOptions) SizeLimits() sizeBudget {
+	b, _ := parseSizeBudget(o.SizeBudget)
+	return b
+}
+
+// FrontendMode returns how the embedded frontend is obtained.
+func (o buildOptions) FrontendMode() string {
+	switch {
+	case o.Headless:
+		return frontendModeHeadless
+	case o.FrontendDist != nil:
+		return frontendModePrebuilt
+	default:
+		return frontendModeBuilt
+	}
+}
+
+// prepareSource resolves version, applies all patches and pins the patched dependencies.
 func (m *MemosBuilds) prepareSource(
 	ctx context.Context,
 	source *dagger.Directory,
 	version string,
-) (*dagger.Directory, string, string, string, error) {
+	opts buildOptions,
+) (*preparedSource, error) {
 	if source == nil {
-		return nil, "", "", "", fmt.Errorf("source directory must be passed explicitly by the user")
+		return nil, fmt.Errorf("source directory must be passed explicitly by the user")
 	}
 
-	gitSrc, buildVersion, releaseVersion, commit, err := m.resolveVersion(ctx, version)
+	upstreamSrc, buildVersion, releaseVersion, commit, err := m.resolveVersion(ctx, version, opts.RetryPolicy())
 	if err != nil {
-		return nil, "", "", "", err
+		return nil, err
 	}
 
-	gitSrc, err = m.patchModerncSqlite(ctx, gitSrc)
+	gitSrc, err := m.patchModerncSqlite(ctx, upstreamSrc, opts.ModuleProxy(), opts.RetryPolicy())
 	if err != nil {
-		return nil, "", "", "", fmt.Errorf("failed to patch go.mod: %w", err)
+		return nil, fmt.Errorf("failed to patch go.mod: %w", err)
 	}
 
+	profile := matchVersionProfiles(versionProfiles, m.upstreamVersion(ctx, version, upstreamSrc))
+
 	patchesDir := source.Directory("patches")
-	gitSrc, err = m.applyPatches(ctx, gitSrc, patchesDir)
+	gitSrc, err = m.applyPatches(ctx, gitSrc, patchesDir, opts.RetryPolicy())
 	if err != nil {
-		return nil, "", "", "", fmt.Errorf("failed to apply patches: %w", err)
+		return nil, fmt.Errorf("failed to apply patches: %w", err)
+	}
+	for _, dir := range profile.Patches {
+		gitSrc, err = m.applyPatches(ctx, gitSrc, patchesDir.Directory(dir), opts.RetryPolicy())
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply patches/%s: %w", dir, err)
+		}
 	}
 
-	return gitSrc, buildVersion, releaseVersion, commit, nil
+	userOverrides, err := parseToolchainOverrides(opts.Toolchain)
+	if err != nil {
+		return nil, err
+	}
+	overrides := maps.Clone(profile.Toolchain)
+	maps.Copy(overrides, userOverrides)
+	toolchain, err := m.detectToolchain(ctx, gitSrc, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect toolchain: %w", err)
+	}
+
+	gitSrc, report, err := m.resolveDependencies(ctx, toolchain.Go.Image, opts.ModuleProxy(), upstreamSrc, gitSrc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	overlay, err := m.loadOverlay(ctx, source.Directory("overlays"), profile.Overlays, gitSrc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlays: %w", err)
+	}
+
+	pgo, err := m.resolvePgoProfile(ctx, source, profile, opts.PGO)
+	if err != nil {
+		return nil, err
+	}
+
+	var branding *brandingManifest
+	if opts.Branding != nil {
+		gitSrc, branding, err = m.applyBranding(ctx, opts.Branding, gitSrc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branding: %w", err)
+		}
+	}
+
+	return &preparedSource{
+		Src:              gitSrc,
+		BuildVersion:     buildVersion,
+		ReleaseVersion:   releaseVersion,
+		Commit:           commit,
+		DependencyReport: report,
+		Overlay:          overlay,
+		Branding:         branding,
+		Toolchain:        toolchain,
+		GoProxy:          opts.ModuleProxy(),
+		Profile:          profile,
+		PGO:              pgo,
+		FIPS:             opts.FIPS,
+		Coverage:         opts.Coverage,
+	}, nil
 }
 
 // Build compiles Memos binaries, creates release archives, and generates checksums.
@@ -113,49 +367,338 @@ func (m *MemosBuilds) Build(
 	source *dagger.Directory,
 	version string,
 	platforms string,
+	// Prebuilt frontend (the `server/router/frontend/dist` output of `pnpm run release`) to embed instead of building it.
+	// +optional
+	frontendDist *dagger.Directory,
+	// Embed a placeholder page instead of the frontend, for API-only deployments.
+	// +optional
+	headless bool,
+	// White-label branding directory with a `branding.json` manifest. See `.dagger/README.md`.
+	// +optional
+	branding *dagger.Directory,
+	// Build image overrides as comma-separated `tool=image` pairs (e.g. "go=golang:1.26.2-alpine,node=node:22-alpine").
+	// Images are otherwise derived from the upstream source.
+	// +optional
+	toolchain string,
+	// Number of targets compiled at once. Defaults to NumCPU-1, or NumCPU when CI=true.
+	// +optional
+	concurrency int,
+	// Build every target possible, shipping the successful ones along with a failure summary.
+	// Callers must fail when the returned directory holds `memos-<version>_build-failures.txt`.
+	// +optional
+	keepGoing bool,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads (e.g. "https://goproxy.example.com,off"). Defaults to the Go toolchain's.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy (the default when only this is given).
+	// +optional
+	goproxyDir *dagger.Directory,
+	// CPU profile for profile-guided optimization, overriding `pgo/<version>.pgo` and `pgo/default.pgo`.
+	// +optional
+	pgo *dagger.File,
+	// Also ship unstripped binaries and frontend source maps as separate `-debug` archives.
+	// +optional
+	debugSymbols bool,
+	// Run each Linux binary (under emulation when needed) and validate the format of the others before archiving.
+	// +optional
+	smokeTest bool,
+	// Sizes to compare the build with (a previous `_sizes.json`). Defaults to `.dagger/size-baseline.json`.
+	// +optional
+	sizeBaseline *dagger.File,
+	// Allowed growth over the baseline, as comma-separated `key=value` pairs (warn, fail). Defaults to "warn=5%".
+	// +optional
+	sizeBudget string,
+	// Build PIEs where supported (static executables elsewhere) with the internal linker, and verify every binary.
+	// +optional
+	hardened bool,
+	// Build against the Go Cryptographic Module in FIPS 140-3 mode, as `fips`-labelled artifacts and image tags.
+	// +optional
+	fips bool,
+	// Instrument the upstream packages with `go build -cover`, as `coverage`-labelled artifacts and image tags.
+	// Images write coverage data to GOCOVERDIR. See `collect-coverage`.
+	// +optional
+	coverage bool,
+	// Also produce .deb, .rpm, .apk and Arch packages of the Linux targets. See `packages`.
+	// +optional
+	packages bool,
+	// PEM certificate (followed by its intermediates) signing the Windows binaries with Authenticode.
+	// +optional
+	authenticodeCertificate *dagger.Secret,
+	// PEM private key of the Authenticode certificate.
+	// +optional
+	authenticodeKey *dagger.Secret,
+	// Passphrase of the Authenticode key.
+	// +optional
+	authenticodePassphrase *dagger.Secret,
+	// RFC 3161 timestamping service of Authenticode signatures. Defaults to "http://timestamp.digicert.com".
+	// +optional
+	timestampUrl string,
+	// Leave Authenticode signatures untimestamped, for offline builds.
+	// +optional
+	skipTimestamp bool,
 ) (*dagger.Directory, error) {
-	out, _, _, _, _, err := m.buildInternal(ctx, source, version, platforms)
+	opts := buildOptions{
+		FrontendDist: frontendDist,
+		Headless:     headless,
+		Branding:     branding,
+		Toolchain:    toolchain,
+		Concurrency:  concurrency,
+		KeepGoing:    keepGoing,
+		Retry:        retry,
+		GoProxy:      goproxy,
+		GoProxyDir:   goproxyDir,
+		PGO:          pgo,
+		DebugSymbols: debugSymbols,
+		SmokeTest:    smokeTest,
+		SizeBaseline: sizeBaseline,
+		SizeBudget:   sizeBudget,
+		Hardened:     hardened,
+		FIPS:         fips,
+		Coverage:     coverage,
+		Packages:     packages,
+
+		AuthenticodeCertificate: authenticodeCertificate,
+		AuthenticodeKey:         authenticodeKey,
+		AuthenticodePassphrase:  authenticodePassphrase,
+		TimestampURL:            timestampUrl,
+		SkipTimestamp:           skipTimestamp,
+	}
+	out, _, err := m.buildInternal(ctx, source, version, platforms, opts)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
+// buildResult holds the intermediate outputs of a build, so later stages can reuse them.
+type buildResult struct {
+	Prepared *preparedSource
+	// Targets that were built successfully, in TARGETS order.
+	Targets []BuildMatrix
+	// Binaries, named by BuildMatrix.BinaryName.
+	Binaries *dagger.Directory
+	// Frontend embedded in the binaries.
+	FrontendDist *dagger.Directory
+	Timings      []targetTiming
+	// Targets that failed, in keep-going mode.
+	Failures []targetFailure
+	// Debug builds, in a subdirectory per BuildMatrix.BinaryName. Nil unless requested.
+	DebugSymbols *dagger.Directory
+	// Source maps split from FrontendDist. Nil unless requested and the frontend was built.
+	SourceMaps *dagger.Directory
+}
+
+// ReplaceBinary swaps the release binary of a target for a processed one, such as a
+// signed binary, keeping its debug bundle tied to it.
+func (b *buildResult) ReplaceBinary(t BuildMatrix, binary *dagger.File) {
+	b.Binaries = b.Binaries.WithFile(t.BinaryName(), binary)
+	if b.DebugSymbols != nil {
+		bundle := rebindDebugBundle(b.DebugSymbols.Directory(t.BinaryName()), binary, t, b.Prepared.Toolchain.Go.Image)
+		b.DebugSymbols = b.DebugSymbols.WithDirectory(t.BinaryName(), bundle)
+	}
+}
+
+// compile generates the proto code, resolves the frontend and compiles the backend for the given targets.
+//
+// Every other stage works off its result, so each target is compiled once per call.
+func (m *MemosBuilds) compile(
+	ctx context.Context,
+	prepared *preparedSource,
+	targets []BuildMatrix,
+	opts buildOptions,
+) (*buildResult, error) {
+	stages := m.compileStages()
+	gitSrc := stages.GenerateProto(prepared.Src, prepared.Toolchain.Buf.Image)
+	frontendDist, err := stages.Frontend(ctx, gitSrc, prepared.Toolchain.Node.Image, opts)
+	if err != nil {
+		return nil, err
+	}
+	var sourceMaps *dagger.Directory
+	if opts.DebugSymbols && opts.FrontendMode() == frontendModeBuilt {
+		frontendDist, sourceMaps = splitSourceMaps(frontendDist)
+	}
+
+	result, err := m.buildBackend(ctx, gitSrc, frontendDist, prepared, targets, opts)
+	if err != nil {
+		return nil, err
+	}
+	if prepared.FIPS {
+		if err := m.verifyFIPSBuild(ctx, result, opts); err != nil {
+			return nil, err
+		}
+	}
+	result.Prepared = prepared
+	result.FrontendDist = frontendDist
+	result.SourceMaps = sourceMaps
+	return result, nil
+}
+
 // buildInternal is the core build logic.
-// It returns artifacts, source, build version, release version, source commit, and an error.
+// It returns artifacts, the intermediate build outputs, and an error.
 func (m *MemosBuilds) buildInternal(
 	ctx context.Context,
 	source *dagger.Directory,
 	version string,
 	platforms string,
-) (*dagger.Directory, *dagger.Directory, string, string, string, error) {
+	opts buildOptions,
+) (*dagger.Directory, *buildResult, error) {
 	if version == "" {
 		version = "nightly"
 	}
 
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+
 	targets, err := filterTargets(platforms)
 	if err != nil {
-		return nil, nil, "", "", "", fmt.Errorf("invalid platforms: %w", err)
+		return nil, nil, fmt.Errorf("invalid platforms: %w", err)
+	}
+
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets, err = prepared.Profile.Buildable(targets, platforms)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	gitSrc, buildVersion, releaseVersion, commit, err := m.prepareSource(ctx, source, version)
+	// Checked before compiling, as it depends on the layout of the guides in docs/.
+	bundle, err := m.loadArchiveBundle(ctx, source, prepared)
 	if err != nil {
-		return nil, nil, "", "", "", err
+		return nil, nil, fmt.Errorf("failed to prepare archive contents: %w", err)
 	}
 
-	gitSrc = m.generateProto(gitSrc)
-	frontendDist := m.buildFrontend(gitSrc)
+	build, err := m.compile(ctx, prepared, targets, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata, err := newBuildMetadata(prepared, opts, build.Timings).JSON()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialise build metadata: %w", err)
+	}
+
+	artifactVersion := prepared.ArtifactVersion()
+	var hardeningReport string
+	if opts.Hardened {
+		results := m.verifyHardenedBuild(ctx, build, opts)
+		hardeningReport = formatHardeningReport(artifactVersion, results)
+		var passed []BuildMatrix
+		for _, r := range results {
+			err := r.Failed()
+			if err == nil {
+				passed = append(passed, r.Target)
+				continue
+			}
+			if !opts.KeepGoing {
+				return nil, nil, fmt.Errorf("hardening checks failed:\n%s", hardeningReport)
+			}
+			build.Failures = append(build.Failures, newTargetFailure(r.Target, failureStageHardening, err))
+		}
+		build.Targets = passed
+	}
+
+	var smokeTestReport string
+	if opts.SmokeTest {
+		results := m.smokeTest(ctx, build, opts)
+		smokeTestReport = formatSmokeTestReport(artifactVersion, results)
+		var passed []BuildMatrix
+		for _, r := range results {
+			if r.Err == nil {
+				passed = append(passed, r.Target)
+				continue
+			}
+			if !opts.KeepGoing {
+				return nil, nil, fmt.Errorf("smoke tests failed:\n%s", smokeTestReport)
+			}
+			build.Failures = append(build.Failures, newTargetFailure(r.Target, failureStageSmokeTest, r.Err))
+		}
+		build.Targets = passed
+	}
+
+	if signing := opts.Authenticode(); signing != nil {
+		results, err := m.signWindowsBinaries(ctx, build, signing, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		failed := map[BuildMatrix]bool{}
+		for _, r := range results {
+			if r.Err == nil {
+				build.ReplaceBinary(r.Target, r.Binary)
+				continue
+			}
+			if !opts.KeepGoing {
+				return nil, nil, r.Err
+			}
+			failed[r.Target] = true
+			build.Failures = append(build.Failures, newTargetFailure(r.Target, failureStageSigning, r.Err))
+		}
+		build.Targets = slices.DeleteFunc(build.Targets, func(t BuildMatrix) bool { return failed[t] })
+	}
+
+	archiver, err := m.archiveTools(ctx, opts.RetryPolicy())
+	if err != nil {
+		return nil, nil, err
+	}
+	var archives *dagger.Directory
+	if opts.KeepGoing {
+		archives = m.archiveEach(ctx, build, bundle, artifactVersion, archiver)
+	} else {
+		archives, err = m.createReleaseArchives(ctx, build, bundle, artifactVersion, archiver)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(build.Targets) == 0 {
+		return nil, nil, fmt.Errorf("every target failed:\n%s", formatFailureSummary(artifactVersion, build.Failures, 0))
+	}
+
+	if opts.Packages {
+		packages, err := m.packageEach(ctx, build, bundle, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create packages: %w", err)
+		}
+		archives = archives.WithDirectory(".", packages)
+	}
 
-	binaries, err := m.buildBackend(ctx, gitSrc, frontendDist, buildVersion, commit, targets)
+	sizes, sizeReport, err := m.trackSizes(ctx, source, build, archives, artifactVersion, opts)
 	if err != nil {
-		return nil, nil, "", "", "", err
+		return nil, nil, err
 	}
 
-	archives := m.createReleaseArchives(binaries, buildVersion, targets)
-	checksums := m.generateChecksums(archives, buildVersion)
-	out := archives.WithFile(fmt.Sprintf(buildconsts.CHECKSUM_FILE_FORMAT, buildVersion), checksums)
+	checksumFile := fmt.Sprintf(buildconsts.CHECKSUM_FILE_FORMAT, artifactVersion)
+	out := archives.
+		WithFile(checksumFile, m.generateChecksums(archives, checksumFile)).
+		WithNewFile(fmt.Sprintf(buildconsts.DEPENDENCY_DRIFT_FILE_FORMAT, artifactVersion), prepared.DependencyReport).
+		WithNewFile(fmt.Sprintf(buildconsts.METADATA_FILE_FORMAT, artifactVersion), metadata).
+		WithNewFile(fmt.Sprintf(buildconsts.SIZES_FILE_FORMAT, artifactVersion), sizes).
+		WithNewFile(fmt.Sprintf(buildconsts.SIZE_REPORT_FILE_FORMAT, artifactVersion), sizeReport)
+	if len(build.Failures) > 0 {
+		summary := formatFailureSummary(artifactVersion, build.Failures, len(build.Targets))
+		out = out.WithNewFile(fmt.Sprintf(buildconsts.FAILURES_FILE_FORMAT, artifactVersion), summary)
+	}
+	if smokeTestReport != "" {
+		out = out.WithNewFile(fmt.Sprintf(buildconsts.SMOKE_TEST_FILE_FORMAT, artifactVersion), smokeTestReport)
+	}
+	if hardeningReport != "" {
+		out = out.WithNewFile(fmt.Sprintf(buildconsts.HARDENING_FILE_FORMAT, artifactVersion), hardeningReport)
+	}
+	// Debug archives have their own checksums, so the release ones can be verified without downloading them.
+	if debugArchives := m.createDebugArchives(build, artifactVersion, archiver); debugArchives != nil {
+		debugChecksumFile := fmt.Sprintf(buildconsts.DEBUG_CHECKSUM_FILE_FORMAT, artifactVersion)
+		out = out.
+			WithDirectory(".", debugArchives).
+			WithFile(debugChecksumFile, m.generateChecksums(debugArchives, debugChecksumFile))
+	}
 
-	return out, gitSrc, buildVersion, releaseVersion, commit, nil
+	return out, build, nil
 }
 
 // Publish builds release artifacts and optionally publishes containers.
@@ -167,14 +710,124 @@ func (m *MemosBuilds) Publish(
 	dockerHubPassword *dagger.Secret,
 	ghcrUser string,
 	ghcrPassword *dagger.Secret,
+	// White-label branding directory with a `branding.json` manifest. See `.dagger/README.md`.
+	// +optional
+	branding *dagger.Directory,
+	// Build image overrides as comma-separated `tool=image` pairs (e.g. "go=golang:1.26.2-alpine,node=node:22-alpine").
+	// Images are otherwise derived from the upstream source.
+	// +optional
+	toolchain string,
+	// Number of targets compiled at once. Defaults to NumCPU-1, or NumCPU when CI=true.
+	// +optional
+	concurrency int,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads (e.g. "https://goproxy.example.com,off"). Defaults to the Go toolchain's.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy (the default when only this is given).
+	// +optional
+	goproxyDir *dagger.Directory,
+	// CPU profile for profile-guided optimization, overriding `pgo/<version>.pgo` and `pgo/default.pgo`.
+	// +optional
+	pgo *dagger.File,
+	// Also ship unstripped binaries and frontend source maps as separate `-debug` archives.
+	// +optional
+	debugSymbols bool,
+	// Run each Linux binary (under emulation when needed) and validate the format of the others before archiving.
+	// +optional
+	smokeTest bool,
+	// Sizes to compare the build with (a previous `_sizes.json`). Defaults to `.dagger/size-baseline.json`.
+	// +optional
+	sizeBaseline *dagger.File,
+	// Allowed growth over the baseline, as comma-separated `key=value` pairs (warn, fail). Defaults to "warn=5%".
+	// +optional
+	sizeBudget string,
+	// Build PIEs where supported (static executables elsewhere) with the internal linker, and verify every binary.
+	// +optional
+	hardened bool,
+	// Build against the Go Cryptographic Module in FIPS 140-3 mode, as `fips`-labelled artifacts and image tags.
+	// +optional
+	fips bool,
+	// Instrument the upstream packages with `go build -cover`, as `coverage`-labelled artifacts and image tags.
+	// Images write coverage data to GOCOVERDIR. See `collect-coverage`.
+	// +optional
+	coverage bool,
+	// Also produce .deb, .rpm, .apk and Arch packages of the Linux targets. See `packages`.
+	// +optional
+	packages bool,
+	// PEM certificate (followed by its intermediates) signing the Windows binaries with Authenticode.
+	// +optional
+	authenticodeCertificate *dagger.Secret,
+	// PEM private key of the Authenticode certificate.
+	// +optional
+	authenticodeKey *dagger.Secret,
+	// Passphrase of the Authenticode key.
+	// +optional
+	authenticodePassphrase *dagger.Secret,
+	// RFC 3161 timestamping service of Authenticode signatures. Defaults to "http://timestamp.digicert.com".
+	// +optional
+	timestampUrl string,
+	// Leave Authenticode signatures untimestamped, for offline builds.
+	// +optional
+	skipTimestamp bool,
+	// Run the upstream Go tests, natively and under emulation for linux/s390x, refusing to publish when they fail.
+	// +optional
+	test bool,
 ) (*dagger.Directory, error) {
-	out, gitSrc, buildVersion, releaseVersion, commit, err := m.buildInternal(ctx, source, version, "")
+	opts := buildOptions{
+		Branding:     branding,
+		Toolchain:    toolchain,
+		Concurrency:  concurrency,
+		Retry:        retry,
+		GoProxy:      goproxy,
+		GoProxyDir:   goproxyDir,
+		PGO:          pgo,
+		DebugSymbols: debugSymbols,
+		SmokeTest:    smokeTest,
+		SizeBaseline: sizeBaseline,
+		SizeBudget:   sizeBudget,
+		Hardened:     hardened,
+		FIPS:         fips,
+		Coverage:     coverage,
+		Packages:     packages,
+
+		AuthenticodeCertificate: authenticodeCertificate,
+		AuthenticodeKey:         authenticodeKey,
+		AuthenticodePassphrase:  authenticodePassphrase,
+		TimestampURL:            timestampUrl,
+		SkipTimestamp:           skipTimestamp,
+	}
+	out, build, err := m.buildInternal(ctx, source, version, "", opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build: %w", err)
 	}
 
+	if test {
+		testOn, err := testPlatforms(ctx, defaultTestPlatforms)
+		if err != nil {
+			return nil, err
+		}
+		runs, err := m.testUpstream(ctx, build.Prepared, testOn, opts.RetryPolicy())
+		if err != nil {
+			return nil, err
+		}
+		artifactVersion := build.Prepared.ArtifactVersion()
+		results, failed, err := testResults(artifactVersion, runs)
+		if err != nil {
+			return nil, err
+		}
+		if failed {
+			return nil, fmt.Errorf("upstream tests failed, refusing to publish:\n%s", formatTestSummary(artifactVersion, runs))
+		}
+		out = out.WithDirectory(".", results)
+	}
+
 	if (dockerHubUser != "" && dockerHubPassword != nil) || (ghcrUser != "" && ghcrPassword != nil) {
-		images, err := m.publishContainers(ctx, source, gitSrc, buildVersion, releaseVersion, commit, dockerHubUser, dockerHubPassword, ghcrUser, ghcrPassword)
+		// Containers reuse the binaries compiled for the release archives.
+		images, err := m.publishContainers(ctx, source, build, dockerHubUser, dockerHubPassword, ghcrUser, ghcrPassword, opts.RetryPolicy())
 		if err != nil {
 			return nil, fmt.Errorf("failed to publish containers: %w", err)
 		}
@@ -197,11 +850,64 @@ func (m *MemosBuilds) BuildContainers(
 	source *dagger.Directory,
 	version string,
 	platforms string,
+	// Prebuilt frontend (the `server/router/frontend/dist` output of `pnpm run release`) to embed instead of building it.
+	// +optional
+	frontendDist *dagger.Directory,
+	// Embed a placeholder page instead of the frontend, for API-only deployments.
+	// +optional
+	headless bool,
+	// White-label branding directory with a `branding.json` manifest. See `.dagger/README.md`.
+	// +optional
+	branding *dagger.Directory,
+	// Build image overrides as comma-separated `tool=image` pairs (e.g. "go=golang:1.26.2-alpine,node=node:22-alpine").
+	// Images are otherwise derived from the upstream source.
+	// +optional
+	toolchain string,
+	// Number of targets compiled at once. Defaults to NumCPU-1, or NumCPU when CI=true.
+	// +optional
+	concurrency int,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads (e.g. "https://goproxy.example.com,off"). Defaults to the Go toolchain's.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy (the default when only this is given).
+	// +optional
+	goproxyDir *dagger.Directory,
+	// CPU profile for profile-guided optimization, overriding `pgo/<version>.pgo` and `pgo/default.pgo`.
+	// +optional
+	pgo *dagger.File,
+	// Build against the Go Cryptographic Module in FIPS 140-3 mode, as `fips`-labelled artifacts and image tags.
+	// +optional
+	fips bool,
+	// Instrument the upstream packages with `go build -cover`, as `coverage`-labelled artifacts and image tags.
+	// Images write coverage data to GOCOVERDIR. See `collect-coverage`.
+	// +optional
+	coverage bool,
 ) (*dagger.Directory, error) {
 	if version == "" {
 		version = "nightly"
 	}
 
+	opts := buildOptions{
+		FrontendDist: frontendDist,
+		Headless:     headless,
+		Branding:     branding,
+		Toolchain:    toolchain,
+		Concurrency:  concurrency,
+		Retry:        retry,
+		GoProxy:      goproxy,
+		GoProxyDir:   goproxyDir,
+		PGO:          pgo,
+		FIPS:         fips,
+		Coverage:     coverage,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
 	targets, err := filterTargets(platforms)
 	if err != nil {
 		return nil, fmt.Errorf("invalid platforms: %w", err)
@@ -212,23 +918,117 @@ func (m *MemosBuilds) BuildContainers(
 		return nil, fmt.Errorf("no Linux platforms in the selected targets")
 	}
 
-	gitSrc, buildVersion, _, commit, err := m.prepareSource(ctx, source, version)
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	containerTargets, err = prepared.Profile.Buildable(containerTargets, platforms)
 	if err != nil {
 		return nil, err
 	}
 
-	containers, err := m.buildContainers(ctx, source, gitSrc, buildVersion, commit, containerTargets)
+	build, err := m.compile(ctx, prepared, containerTargets, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build binaries: %w", err)
+	}
+	containers, err := m.assembleContainers(ctx, source, build, containerTargets, opts.RetryPolicy())
 	if err != nil {
 		return nil, err
 	}
 
-	out := dag.Directory()
+	metadata, err := newBuildMetadata(prepared, opts, build.Timings).JSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise build metadata: %w", err)
+	}
+
+	artifactVersion := prepared.ArtifactVersion()
+	out := dag.Directory().
+		WithNewFile(fmt.Sprintf(buildconsts.DEPENDENCY_DRIFT_FILE_FORMAT, artifactVersion), prepared.DependencyReport).
+		WithNewFile(fmt.Sprintf(buildconsts.METADATA_FILE_FORMAT, artifactVersion), metadata)
 	for i, ctr := range containers {
 		t := containerTargets[i]
 		platform := strings.ReplaceAll(t.DockerPlatform(), "/", "-")
-		imageName := fmt.Sprintf("memos-%s.tar", platform)
+		imageName := fmt.Sprintf("%s-%s.tar", prepared.ArtifactName(), platform)
 		out = out.WithFile(imageName, ctr.AsTarball())
 	}
 
 	return out, nil
 }
+
+// UpdateSqliteLibcMap resolves modernc.org/libc versions for the given modernc.org/sqlite
+// versions through the Go module proxy and returns an updated `sqlite-libc.json`.
+//
+// Export the result to `.dagger/sqlite-libc.json` and commit it.
+func (m *MemosBuilds) UpdateSqliteLibcMap(
+	ctx context.Context,
+	source *dagger.Directory,
+	// Comma-separated modernc.org/sqlite versions (e.g. "v1.52.0,v1.53.0").
+	// Defaults to the version required by the upstream main branch.
+	// +optional
+	versions string,
+	// GOPROXY list to query. Defaults to "https://proxy.golang.org,direct", or to --goproxy-dir when given.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy.
+	// +optional
+	goproxyDir *dagger.Directory,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+) (*dagger.File, error) {
+	if source == nil {
+		return nil, fmt.Errorf("source directory must be passed explicitly by the user")
+	}
+	policy, err := parseRetryPolicy(retry)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := newGoProxy(goproxy, goproxyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := source.File(".dagger/sqlite-libc.json").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sqlite-libc.json: %w", err)
+	}
+	entries, err := parseSqliteLibcEntries([]byte(current))
+	if err != nil {
+		return nil, err
+	}
+
+	var sqliteVersions []string
+	for v := range strings.SplitSeq(versions, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			sqliteVersions = append(sqliteVersions, v)
+		}
+	}
+	if len(sqliteVersions) == 0 {
+		gitSrc, _, _, _, err := m.resolveVersion(ctx, "nightly", policy)
+		if err != nil {
+			return nil, err
+		}
+		goMod, err := gitSrc.File("go.mod").Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream go.mod: %w", err)
+		}
+		v, found := getSqliteVersion(goMod)
+		if !found {
+			return nil, fmt.Errorf("upstream go.mod does not require %s", sqliteModulePath)
+		}
+		sqliteVersions = append(sqliteVersions, v)
+	}
+
+	entries, err = updateSqliteLibcEntries(ctx, goProxyClient, proxy, entries, sqliteVersions, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := marshalSqliteLibcEntries(entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise sqlite-libc map: %w", err)
+	}
+	return dag.File("sqlite-libc.json", contents), nil
+}
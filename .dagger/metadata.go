@@ -0,0 +1,65 @@
+// # Build metadata.
+//
+// Records the inputs that shaped a build, shipped alongside the release artifacts.
+package main
+
+import (
+	"dagger/memos-builds/buildconsts"
+	"encoding/json"
+)
+
+// BuildMetadata describes how a set of release artifacts was produced.
+type BuildMetadata struct {
+	Version   string         `json:"version"`             // e.g. "v0.25.3"
+	Commit    string         `json:"commit,omitempty"`    // upstream source commit
+	Overlays  []string       `json:"overlays,omitempty"`  // source files replaced or added via `go build -overlay`
+	Frontend  string         `json:"frontend"`            // "built", "prebuilt" or "headless"
+	Branding  string         `json:"branding,omitempty"`  // white-label branding name
+	Toolchain *Toolchain     `json:"toolchain,omitempty"` // build images selected for the upstream source, and why
+	Profiles  []string       `json:"profiles,omitempty"`  // constraints of the version profiles applied
+	Timings   []targetTiming `json:"timings,omitempty"`   // compile time of each target, in target order
+	PGO       *pgoProfile    `json:"pgo,omitempty"`       // CPU profile the binaries were optimized with
+	FIPS      string         `json:"fips,omitempty"`      // Go Cryptographic Module version (GOFIPS140)
+	Coverage  bool           `json:"coverage,omitempty"`  // instrumented with `go build -cover`
+	// Timestamping service of the Authenticode signatures of Windows binaries, or "untimestamped".
+	Authenticode string `json:"authenticode,omitempty"`
+}
+
+// newBuildMetadata returns the metadata for a prepared source built with the given options.
+func newBuildMetadata(prepared *preparedSource, opts buildOptions, timings []targetTiming) BuildMetadata {
+	metadata := BuildMetadata{
+		Version:   prepared.BuildVersion,
+		Commit:    prepared.Commit,
+		Overlays:  prepared.Overlay.Files(),
+		Frontend:  opts.FrontendMode(),
+		Toolchain: prepared.Toolchain,
+		Timings:   timings,
+		PGO:       prepared.PGO,
+		Coverage:  prepared.Coverage,
+	}
+	if prepared.Profile != nil {
+		metadata.Profiles = prepared.Profile.Matched
+	}
+	if prepared.Branding != nil {
+		metadata.Branding = prepared.Branding.Name
+	}
+	if prepared.FIPS {
+		metadata.FIPS = buildconsts.FIPS_MODULE_VERSION
+	}
+	if signing := opts.Authenticode(); signing != nil {
+		metadata.Authenticode = signing.TimestampURL
+		if metadata.Authenticode == "" {
+			metadata.Authenticode = "untimestamped"
+		}
+	}
+	return metadata
+}
+
+// JSON returns the metadata serialised for writing to the dist directory.
+func (b BuildMetadata) JSON() (string, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
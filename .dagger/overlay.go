@@ -0,0 +1,195 @@
+// # Source overlays.
+//
+// Full-file replacements passed to `go build -overlay`, as an alternative to diff patches.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Lists overlay files that do not exist upstream, one path per line.
+const overlayNewFilesList = "NEW_FILES"
+
+// Where overlay files are mounted in the build container.
+const overlayMountPath = "/overlays"
+
+// sourceOverlay is a validated set of Go files replacing or extending the upstream source.
+type sourceOverlay struct {
+	// Overlay files, laid out with the same paths as the upstream source.
+	Dir *dagger.Directory
+	// Paths of replaced upstream files, relative to the source root.
+	Replaced []string
+	// Paths of files added on top of upstream, relative to the source root.
+	Added []string
+}
+
+// Files returns all overlay paths, sorted.
+func (o *sourceOverlay) Files() []string {
+	if o == nil {
+		return nil
+	}
+	files := slices.Concat(o.Replaced, o.Added)
+	slices.Sort(files)
+	return files
+}
+
+// JSON returns the overlay file consumed by `go build -overlay`.
+//
+// See <https://pkg.go.dev/cmd/go#hdr-Compile_packages_and_dependencies>.
+func (o *sourceOverlay) JSON(srcRoot string) (string, error) {
+	replace := map[string]string{}
+	for _, p := range o.Files() {
+		replace[path.Join(srcRoot, p)] = path.Join(overlayMountPath, p)
+	}
+	b, err := json.MarshalIndent(struct{ Replace map[string]string }{replace}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Mount adds the overlay to a Go container with the source at srcRoot,
+// returning the `go` flags that apply it. Empty overlays leave the container unchanged.
+func (o *sourceOverlay) Mount(ctr *dagger.Container, srcRoot string) (*dagger.Container, []string, error) {
+	if len(o.Files()) == 0 {
+		return ctr, nil, nil
+	}
+	overlayJSON, err := o.JSON(srcRoot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate overlay: %w", err)
+	}
+	ctr = ctr.
+		WithDirectory(overlayMountPath, o.Dir).
+		WithNewFile("/overlay.json", overlayJSON)
+	return ctr, []string{"-overlay=/overlay.json"}, nil
+}
+
+// parseOverlayNewFiles parses the NEW_FILES list, skipping blank lines and comments.
+func parseOverlayNewFiles(contents string) []string {
+	var files []string
+	for line := range strings.Lines(contents) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		files = append(files, path.Clean(line))
+	}
+	return files
+}
+
+// overlayLayer is a directory of overlay files, with its NEW_FILES list.
+type overlayLayer struct {
+	// Shown in errors, e.g. "overlays/v0.25".
+	Name string
+	Dir  *dagger.Directory
+	// Go files of the layer, relative to its root.
+	Files []string
+	// Files listed in the layer's NEW_FILES.
+	NewFiles []string
+}
+
+// readOverlayLayer lists the Go files of an overlay directory and reads its NEW_FILES list.
+func readOverlayLayer(ctx context.Context, name string, dir *dagger.Directory) (*overlayLayer, error) {
+	layer := &overlayLayer{Name: name, Dir: dir}
+	files, err := dir.Glob(ctx, "**/*.go")
+	if err != nil || len(files) == 0 {
+		// Missing directories have no overlays.
+		return layer, nil
+	}
+	layer.Files = files
+	if ok, _ := dir.Exists(ctx, overlayNewFilesList); ok {
+		contents, err := dir.File(overlayNewFilesList).Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s/%s: %w", name, overlayNewFilesList, err)
+		}
+		layer.NewFiles = parseOverlayNewFiles(contents)
+	}
+	for _, f := range layer.NewFiles {
+		if !slices.Contains(files, f) {
+			return nil, fmt.Errorf("%s/%s lists %q, but no such overlay file exists", name, overlayNewFilesList, f)
+		}
+	}
+	return layer, nil
+}
+
+// loadOverlay collects and validates Go overlay files against the upstream source.
+//
+// The top-level overlays come first, then the versionDirs subdirectories of the version
+// profiles, in order; a file in a later layer replaces the same path in earlier ones.
+// Subdirectories listed by any profile are never read as top-level overlays.
+//
+// Each file must replace an existing upstream file, unless it is listed in the NEW_FILES
+// of its layer. Returns nil if there are no overlays.
+func (m *MemosBuilds) loadOverlay(
+	ctx context.Context,
+	overlays *dagger.Directory,
+	versionDirs []string,
+	upstream *dagger.Directory,
+) (*sourceOverlay, error) {
+	if overlays == nil {
+		return nil, nil
+	}
+
+	top, err := readOverlayLayer(ctx, "overlays", overlays.Filter(dagger.DirectoryFilterOpts{
+		Exclude: overlayDirs(versionProfiles),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	layers := []*overlayLayer{top}
+	for _, dir := range versionDirs {
+		name := "overlays/" + dir
+		if ok, _ := overlays.Exists(ctx, dir, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeDirectoryType}); !ok {
+			return nil, fmt.Errorf("%s is listed by a version profile, but does not exist", name)
+		}
+		layer, err := readOverlayLayer(ctx, name, overlays.Directory(dir))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+
+	// Path → whether it is listed in NEW_FILES, as of the last layer providing it.
+	isNew := map[string]bool{}
+	merged := dag.Directory()
+	for _, layer := range layers {
+		if len(layer.Files) == 0 {
+			continue
+		}
+		merged = merged.WithDirectory(".", layer.Dir, dagger.DirectoryWithDirectoryOpts{Include: layer.Files})
+		for _, f := range layer.Files {
+			isNew[f] = slices.Contains(layer.NewFiles, f)
+		}
+	}
+	if len(isNew) == 0 {
+		return nil, nil
+	}
+
+	overlay := &sourceOverlay{Dir: merged}
+	for _, f := range slices.Sorted(maps.Keys(isNew)) {
+		exists, err := upstream.Exists(ctx, f, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check upstream file %q: %w", f, err)
+		}
+
+		switch {
+		case isNew[f] && exists:
+			return nil, fmt.Errorf("overlay %q is listed in %s, but exists upstream", f, overlayNewFilesList)
+		case isNew[f]:
+			overlay.Added = append(overlay.Added, f)
+		case exists:
+			overlay.Replaced = append(overlay.Replaced, f)
+		default:
+			return nil, fmt.Errorf("overlay %q does not exist upstream; list it in %s to add it as a new file", f, overlayNewFilesList)
+		}
+	}
+
+	return overlay, nil
+}
@@ -0,0 +1,634 @@
+// # Native Linux packages.
+//
+// Debian, RPM, Alpine and Arch Linux packages of the Linux targets. Like nFPM, the package
+// formats are written in Go, so no distribution tooling is needed to produce them.
+//
+// Every package installs the binary as /usr/bin/memos with a systemd unit (plus an OpenRC
+// script for Alpine), creates a `memos` system user, owns the /var/opt/memos data directory
+// and keeps local changes to /etc/memos/memos.env across upgrades.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// Package metadata shared by every format.
+const (
+	packageSummary     = "A privacy-first, lightweight note-taking service"
+	packageDescription = "Memos is an open-source, self-hosted note-taking service.\nBuilt by memos-builds: https://github.com/memospot/memos-builds"
+	packageHomepage    = "https://usememos.com"
+	packageMaintainer  = "Memospot <https://github.com/memospot/memos-builds>"
+	packageLicense     = "MIT"
+)
+
+// Paths of the packaged installation. The service files from `docs/` are adapted to them.
+const (
+	packageBinary  = "/usr/bin/memos"
+	packageDataDir = "/var/opt/memos"
+	packageEnvFile = "/etc/memos/memos.env"
+	// Where the service guides install the binary.
+	documentedBinary = "/usr/local/bin/memos"
+)
+
+// Packages are assembled in memory; this bounds how many binaries are held at once.
+const maxPackageConcurrency = 4
+
+// Maintainer scripts, shared by every format. Each format runs them from its own hooks.
+const (
+	// Runs before files are unpacked, on installs and upgrades.
+	packagePreInstall = `if ! id memos >/dev/null 2>&1; then
+	nologin=$(command -v nologin || echo /sbin/nologin)
+	if command -v useradd >/dev/null 2>&1; then
+		getent group memos >/dev/null || groupadd --system memos
+		useradd --system --gid memos --home-dir /var/opt/memos --no-create-home --shell "$nologin" memos
+	else
+		addgroup -S memos 2>/dev/null || true
+		adduser -S -D -H -h /var/opt/memos -s "$nologin" -G memos memos
+	fi
+fi
+`
+	// Runs after files are unpacked, on installs and upgrades.
+	packagePostInstall = `chown memos:memos /var/opt/memos
+if [ -d /run/systemd/system ]; then
+	systemctl daemon-reload || true
+fi
+`
+	// Runs before files are removed, on removals only.
+	packagePreRemove = `if [ -d /run/systemd/system ]; then
+	systemctl disable --now memos.service >/dev/null 2>&1 || true
+elif command -v rc-service >/dev/null 2>&1 && [ -x /etc/init.d/memos ]; then
+	rc-service memos stop >/dev/null 2>&1 || true
+	rc-update del memos >/dev/null 2>&1 || true
+fi
+`
+	// Runs after files are removed, on removals only. Data and the `memos` user are kept.
+	packagePostRemove = `if [ -d /run/systemd/system ]; then
+	systemctl daemon-reload || true
+fi
+`
+)
+
+// indentScript indents the lines of a script body by a tab, for nesting it in a block.
+func indentScript(body string) string {
+	var b strings.Builder
+	for line := range strings.Lines(body) {
+		if strings.TrimSpace(line) != "" {
+			line = "\t" + line
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// packageFormat is a native package format.
+type packageFormat struct {
+	Name string
+	// File extension, e.g. ".deb".
+	Ext string
+	// Architecture names of the format, by Go architecture ("amd64/vN" and "arm/vN" for levels).
+	// Targets missing here have no port in the distribution family, and are not packaged.
+	//
+	// Package managers only know the architecture, so a name is given to a single level:
+	// packages of other levels would have the same name, version and architecture.
+	Arches map[string]string
+	// Includes the OpenRC script.
+	OpenRC bool
+	// The written archive is compressed with zstd afterwards.
+	Zstd  bool
+	Write func(spec *packageSpec) ([]byte, error)
+}
+
+// Supported package formats, in output order.
+var packageFormats = []packageFormat{
+	{
+		Name:  "deb",
+		Ext:   ".deb",
+		Write: writeDeb,
+		Arches: map[string]string{
+			"amd64/v1": "amd64", "arm/v5": "armel", "arm/v7": "armhf", "arm64": "arm64",
+			"386": "i386", "ppc64le": "ppc64el", "riscv64": "riscv64", "s390x": "s390x",
+		},
+	},
+	{
+		Name:  "rpm",
+		Ext:   ".rpm",
+		Write: writeRPM,
+		Arches: map[string]string{
+			"amd64/v1": "x86_64", "arm/v5": "armv5tel", "arm/v6": "armv6hl", "arm/v7": "armv7hl", "arm64": "aarch64",
+			"386": "i686", "ppc64le": "ppc64le", "riscv64": "riscv64", "s390x": "s390x",
+		},
+	},
+	{
+		Name:   "apk",
+		Ext:    ".apk",
+		Write:  writeAPK,
+		OpenRC: true,
+		Arches: map[string]string{
+			"amd64/v1": "x86_64", "arm/v6": "armhf", "arm/v7": "armv7", "arm64": "aarch64",
+			"386": "x86", "ppc64le": "ppc64le", "riscv64": "riscv64", "s390x": "s390x",
+		},
+	},
+	{
+		Name:  "arch",
+		Ext:   ".pkg.tar.zst",
+		Write: writeArchPackage,
+		Zstd:  true,
+		// Arch Linux ARM no longer supports ARMv5 and ARMv6.
+		Arches: map[string]string{
+			"amd64/v1": "x86_64", "arm/v7": "armv7h", "arm64": "aarch64", "386": "i686", "riscv64": "riscv64",
+		},
+	},
+}
+
+// Arch returns the architecture name of a target in the format, or "" when it is not packaged.
+func (f packageFormat) Arch(t BuildMatrix) string {
+	key := t.Arch
+	if t.Arch == "amd64" || t.Arch == "arm" {
+		key += "/" + t.ArchLevel
+	}
+	return f.Arches[key]
+}
+
+// packageFile is a file or directory installed by a package.
+type packageFile struct {
+	// Absolute path, e.g. "/usr/bin/memos".
+	Path string
+	// Permissions, plus fs.ModeDir for directories.
+	Mode fs.FileMode
+	// User and group owning the file; root when empty.
+	Owner string
+	Data  []byte
+	// Configuration file whose local changes are kept on upgrades.
+	Config bool
+	// License text, flagged as such where the format supports it.
+	License bool
+}
+
+// IsDir reports whether the entry is a directory.
+func (f packageFile) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// OwnerName returns the user and group owning the file.
+func (f packageFile) OwnerName() string {
+	if f.Owner == "" {
+		return "root"
+	}
+	return f.Owner
+}
+
+// TarHeader returns the tar header of the file, with its path relative to the root and prefixed.
+func (f packageFile) TarHeader(prefix string, mtime time.Time) *tar.Header {
+	h := &tar.Header{
+		Name:    prefix + strings.TrimPrefix(f.Path, "/"),
+		Mode:    int64(f.Mode.Perm()),
+		Uname:   f.OwnerName(),
+		Gname:   f.OwnerName(),
+		ModTime: mtime,
+	}
+	if f.IsDir() {
+		h.Typeflag = tar.TypeDir
+		h.Name += "/"
+	} else {
+		h.Typeflag = tar.TypeReg
+		h.Size = int64(len(f.Data))
+	}
+	return h
+}
+
+// writeTar writes files to a tar stream, with paths prefixed. pax, when set, returns
+// extended header records for each regular file.
+//
+// The end-of-archive marker is left out when terminate is false, for formats
+// that concatenate tar streams.
+func writeTar(
+	w io.Writer,
+	files []packageFile,
+	prefix string,
+	mtime time.Time,
+	terminate bool,
+	pax func(f packageFile) map[string]string,
+) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		h := f.TarHeader(prefix, mtime)
+		if pax != nil && !f.IsDir() {
+			h.PAXRecords = pax(f)
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if terminate {
+		return tw.Close()
+	}
+	return tw.Flush()
+}
+
+// gzipTar is writeTar, compressed with gzip.
+func gzipTar(
+	files []packageFile,
+	prefix string,
+	mtime time.Time,
+	terminate bool,
+	pax func(f packageFile) map[string]string,
+) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTar(gz, files, prefix, mtime, terminate, pax); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// packageSpec describes a package independently of its format.
+type packageSpec struct {
+	// e.g. "memos", or "memos-fips" for a FIPS build.
+	Name    string
+	Version *semver.Version
+	// Architecture, in the naming of the format.
+	Arch      string
+	BuildTime time.Time
+	// Files and directories owned by the package, sorted by path.
+	Files []packageFile
+}
+
+// InstalledSize returns the total size of the packaged files, in bytes.
+func (s *packageSpec) InstalledSize() int {
+	size := 0
+	for _, f := range s.Files {
+		size += len(f.Data)
+	}
+	return size
+}
+
+// ConfigFiles returns the paths of the configuration files.
+func (s *packageSpec) ConfigFiles() []string {
+	var paths []string
+	for _, f := range s.Files {
+		if f.Config {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
+
+// WithParentDirs returns the files along with their parent directories not owned by
+// the package, as expected by tar-based formats. Parents are owned by root.
+func (s *packageSpec) WithParentDirs() []packageFile {
+	files := slices.Clone(s.Files)
+	seen := map[string]bool{}
+	for _, f := range s.Files {
+		seen[f.Path] = true
+	}
+	for _, f := range s.Files {
+		for dir := path.Dir(f.Path); dir != "/"; dir = path.Dir(dir) {
+			if !seen[dir] {
+				seen[dir] = true
+				files = append(files, packageFile{Path: dir, Mode: fs.ModeDir | 0755})
+			}
+		}
+	}
+	slices.SortFunc(files, func(a, b packageFile) int { return strings.Compare(a.Path, b.Path) })
+	return files
+}
+
+// packageVersion returns the version of the packages of a build, without the "v" prefix.
+//
+// Builds of arbitrary commits may not carry a semantic version; they are packaged as 0.0.0.
+func packageVersion(buildVersion string) *semver.Version {
+	v, err := semver.NewVersion(buildVersion)
+	if err != nil {
+		return semver.MustParse("0.0.0")
+	}
+	return v
+}
+
+// newPackageSpec describes the package of a target in a format.
+func newPackageSpec(
+	prepared *preparedSource,
+	bundle *archiveBundle,
+	license string,
+	t BuildMatrix,
+	format packageFormat,
+	binary []byte,
+	buildTime time.Time,
+) (*packageSpec, error) {
+	// The service guides install the binary elsewhere.
+	adapt := func(name, contents string) (string, error) {
+		if !strings.Contains(contents, documentedBinary) {
+			return "", fmt.Errorf("%s does not run %s", name, documentedBinary)
+		}
+		return strings.ReplaceAll(contents, documentedBinary, packageBinary), nil
+	}
+	unit, err := adapt("systemd unit", bundle.SystemdUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	name := prepared.ArtifactName()
+	licensePath := path.Join("/usr/share/licenses", name, "LICENSE")
+	if format.Name == "deb" {
+		licensePath = path.Join("/usr/share/doc", name, "copyright")
+	}
+
+	files := []packageFile{
+		{Path: "/etc/memos", Mode: fs.ModeDir | 0755},
+		{Path: packageEnvFile, Mode: 0640, Data: []byte(bundle.EnvExample), Config: true},
+		{Path: packageBinary, Mode: 0755, Data: binary},
+		{Path: "/usr/lib/systemd/system/memos.service", Mode: 0644, Data: []byte(unit)},
+		{Path: licensePath, Mode: 0644, Data: []byte(license), License: true},
+		{Path: packageDataDir, Mode: fs.ModeDir | 0750, Owner: "memos"},
+	}
+	if format.OpenRC {
+		openrc, err := adapt("OpenRC script", bundle.OpenRCScript)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, packageFile{Path: "/etc/init.d/memos", Mode: 0755, Data: []byte(openrc)})
+	}
+	slices.SortFunc(files, func(a, b packageFile) int { return strings.Compare(a.Path, b.Path) })
+
+	return &packageSpec{
+		Name:      name,
+		Version:   packageVersion(prepared.BuildVersion),
+		Arch:      format.Arch(t),
+		BuildTime: buildTime,
+		Files:     files,
+	}, nil
+}
+
+// packageResult is the outcome of packaging a single target.
+type packageResult struct {
+	Target BuildMatrix
+	// Packages, named by BuildMatrix.PackageName.
+	Files map[string]*dagger.File
+	Err   error
+}
+
+// createPackages builds the packages of every Linux target in every format, in parallel.
+// Results are returned in target order.
+func (m *MemosBuilds) createPackages(
+	ctx context.Context,
+	build *buildResult,
+	bundle *archiveBundle,
+	opts buildOptions,
+) ([]packageResult, error) {
+	license, err := bundle.License.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the license: %w", err)
+	}
+
+	var compressor *dagger.Container
+	err = opts.RetryPolicy().Do(ctx, "apk add (packages)", func() (err error) {
+		compressor, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "zstd"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the package compressor: %w", err)
+	}
+
+	maxConcurrent := opts.Concurrency
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultConcurrency()
+	}
+
+	prepared := build.Prepared
+	version := prepared.ArtifactVersion()
+	buildTime := time.Now().UTC().Truncate(time.Second)
+	targets := filterLinuxTargets(build.Targets)
+	results := make([]packageResult, len(targets))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(min(maxConcurrent, maxPackageConcurrency))
+	for i, t := range targets {
+		g.Go(func() error {
+			results[i] = packageResult{Target: t, Files: map[string]*dagger.File{}}
+			if !slices.ContainsFunc(packageFormats, func(f packageFormat) bool { return f.Arch(t) != "" }) {
+				return nil
+			}
+			binary, err := readFileBytes(gctx, build.Binaries.File(t.BinaryName()))
+			if err != nil {
+				results[i].Err = fmt.Errorf("failed to read %s: %w", t.BinaryName(), err)
+				return nil
+			}
+			for _, format := range packageFormats {
+				if format.Arch(t) == "" {
+					continue
+				}
+				name := t.PackageName(version, format.Ext)
+				file, err := m.writePackage(gctx, prepared, bundle, license, t, format, binary, buildTime, compressor)
+				if err != nil {
+					results[i].Err = fmt.Errorf("failed to create %s: %w", name, err)
+					return nil
+				}
+				results[i].Files[name] = file
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results, nil
+}
+
+// writePackage writes the package of a target in a format.
+func (m *MemosBuilds) writePackage(
+	ctx context.Context,
+	prepared *preparedSource,
+	bundle *archiveBundle,
+	license string,
+	t BuildMatrix,
+	format packageFormat,
+	binary []byte,
+	buildTime time.Time,
+	compressor *dagger.Container,
+) (*dagger.File, error) {
+	spec, err := newPackageSpec(prepared, bundle, license, t, format, binary, buildTime)
+	if err != nil {
+		return nil, err
+	}
+	data, err := format.Write(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	name := t.PackageName(prepared.ArtifactVersion(), format.Ext)
+	if !format.Zstd {
+		return newFileFromBytes(ctx, name, data)
+	}
+	uncompressed := strings.TrimSuffix(name, ".zst")
+	file, err := newFileFromBytes(ctx, uncompressed, data)
+	if err != nil {
+		return nil, err
+	}
+	return compressor.
+		WithFile("/work/"+uncompressed, file).
+		WithExec([]string{"zstd", "-q", "-19", "-T0", "/work/" + uncompressed, "-o", "/work/" + name}).
+		File("/work/" + name).
+		Sync(ctx)
+}
+
+// packageEach builds the packages of every Linux target, stopping at the first failure unless
+// keep-going mode is on. Targets whose packages fail are reported in build.Failures.
+func (m *MemosBuilds) packageEach(
+	ctx context.Context,
+	build *buildResult,
+	bundle *archiveBundle,
+	opts buildOptions,
+) (*dagger.Directory, error) {
+	results, err := m.createPackages(ctx, build, bundle, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := dag.Directory()
+	for _, r := range results {
+		if r.Err != nil {
+			if !opts.KeepGoing {
+				return nil, fmt.Errorf("%s: %w", r.Target.DockerPlatform(), r.Err)
+			}
+			build.Failures = append(build.Failures, newTargetFailure(r.Target, failureStagePackage, r.Err))
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(r.Files)) {
+			out = out.WithFile(name, r.Files[name])
+		}
+	}
+	return out, nil
+}
+
+// Packages builds .deb, .rpm, .apk and Arch Linux packages of the selected Linux platforms,
+// with their checksums.
+//
+// Each package installs the binary as /usr/bin/memos with a systemd unit (and an OpenRC script
+// in Alpine packages), creates a `memos` system user and keeps changes to /etc/memos/memos.env.
+func (m *MemosBuilds) Packages(
+	ctx context.Context,
+	source *dagger.Directory,
+	version string,
+	platforms string,
+	// Prebuilt frontend (the `server/router/frontend/dist` output of `pnpm run release`) to embed instead of building it.
+	// +optional
+	frontendDist *dagger.Directory,
+	// Embed a placeholder page instead of the frontend, for API-only deployments.
+	// +optional
+	headless bool,
+	// White-label branding directory with a `branding.json` manifest. See `.dagger/README.md`.
+	// +optional
+	branding *dagger.Directory,
+	// Build image overrides as comma-separated `tool=image` pairs (e.g. "go=golang:1.26.2-alpine,node=node:22-alpine").
+	// Images are otherwise derived from the upstream source.
+	// +optional
+	toolchain string,
+	// Number of targets compiled at once. Defaults to NumCPU-1, or NumCPU when CI=true.
+	// +optional
+	concurrency int,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads (e.g. "https://goproxy.example.com,off"). Defaults to the Go toolchain's.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy (the default when only this is given).
+	// +optional
+	goproxyDir *dagger.Directory,
+	// CPU profile for profile-guided optimization, overriding `pgo/<version>.pgo` and `pgo/default.pgo`.
+	// +optional
+	pgo *dagger.File,
+	// Build against the Go Cryptographic Module in FIPS 140-3 mode, as `fips`-labelled artifacts and image tags.
+	// +optional
+	fips bool,
+) (*dagger.Directory, error) {
+	if version == "" {
+		version = "nightly"
+	}
+
+	opts := buildOptions{
+		FrontendDist: frontendDist,
+		Headless:     headless,
+		Branding:     branding,
+		Toolchain:    toolchain,
+		Concurrency:  concurrency,
+		Retry:        retry,
+		GoProxy:      goproxy,
+		GoProxyDir:   goproxyDir,
+		PGO:          pgo,
+		FIPS:         fips,
+		Packages:     true,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	targets, err := filterTargets(platforms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platforms: %w", err)
+	}
+	targets = filterLinuxTargets(targets)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no Linux platforms in the selected targets")
+	}
+
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, err
+	}
+	targets, err = prepared.Profile.Buildable(targets, platforms)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := m.loadArchiveBundle(ctx, source, prepared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare package contents: %w", err)
+	}
+
+	build, err := m.compile(ctx, prepared, targets, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build binaries: %w", err)
+	}
+	packages, err := m.packageEach(ctx, build, bundle, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create packages: %w", err)
+	}
+
+	metadata, err := newBuildMetadata(prepared, opts, build.Timings).JSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialise build metadata: %w", err)
+	}
+
+	artifactVersion := prepared.ArtifactVersion()
+	checksumFile := fmt.Sprintf(buildconsts.CHECKSUM_FILE_FORMAT, artifactVersion)
+	return packages.
+		WithFile(checksumFile, m.generateChecksums(packages, checksumFile)).
+		WithNewFile(fmt.Sprintf(buildconsts.DEPENDENCY_DRIFT_FILE_FORMAT, artifactVersion), prepared.DependencyReport).
+		WithNewFile(fmt.Sprintf(buildconsts.METADATA_FILE_FORMAT, artifactVersion), metadata), nil
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// testPackageSpec returns a package with the kinds of files createPackages packages.
+func testPackageSpec(arch string) *packageSpec {
+	return &packageSpec{
+		Name:      "memos",
+		Version:   semver.MustParse("0.26.0-rc.1"),
+		Arch:      arch,
+		BuildTime: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Files: []packageFile{
+			{Path: "/etc/memos", Mode: fs.ModeDir | 0755},
+			{Path: "/etc/memos/memos.env", Mode: 0640, Data: []byte("MEMOS_PORT=5230\n"), Config: true},
+			{Path: "/usr/bin/memos", Mode: 0755, Data: bytes.Repeat([]byte("\x7fELF\x02"), 4099)},
+			{Path: "/usr/lib/systemd/system/memos.service", Mode: 0644, Data: []byte("[Service]\nExecStart=/usr/bin/memos\n")},
+			{Path: "/usr/share/licenses/memos/LICENSE", Mode: 0644, Data: []byte("MIT License\n"), License: true},
+			{Path: "/var/opt/memos", Mode: fs.ModeDir | 0750, Owner: "memos"},
+		},
+	}
+}
+
+// tarEntry is a file read back from a tar stream.
+type tarEntry struct {
+	Header *tar.Header
+	Data   []byte
+}
+
+// readTar reads every entry of a tar stream.
+func readTar(t *testing.T, r io.Reader) []tarEntry {
+	t.Helper()
+	var entries []tarEntry
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("invalid tar stream: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("invalid tar entry %s: %v", h.Name, err)
+		}
+		entries = append(entries, tarEntry{Header: h, Data: data})
+	}
+}
+
+// gunzip decompresses a single gzip stream.
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	out, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("invalid gzip stream: %v", err)
+	}
+	return out
+}
+
+// checkTarFiles checks that tar entries hold the files of a spec and their parent directories,
+// under prefix.
+func checkTarFiles(t *testing.T, entries []tarEntry, spec *packageSpec, prefix string) {
+	t.Helper()
+	want := spec.WithParentDirs()
+	if len(entries) != len(want) {
+		t.Fatalf("%d entries, want %d", len(entries), len(want))
+	}
+	for i, f := range want {
+		h, data := entries[i].Header, entries[i].Data
+		name := prefix + strings.TrimPrefix(f.Path, "/")
+		if f.IsDir() {
+			name += "/"
+		}
+		if h.Name != name {
+			t.Errorf("entry %d is %s, want %s", i, h.Name, name)
+		}
+		if fs.FileMode(h.Mode).Perm() != f.Mode.Perm() || h.Uname != f.OwnerName() || h.Gname != f.OwnerName() {
+			t.Errorf("%s: mode %o owned by %s:%s, want %o owned by %s", h.Name, h.Mode, h.Uname, h.Gname, f.Mode.Perm(), f.OwnerName())
+		}
+		if f.IsDir() != (h.Typeflag == tar.TypeDir) || !bytes.Equal(data, f.Data) {
+			t.Errorf("%s: type %c with %d bytes, want %d bytes", h.Name, h.Typeflag, len(data), len(f.Data))
+		}
+		if !h.ModTime.Equal(spec.BuildTime) {
+			t.Errorf("%s: modified %v, want %v", h.Name, h.ModTime, spec.BuildTime)
+		}
+	}
+}
+
+// keyValues parses the `key = value` lines of a .PKGINFO file.
+func keyValues(pkginfo []byte) map[string][]string {
+	values := map[string][]string{}
+	for line := range strings.Lines(string(pkginfo)) {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), " = "); ok && !strings.HasPrefix(key, "#") {
+			values[key] = append(values[key], value)
+		}
+	}
+	return values
+}
+
+func TestPackageVersions(t *testing.T) {
+	tests := []struct {
+		version              string
+		deb, rpm, rpmRelease string
+		apk, arch            string
+	}{
+		{"0.26.0", "0.26.0-1", "0.26.0", "1", "0.26.0-r0", "0.26.0-1"},
+		{"0.26.0-rc.1", "0.26.0~rc.1-1", "0.26.0~rc.1", "1", "0.26.0_rc1-r0", "0.26.0rc.1-1"},
+		{"0.26.0-nightly-20261001", "0.26.0~nightly-20261001-1", "0.26.0~nightly_20261001", "1", "0.26.0_pre20261001-r0", "0.26.0nightly_20261001-1"},
+	}
+	for _, tt := range tests {
+		v := semver.MustParse(tt.version)
+		if got := debVersion(v); got != tt.deb {
+			t.Errorf("debVersion(%s) = %s, want %s", tt.version, got, tt.deb)
+		}
+		if got, release := rpmVersion(v); got != tt.rpm || release != tt.rpmRelease {
+			t.Errorf("rpmVersion(%s) = %s, %s, want %s, %s", tt.version, got, release, tt.rpm, tt.rpmRelease)
+		}
+		if got := apkVersion(v); got != tt.apk {
+			t.Errorf("apkVersion(%s) = %s, want %s", tt.version, got, tt.apk)
+		}
+		if got := archVersion(v); got != tt.arch {
+			t.Errorf("archVersion(%s) = %s, want %s", tt.version, got, tt.arch)
+		}
+	}
+}
+
+// arMember is a member read back from an ar archive.
+type arMember struct {
+	Name string
+	Data []byte
+}
+
+// readAr reads a common-format ar archive, checking its headers and padding.
+func readAr(t *testing.T, data []byte) []arMember {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		t.Fatal("missing ar magic")
+	}
+	var members []arMember
+	for off := 8; off < len(data); {
+		if off+60 > len(data) {
+			t.Fatalf("truncated ar header at %d", off)
+		}
+		header := data[off : off+60]
+		if string(header[58:60]) != "`\n" {
+			t.Fatalf("invalid ar header terminator at %d: %q", off, header[58:60])
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil {
+			t.Fatalf("invalid ar member size %q", header[48:58])
+		}
+		if mode := strings.TrimSpace(string(header[40:48])); mode != "100644" {
+			t.Errorf("ar member mode %s, want 100644", mode)
+		}
+		off += 60
+		if off+size > len(data) {
+			t.Fatalf("ar member of %d bytes overruns the archive", size)
+		}
+		members = append(members, arMember{Name: strings.TrimSpace(string(header[:16])), Data: data[off : off+size]})
+		off += size
+		if size%2 != 0 {
+			if off >= len(data) || data[off] != '\n' {
+				t.Fatalf("missing padding after ar member %s", members[len(members)-1].Name)
+			}
+			off++
+		}
+	}
+	return members
+}
+
+func TestWriteArMember(t *testing.T) {
+	var ar bytes.Buffer
+	ar.WriteString("!<arch>\n")
+	mtime := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeArMember(&ar, "odd", []byte("abc"), mtime)
+	writeArMember(&ar, "even", []byte("abcd"), mtime)
+
+	members := readAr(t, ar.Bytes())
+	if len(members) != 2 || members[0].Name != "odd" || string(members[0].Data) != "abc" ||
+		members[1].Name != "even" || string(members[1].Data) != "abcd" {
+		t.Errorf("members = %+v", members)
+	}
+}
+
+func TestWriteDeb(t *testing.T) {
+	spec := testPackageSpec("amd64")
+	pkg, err := writeDeb(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := readAr(t, pkg)
+	var names []string
+	for _, m := range members {
+		names = append(names, m.Name)
+	}
+	if !slices.Equal(names, []string{"debian-binary", "control.tar.gz", "data.tar.gz"}) {
+		t.Fatalf("ar members %v", names)
+	}
+	if string(members[0].Data) != "2.0\n" {
+		t.Errorf("debian-binary = %q", members[0].Data)
+	}
+
+	control := map[string][]byte{}
+	for _, e := range readTar(t, bytes.NewReader(gunzip(t, members[1].Data))) {
+		control[e.Header.Name] = e.Data
+		if strings.HasPrefix(e.Header.Name, "./pre") || strings.HasPrefix(e.Header.Name, "./post") {
+			if e.Header.Mode != 0755 || !bytes.HasPrefix(e.Data, []byte("#!/bin/sh\n")) {
+				t.Errorf("%s: mode %o, not an executable script", e.Header.Name, e.Header.Mode)
+			}
+		}
+	}
+	for _, field := range []string{
+		"Package: memos\n",
+		"Version: 0.26.0~rc.1-1\n",
+		"Architecture: amd64\n",
+		fmt.Sprintf("Installed-Size: %d\n", (spec.InstalledSize()+1023)/1024),
+	} {
+		if !bytes.Contains(control["./control"], []byte(field)) {
+			t.Errorf("control lacks %q:\n%s", field, control["./control"])
+		}
+	}
+	if string(control["./conffiles"]) != "/etc/memos/memos.env\n" {
+		t.Errorf("conffiles = %q", control["./conffiles"])
+	}
+
+	data := readTar(t, bytes.NewReader(gunzip(t, members[2].Data)))
+	checkTarFiles(t, data, spec, "./")
+	var md5sums strings.Builder
+	for _, e := range data {
+		if e.Header.Typeflag == tar.TypeReg {
+			fmt.Fprintf(&md5sums, "%x  %s\n", md5.Sum(e.Data), strings.TrimPrefix(e.Header.Name, "./"))
+		}
+	}
+	if string(control["./md5sums"]) != md5sums.String() {
+		t.Errorf("md5sums = %q, want %q", control["./md5sums"], md5sums.String())
+	}
+}
+
+func TestWriteAPK(t *testing.T) {
+	spec := testPackageSpec("x86_64")
+	pkg, err := writeAPK(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two concatenated gzip streams: control, then data.
+	r := bytes.NewReader(pkg)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	control, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataSegment := pkg[len(pkg)-r.Len():]
+	if err := gz.Reset(bufio.NewReader(bytes.NewReader(dataSegment))); err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// apk concatenates the segments, so the control one has no end-of-archive marker.
+	if len(control) < 512 || bytes.Equal(control[len(control)-512:], make([]byte, 512)) {
+		t.Error("control segment ends with an end-of-archive marker")
+	}
+	entries := readTar(t, bytes.NewReader(control))
+	if len(entries) == 0 || entries[0].Header.Name != ".PKGINFO" {
+		t.Fatal("control segment does not start with .PKGINFO")
+	}
+	info := keyValues(entries[0].Data)
+	for key, want := range map[string]string{
+		"pkgname":  "memos",
+		"pkgver":   "0.26.0_rc1-r0",
+		"arch":     "x86_64",
+		"size":     strconv.Itoa(spec.InstalledSize()),
+		"datahash": fmt.Sprintf("%x", sha256.Sum256(dataSegment)),
+	} {
+		if got := info[key]; len(got) != 1 || got[0] != want {
+			t.Errorf(".PKGINFO %s = %v, want %s", key, got, want)
+		}
+	}
+	for _, e := range entries[1:] {
+		if !strings.HasPrefix(e.Header.Name, ".pre-") && !strings.HasPrefix(e.Header.Name, ".post-") {
+			t.Errorf("unexpected control file %s", e.Header.Name)
+		}
+	}
+
+	files := readTar(t, bytes.NewReader(data))
+	checkTarFiles(t, files, spec, "")
+	for _, e := range files {
+		checksum := e.Header.PAXRecords["APK-TOOLS.checksum.SHA1"]
+		if e.Header.Typeflag == tar.TypeReg && checksum != fmt.Sprintf("%x", sha1.Sum(e.Data)) {
+			t.Errorf("%s: checksum %q does not match its contents", e.Header.Name, checksum)
+		}
+	}
+}
+
+func TestWriteArchPackage(t *testing.T) {
+	spec := testPackageSpec("x86_64")
+	pkg, err := writeArchPackage(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readTar(t, bytes.NewReader(pkg))
+	if len(entries) < 2 || entries[0].Header.Name != ".PKGINFO" || entries[1].Header.Name != ".INSTALL" {
+		t.Fatal("package does not start with .PKGINFO and .INSTALL")
+	}
+	info := keyValues(entries[0].Data)
+	for key, want := range map[string][]string{
+		"pkgname": {"memos"},
+		"pkgver":  {"0.26.0rc.1-1"},
+		"arch":    {"x86_64"},
+		"size":    {strconv.Itoa(spec.InstalledSize())},
+		"backup":  {"etc/memos/memos.env"},
+	} {
+		if !slices.Equal(info[key], want) {
+			t.Errorf(".PKGINFO %s = %v, want %v", key, info[key], want)
+		}
+	}
+	for _, hook := range []string{"pre_install", "post_install", "pre_upgrade", "post_upgrade", "pre_remove", "post_remove"} {
+		if !bytes.Contains(entries[1].Data, []byte(hook+"() {\n")) {
+			t.Errorf(".INSTALL lacks %s", hook)
+		}
+	}
+	checkTarFiles(t, entries[2:], spec, "")
+}
+
+// cpioEntry is an entry read back from a "newc" cpio archive.
+type cpioEntry struct {
+	Name  string
+	Mode  uint32
+	Nlink uint32
+	Data  []byte
+}
+
+// readCpio reads a "newc" cpio archive up to its trailer, checking its alignment.
+func readCpio(t *testing.T, data []byte) []cpioEntry {
+	t.Helper()
+	align := func(n int) int { return (n + 3) &^ 3 }
+	var entries []cpioEntry
+	for off := 0; ; {
+		if off+110 > len(data) || string(data[off:off+6]) != "070701" {
+			t.Fatalf("invalid cpio header at %d", off)
+		}
+		var fields [13]uint32
+		for i := range fields {
+			v, err := strconv.ParseUint(string(data[off+6+8*i:off+14+8*i]), 16, 32)
+			if err != nil {
+				t.Fatalf("invalid cpio header field at %d: %v", off, err)
+			}
+			fields[i] = uint32(v)
+		}
+		size, nameSize := int(fields[6]), int(fields[11])
+		nameEnd := off + 110 + nameSize
+		if nameSize == 0 || nameEnd > len(data) || data[nameEnd-1] != 0 {
+			t.Fatalf("invalid cpio name at %d", off)
+		}
+		name := string(data[off+110 : nameEnd-1])
+		start := align(nameEnd)
+		if start+size > len(data) {
+			t.Fatalf("cpio entry %s overruns the archive", name)
+		}
+		off = align(start + size)
+		if name == "TRAILER!!!" {
+			if off != len(data) {
+				t.Errorf("%d bytes after the cpio trailer", len(data)-off)
+			}
+			return entries
+		}
+		entries = append(entries, cpioEntry{Name: name, Mode: fields[1], Nlink: fields[4], Data: data[start : start+size]})
+	}
+}
+
+// rpmIndex is an index entry read back from an RPM header.
+type rpmIndex struct {
+	Tag, Type, Offset, Count uint32
+}
+
+// parsedRPMHeader is an RPM header read back, with the data of each entry.
+type parsedRPMHeader struct {
+	Raw     []byte
+	Entries map[uint32]rpmIndex
+	Data    map[uint32][]byte
+}
+
+// readRPMHeader reads the header at the start of data and checks its layout: the region
+// entry and trailer, sorted tags, and entry data that is aligned, in bounds and does not overlap.
+func readRPMHeader(t *testing.T, data []byte, region uint32) parsedRPMHeader {
+	t.Helper()
+	if len(data) < 16 || !bytes.Equal(data[:8], []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}) {
+		t.Fatal("missing header magic")
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	storeSize := int(binary.BigEndian.Uint32(data[12:]))
+	storeStart := 16 + 16*count
+	if count < 1 || storeStart+storeSize > len(data) {
+		t.Fatalf("header of %d entries and %d bytes overruns the package", count, storeSize)
+	}
+	store := data[storeStart : storeStart+storeSize]
+	index := make([]rpmIndex, count)
+	for i := range index {
+		e := data[16+16*i:]
+		index[i] = rpmIndex{
+			Tag:    binary.BigEndian.Uint32(e),
+			Type:   binary.BigEndian.Uint32(e[4:]),
+			Offset: binary.BigEndian.Uint32(e[8:]),
+			Count:  binary.BigEndian.Uint32(e[12:]),
+		}
+	}
+
+	wantRegion := rpmIndex{Tag: region, Type: rpmBin, Offset: uint32(storeSize - 16), Count: 16}
+	if index[0] != wantRegion {
+		t.Errorf("region entry %+v, want %+v", index[0], wantRegion)
+	}
+	trailer := rpmIndex{
+		Tag:    binary.BigEndian.Uint32(store[storeSize-16:]),
+		Type:   binary.BigEndian.Uint32(store[storeSize-12:]),
+		Offset: binary.BigEndian.Uint32(store[storeSize-8:]),
+		Count:  binary.BigEndian.Uint32(store[storeSize-4:]),
+	}
+	if want := (rpmIndex{Tag: region, Type: rpmBin, Offset: uint32(-16 * count), Count: 16}); trailer != want {
+		t.Errorf("region trailer %+v, want %+v", trailer, want)
+	}
+
+	h := parsedRPMHeader{Raw: data[:storeStart+storeSize], Entries: map[uint32]rpmIndex{}, Data: map[uint32][]byte{}}
+	end := 0
+	for i, e := range index[1:] {
+		if i > 0 && e.Tag <= index[i].Tag {
+			t.Errorf("tag %d follows tag %d", e.Tag, index[i].Tag)
+		}
+		if int(e.Offset)%rpmAlignment(e.Type) != 0 || int(e.Offset) < end || int(e.Offset) > storeSize-16 {
+			t.Errorf("tag %d: offset %d is misaligned or overlaps the previous entry (ending at %d)", e.Tag, e.Offset, end)
+			continue
+		}
+		size := 0
+		switch e.Type {
+		case rpmInt16:
+			size = 2 * int(e.Count)
+		case rpmInt32:
+			size = 4 * int(e.Count)
+		case rpmBin:
+			size = int(e.Count)
+		case rpmString, rpmI18NString, rpmStringArray:
+			if e.Type != rpmStringArray && e.Count != 1 {
+				t.Errorf("tag %d: string with a count of %d", e.Tag, e.Count)
+			}
+			for range e.Count {
+				n := bytes.IndexByte(store[int(e.Offset)+size:storeSize-16], 0)
+				if n < 0 {
+					t.Fatalf("tag %d: unterminated string", e.Tag)
+				}
+				size += n + 1
+			}
+		default:
+			t.Fatalf("tag %d: unknown type %d", e.Tag, e.Type)
+		}
+		end = int(e.Offset) + size
+		if end > storeSize-16 {
+			t.Fatalf("tag %d overruns the data store", e.Tag)
+		}
+		h.Entries[e.Tag] = e
+		h.Data[e.Tag] = store[e.Offset:end]
+	}
+	if end != storeSize-16 {
+		t.Errorf("%d unused bytes before the region trailer", storeSize-16-end)
+	}
+	return h
+}
+
+// Strings returns the strings of a string, i18n string or string array entry.
+func (h parsedRPMHeader) Strings(tag uint32) []string {
+	return strings.Split(strings.TrimSuffix(string(h.Data[tag]), "\x00"), "\x00")
+}
+
+// Int32s returns the values of an int32 entry.
+func (h parsedRPMHeader) Int32s(tag uint32) []uint32 {
+	var values []uint32
+	for b := h.Data[tag]; len(b) >= 4; b = b[4:] {
+		values = append(values, binary.BigEndian.Uint32(b))
+	}
+	return values
+}
+
+func TestWriteRPM(t *testing.T) {
+	spec := testPackageSpec("x86_64")
+	pkg, err := writeRPM(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pkg) < 96 || !bytes.Equal(pkg[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		t.Fatal("missing lead magic")
+	}
+	if name := string(bytes.TrimRight(pkg[10:76], "\x00")); name != "memos-0.26.0~rc.1-1" {
+		t.Errorf("lead name %q", name)
+	}
+	sig := readRPMHeader(t, pkg[96:], rpmTagHeaderSignatures)
+	headerStart := 96 + len(sig.Raw)
+	if padding := (8 - len(sig.Raw)%8) % 8; !bytes.Equal(pkg[headerStart:headerStart+padding], make([]byte, padding)) {
+		t.Fatal("signature header is not padded to 8 bytes")
+	} else {
+		headerStart += padding
+	}
+	h := readRPMHeader(t, pkg[headerStart:], rpmTagHeaderImmutable)
+	payload := pkg[headerStart+len(h.Raw):]
+
+	// Signature digests cover the main header, and the payload for the size and MD5.
+	headerSHA1, headerSHA256 := sha1.Sum(h.Raw), sha256.Sum256(h.Raw)
+	md5sum := md5.Sum(slices.Concat(h.Raw, payload))
+	cpio := gunzip(t, payload)
+	payloadDigest := sha256.Sum256(payload)
+	for _, check := range []struct {
+		name      string
+		got, want any
+	}{
+		{"SHA1", sig.Strings(rpmSigTagSHA1), []string{hex.EncodeToString(headerSHA1[:])}},
+		{"SHA256", sig.Strings(rpmSigTagSHA256), []string{hex.EncodeToString(headerSHA256[:])}},
+		{"MD5", sig.Data[rpmSigTagMD5], md5sum[:]},
+		{"SIZE", sig.Int32s(rpmSigTagSize), []uint32{uint32(len(h.Raw) + len(payload))}},
+		{"PAYLOADSIZE", sig.Int32s(rpmSigTagPayloadSize), []uint32{uint32(len(cpio))}},
+		{"PAYLOADDIGEST", h.Strings(rpmTagPayloadDigest), []string{hex.EncodeToString(payloadDigest[:])}},
+	} {
+		if fmt.Sprint(check.got) != fmt.Sprint(check.want) {
+			t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+
+	for tag, want := range map[uint32]string{
+		rpmTagName:    "memos",
+		rpmTagVersion: "0.26.0~rc.1",
+		rpmTagRelease: "1",
+		rpmTagArch:    "x86_64",
+		rpmTagOS:      "linux",
+	} {
+		if got := h.Strings(tag); len(got) != 1 || got[0] != want {
+			t.Errorf("tag %d = %q, want %q", tag, got, want)
+		}
+	}
+	if got := h.Int32s(rpmTagSize); len(got) != 1 || got[0] != uint32(spec.InstalledSize()) {
+		t.Errorf("SIZE = %v, want %d", got, spec.InstalledSize())
+	}
+
+	// Every file tag has one value per file, and the payload holds the files in header order.
+	n := uint32(len(spec.Files))
+	for _, tag := range []uint32{
+		rpmTagFileSizes, rpmTagFileModes, rpmTagFileRdevs, rpmTagFileMtimes, rpmTagFileDigests,
+		rpmTagFileLinkTos, rpmTagFileFlags, rpmTagFileUserName, rpmTagFileGroupName,
+		rpmTagFileVerifyFlags, rpmTagFileDevices, rpmTagFileInodes, rpmTagFileLangs,
+		rpmTagDirIndexes, rpmTagBaseNames,
+	} {
+		if e, ok := h.Entries[tag]; !ok || e.Count != n {
+			t.Errorf("file tag %d has %d values, want %d", tag, e.Count, n)
+		}
+	}
+	for _, tag := range []uint32{rpmTagRequireFlags, rpmTagRequireVersion} {
+		if e := h.Entries[tag]; e.Count != h.Entries[rpmTagRequireName].Count {
+			t.Errorf("tag %d has %d values, for %d requirements", tag, e.Count, h.Entries[rpmTagRequireName].Count)
+		}
+	}
+
+	dirNames, dirIndexes, baseNames := h.Strings(rpmTagDirNames), h.Int32s(rpmTagDirIndexes), h.Strings(rpmTagBaseNames)
+	sizes, digests, flags := h.Int32s(rpmTagFileSizes), h.Strings(rpmTagFileDigests), h.Int32s(rpmTagFileFlags)
+	entries := readCpio(t, cpio)
+	if len(entries) != len(spec.Files) {
+		t.Fatalf("payload holds %d files, want %d", len(entries), len(spec.Files))
+	}
+	for i, f := range spec.Files {
+		if int(dirIndexes[i]) >= len(dirNames) {
+			t.Fatalf("%s: directory index %d out of range", f.Path, dirIndexes[i])
+		}
+		if got := path.Join(dirNames[dirIndexes[i]], baseNames[i]); got != f.Path {
+			t.Errorf("file %d is %s in the header, want %s", i, got, f.Path)
+		}
+		e := entries[i]
+		if e.Name != "."+f.Path || e.Mode != rpmFileMode(f) || !bytes.Equal(e.Data, f.Data) {
+			t.Errorf("payload entry %d is %s (mode %o, %d bytes), want %s (mode %o, %d bytes)",
+				i, e.Name, e.Mode, len(e.Data), "."+f.Path, rpmFileMode(f), len(f.Data))
+		}
+		if sizes[i] != uint32(len(e.Data)) {
+			t.Errorf("%s: size %d in the header, %d in the payload", f.Path, sizes[i], len(e.Data))
+		}
+		wantDigest := ""
+		if !f.IsDir() {
+			sum := sha256.Sum256(e.Data)
+			wantDigest = hex.EncodeToString(sum[:])
+		}
+		if digests[i] != wantDigest {
+			t.Errorf("%s: digest %q, want %q", f.Path, digests[i], wantDigest)
+		}
+		if f.Config != (flags[i]&rpmFileConfig != 0) || f.License != (flags[i]&rpmFileLicense != 0) {
+			t.Errorf("%s: flags %#x", f.Path, flags[i])
+		}
+	}
+}
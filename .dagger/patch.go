@@ -7,21 +7,93 @@ import (
 	"context"
 	"dagger/memos-builds/buildconsts"
 	"dagger/memos-builds/internal/dagger"
+	_ "embed"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// sqlite-libc map: the modernc.org/libc version required by each modernc.org/sqlite version.
+//
+// Entries are kept in `sqlite-libc.json` and refreshed with `UpdateSqliteLibcMap`,
+// so that builds do not depend on network access for known SQLite versions. The SQLite
+// version of each upstream release is recorded by its version profile.
+//
+//go:embed sqlite-libc.json
+var sqliteLibcJSON []byte
+
+// sqliteLibcEntry is a single row of `sqlite-libc.json`.
+type sqliteLibcEntry struct {
+	Sqlite string `json:"sqlite"`
+	Libc   string `json:"libc"`
+}
+
+var sqliteLibcMap = mustParseSqliteLibcMap(sqliteLibcJSON)
+
+// Module paths involved in the sqlite-libc pinning.
+const (
+	sqliteModulePath = "modernc.org/sqlite"
+	libcModulePath   = "modernc.org/libc"
 )
 
-// sqlite-libc map: known versions from
-// https://gitlab.com/cznic/sqlite/-/blob/master/go.mod
-var sqliteLibcMap = map[string]string{
-	"v1.37.0": "v1.62.1", // Memos v0.24.3
-	"v1.37.1": "v1.65.8", // v0.24.4-v0.25.0
-	"v1.38.2": "v1.66.3", // v0.25.1-v0.26.2
-	"v1.46.1": "v1.67.6", // v0.26.3+
-	"v1.50.0": "v1.72.0", // v0.29.0+
-	"v1.50.1": "v1.72.3",
-	"v1.51.0": "v1.72.3",
-	"v1.52.0": "v1.72.3",
+// parseSqliteLibcEntries decodes the contents of `sqlite-libc.json`.
+func parseSqliteLibcEntries(data []byte) ([]sqliteLibcEntry, error) {
+	var entries []sqliteLibcEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse sqlite-libc map: %w", err)
+	}
+	for _, e := range entries {
+		if e.Sqlite == "" || e.Libc == "" {
+			return nil, fmt.Errorf("invalid sqlite-libc entry: %+v", e)
+		}
+	}
+	return entries, nil
+}
+
+func mustParseSqliteLibcMap(data []byte) map[string]string {
+	entries, err := parseSqliteLibcEntries(data)
+	if err != nil {
+		panic(err)
+	}
+	m := make(map[string]string, len(entries))
+	for _, e := range entries {
+		m[e.Sqlite] = e.Libc
+	}
+	return m
+}
+
+// marshalSqliteLibcEntries encodes entries sorted by SQLite version, one per line.
+func marshalSqliteLibcEntries(entries []sqliteLibcEntry) (string, error) {
+	slices.SortFunc(entries, func(a, b sqliteLibcEntry) int {
+		va, errA := semver.NewVersion(a.Sqlite)
+		vb, errB := semver.NewVersion(b.Sqlite)
+		if errA != nil || errB != nil {
+			return strings.Compare(a.Sqlite, b.Sqlite)
+		}
+		return va.Compare(vb)
+	})
+
+	var b strings.Builder
+	b.WriteString("[\n")
+	for i, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("  ")
+		b.Write(line)
+		if i < len(entries)-1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString("]\n")
+	return b.String(), nil
 }
 
 // Regex patterns for parsing go.mod
@@ -43,20 +115,32 @@ func getSqliteVersion(goModContents string) (string, bool) {
 
 // getLibcVersionForSqlite returns the expected modernc.org/libc version for a given SQLite version.
 //
-// First checks the hardcoded map, then falls back to fetching from upstream.
+// First checks the cached map, then falls back to fetching from the build's module proxies.
 // Returns an error if the version cannot be determined.
-func getLibcVersionForSqlite(ctx context.Context, sqliteVersion string) (string, error) {
-	// Try hardcoded map first.
+func getLibcVersionForSqlite(ctx context.Context, sqliteVersion string, proxy *goProxy, retry retryPolicy) (string, error) {
+	// Try cached map first.
 	if libcVersion, ok := sqliteLibcMap[sqliteVersion]; ok {
 		return libcVersion, nil
 	}
 
 	// Fallback: fetch from upstream.
-	upstreamURL := fmt.Sprintf("https://gitlab.com/cznic/sqlite/-/raw/%s/go.mod", sqliteVersion)
-	upstreamGoMod := dag.HTTP(upstreamURL)
-	upstreamContents, err := upstreamGoMod.Contents(ctx)
+	var libcVersion string
+	err := retry.Do(ctx, "fetch sqlite "+sqliteVersion+" go.mod", func() (err error) {
+		libcVersion, err = fetchLibcVersionForSqlite(ctx, goProxyClient, proxy, sqliteVersion)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("sqlite %s not in sqlite-libc.json and upstream fetch failed (%w); please run `just update-sqlite-map %s`", sqliteVersion, err, sqliteVersion)
+	}
+	return libcVersion, nil
+}
+
+// fetchLibcVersionForSqlite reads the modernc.org/libc requirement from the go.mod
+// of the given SQLite version, as served by the module proxy.
+func fetchLibcVersionForSqlite(ctx context.Context, client *http.Client, proxy *goProxy, sqliteVersion string) (string, error) {
+	upstreamContents, err := fetchGoMod(ctx, client, proxy, sqliteModulePath, sqliteVersion)
 	if err != nil {
-		return "", fmt.Errorf("sqlite %s not in hardcoded map and upstream fetch failed (%w); please update sqliteLibcMap in patch.go", sqliteVersion, err)
+		return "", err
 	}
 
 	matches := reLibcUpstream.FindStringSubmatch(upstreamContents)
@@ -67,6 +151,36 @@ func getLibcVersionForSqlite(ctx context.Context, sqliteVersion string) (string,
 	return matches[1], nil
 }
 
+// updateSqliteLibcEntries resolves the given SQLite versions through the module proxy
+// and merges them into the existing entries.
+func updateSqliteLibcEntries(
+	ctx context.Context,
+	client *http.Client,
+	proxy *goProxy,
+	entries []sqliteLibcEntry,
+	sqliteVersions []string,
+	retry retryPolicy,
+) ([]sqliteLibcEntry, error) {
+	for _, sqliteVersion := range sqliteVersions {
+		var libcVersion string
+		err := retry.Do(ctx, "fetch sqlite "+sqliteVersion+" go.mod", func() (err error) {
+			libcVersion, err = fetchLibcVersionForSqlite(ctx, client, proxy, sqliteVersion)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		idx := slices.IndexFunc(entries, func(e sqliteLibcEntry) bool { return e.Sqlite == sqliteVersion })
+		if idx == -1 {
+			entries = append(entries, sqliteLibcEntry{Sqlite: sqliteVersion, Libc: libcVersion})
+			continue
+		}
+		entries[idx].Libc = libcVersion
+	}
+	return entries, nil
+}
+
 // patchLibcVersion replaces the modernc.org/libc version in go.mod contents.
 // Returns the modified contents and true if a change was made, original contents and false otherwise.
 func patchLibcVersion(goModContents, expectedVersion string) (string, bool) {
@@ -97,7 +211,7 @@ func patchLibcVersion(goModContents, expectedVersion string) (string, bool) {
 //   - <https://pkg.go.dev/modernc.org/sqlite#hdr-Fragile_modernc_org_libc_dependency>
 //
 //   - <https://gitlab.com/cznic/sqlite/-/issues/177>
-func (m *MemosBuilds) patchModerncSqlite(ctx context.Context, sourceCode *dagger.Directory) (*dagger.Directory, error) {
+func (m *MemosBuilds) patchModerncSqlite(ctx context.Context, sourceCode *dagger.Directory, proxy *goProxy, retry retryPolicy) (*dagger.Directory, error) {
 	goModContents, err := sourceCode.File("go.mod").Contents(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read go.mod: %w. If this persists, check if the upstream project structure has changed", err)
@@ -108,7 +222,7 @@ func (m *MemosBuilds) patchModerncSqlite(ctx context.Context, sourceCode *dagger
 		return sourceCode, nil
 	}
 
-	expectedLibcVersion, err := getLibcVersionForSqlite(ctx, sqliteVersion)
+	expectedLibcVersion, err := getLibcVersionForSqlite(ctx, sqliteVersion, proxy, retry)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +237,12 @@ func (m *MemosBuilds) patchModerncSqlite(ctx context.Context, sourceCode *dagger
 }
 
 // Apply diff patches to the source code.
-func (m *MemosBuilds) applyPatches(ctx context.Context, source *dagger.Directory, patches *dagger.Directory) (*dagger.Directory, error) {
+func (m *MemosBuilds) applyPatches(
+	ctx context.Context,
+	source *dagger.Directory,
+	patches *dagger.Directory,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
 	if patches == nil {
 		return source, nil
 	}
@@ -134,9 +253,19 @@ func (m *MemosBuilds) applyPatches(ctx context.Context, source *dagger.Directory
 		return source, nil
 	}
 
-	return dag.Container().
-		From(buildconsts.PRIMARY_IMAGE).
-		WithExec([]string{"apk", "add", "git", "patch"}).
+	var ctr *dagger.Container
+	err = retry.Do(ctx, "apk add (patches)", func() (err error) {
+		ctr, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "git", "patch"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the patch tools: %w", err)
+	}
+
+	return ctr.
 		WithDirectory("/src", source).
 		WithDirectory("/patches", patches).
 		WithWorkdir("/src").
@@ -0,0 +1,238 @@
+// # Profile-guided optimization.
+//
+// Selects the CPU profile passed to `go build -pgo`, and collects new ones
+// by running a build under a scripted workload.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Directory of the repository holding the CPU profiles.
+const pgoDir = "pgo"
+
+// Profile used when no version-specific one exists.
+const pgoDefaultProfile = "default.pgo"
+
+// Where the selected profile is mounted in the Go build container.
+const pgoMountPath = "/pgo/default.pgo"
+
+// Environment variable enabling the profiling hook of collection builds.
+const pgoProfileEnv = "MEMOS_BUILDS_CPUPROFILE"
+
+// Source added to the main package of collection builds.
+//
+// Profiling starts at init and is flushed on SIGUSR1, as the server never exits on its own.
+const pgoProfilingHook = `package main
+
+import (
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"syscall"
+)
+
+func init() {
+	path := os.Getenv("` + pgoProfileEnv + `")
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		panic(err)
+	}
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGUSR1)
+		<-c
+		pprof.StopCPUProfile()
+		f.Close()
+	}()
+}
+`
+
+// pgoProfile is the CPU profile a build was optimized with.
+type pgoProfile struct {
+	File   *dagger.File `json:"-"`
+	Source string       `json:"source"` // e.g. "pgo/v0.26.1.pgo", or "argument"
+	SHA256 string       `json:"sha256"`
+}
+
+// pgoCandidates returns the profile paths to try for an upstream version, most specific first.
+//
+// E.g. "pgo/v0.26.1.pgo", "pgo/v0.26.pgo", "pgo/default.pgo".
+func pgoCandidates(major, minor, patch uint64) []string {
+	return []string{
+		path.Join(pgoDir, fmt.Sprintf("v%d.%d.%d.pgo", major, minor, patch)),
+		path.Join(pgoDir, fmt.Sprintf("v%d.%d.pgo", major, minor)),
+		path.Join(pgoDir, pgoDefaultProfile),
+	}
+}
+
+// resolvePgoProfile selects the CPU profile for a build.
+//
+// An explicit profile wins; otherwise the most specific profile under `pgo/` is used.
+// Returns nil when there is none, leaving `go build` to its `-pgo=auto` default.
+func (m *MemosBuilds) resolvePgoProfile(
+	ctx context.Context,
+	source *dagger.Directory,
+	profile *activeProfile,
+	override *dagger.File,
+) (*pgoProfile, error) {
+	file, name := override, "argument"
+	if file == nil {
+		v, err := semver.NewVersion(profile.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream version %q: %w", profile.Version, err)
+		}
+		for _, candidate := range pgoCandidates(v.Major(), v.Minor(), v.Patch()) {
+			if ok, _ := source.Exists(ctx, candidate, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType}); ok {
+				file, name = source.File(candidate), candidate
+				break
+			}
+		}
+	}
+	if file == nil {
+		return nil, nil
+	}
+
+	data, err := readFileBytes(ctx, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PGO profile %s: %w", name, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("PGO profile %s is empty", name)
+	}
+	sum := sha256.Sum256(data)
+	return &pgoProfile{File: file, Source: name, SHA256: hex.EncodeToString(sum[:])}, nil
+}
+
+// CollectPgoProfile builds Memos for the engine's platform with a profiling hook, serves it
+// under a scripted workload and returns the resulting CPU profile.
+//
+// Export the result to `pgo/default.pgo` (or `pgo/v<version>.pgo`) and commit it.
+func (m *MemosBuilds) CollectPgoProfile(
+	ctx context.Context,
+	source *dagger.Directory,
+	// +optional
+	version string,
+	// Workload script, run with MEMOS_URL and DURATION (seconds) set. Defaults to `pgo/workload.sh`.
+	// +optional
+	workload *dagger.File,
+	// How long the workload runs, in seconds. Defaults to 60.
+	// +optional
+	duration int,
+	// Retry policy for network-bound steps. See `build`.
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads. See `build`.
+	// +optional
+	goproxy string,
+	// Local module proxy tree. See `build`.
+	// +optional
+	goproxyDir *dagger.Directory,
+) (*dagger.File, error) {
+	if version == "" {
+		version = "nightly"
+	}
+	if source == nil {
+		return nil, fmt.Errorf("source directory must be passed explicitly by the user")
+	}
+	if workload == nil {
+		workload = source.File(path.Join(pgoDir, "workload.sh"))
+	}
+	if duration <= 0 {
+		duration = 60
+	}
+
+	platform, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the engine platform: %w", err)
+	}
+	targets, err := filterTargets(string(platform))
+	if err != nil {
+		return nil, fmt.Errorf("cannot collect a profile on %s: %w", platform, err)
+	}
+
+	// Headless builds keep the workload on the server; the frontend is static files.
+	opts := buildOptions{
+		Headless:   true,
+		Retry:      retry,
+		GoProxy:    goproxy,
+		GoProxyDir: goproxyDir,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Profile an unoptimized build, so the profile does not reinforce itself.
+	profiling := *prepared
+	profiling.PGO = nil
+	profiling.Src = prepared.Src.WithNewFile(path.Join(buildconsts.APP_ENTRYPOINT, "zz_memos_builds_pgo.go"), pgoProfilingHook)
+	build, err := m.compile(ctx, &profiling, targets, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var runner *dagger.Container
+	err = opts.RetryPolicy().Do(ctx, "apk add (profiling)", func() (err error) {
+		runner, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "curl"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the profiling container: %w", err)
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(prepared.Profile.ContainerEnv)) {
+		runner = runner.WithEnvVariable(k, prepared.Profile.ContainerEnv[k])
+	}
+
+	return runner.
+		WithFile("/usr/local/bin/memos", build.Binaries.File(targets[0].BinaryName()), dagger.ContainerWithFileOpts{Permissions: 0755}).
+		WithFile("/workload.sh", workload, dagger.ContainerWithFileOpts{Permissions: 0755}).
+		WithEnvVariable("MEMOS_DATA", "/var/opt/memos").
+		WithEnvVariable("MEMOS_PORT", "5230").
+		WithEnvVariable("MEMOS_URL", "http://127.0.0.1:5230").
+		WithEnvVariable("DURATION", fmt.Sprint(duration)).
+		WithEnvVariable(pgoProfileEnv, "/out/default.pgo").
+		WithDirectory("/var/opt/memos", dag.Directory()).
+		WithDirectory("/out", dag.Directory()).
+		WithExec([]string{"sh", "-c", `
+			memos > /tmp/memos.log 2>&1 &
+			pid=$!
+			for _ in $(seq 1 60); do
+				curl -fsS -o /dev/null "$MEMOS_URL/" && break
+				sleep 1
+			done
+			/workload.sh
+			status=$?
+			kill -USR1 "$pid"
+			sleep 2
+			kill "$pid"
+			if [ "$status" -ne 0 ] || [ ! -s /out/default.pgo ]; then
+				cat /tmp/memos.log
+				exit 1
+			fi
+		`}).
+		File("/out/default.pgo"), nil
+}
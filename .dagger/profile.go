@@ -0,0 +1,241 @@
+// # Version profiles.
+//
+// Per-release quirks of the upstream project, kept in a single table
+// so that rebuilding old versions needs no code changes.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// versionProfile describes the quirks of a range of upstream releases.
+//
+// Every profile whose constraint matches the upstream version applies, in table order.
+type versionProfile struct {
+	// Semver constraint on the upstream version, e.g. "< 0.26.0".
+	Constraint string
+	// Why the profile exists.
+	Comment string
+	// Upstream commits to build instead of the release tags, keyed by version.
+	Commits map[string]string
+	// Platforms that cannot be built in this range (Docker format, e.g. "linux/riscv64").
+	ExcludePlatforms []string
+	// Build image overrides, as comma-separated `tool=image` pairs. `--toolchain` takes precedence.
+	Toolchain string
+	// modernc.org/sqlite version required by the releases in this range. It must be in
+	// sqlite-libc.json, so that they build without looking up modernc.org/libc.
+	Sqlite string
+	// Subdirectories of `patches/` applied after the top-level patches.
+	Patches []string
+	// Subdirectories of `overlays/` layered over the top-level overlays. They are never
+	// read as top-level overlays.
+	Overlays []string
+	// Environment defaults baked into container images, read by the entrypoint.
+	ContainerEnv map[string]string
+}
+
+var versionProfiles = mustCompileVersionProfiles([]versionProfile{
+	{
+		Constraint: "0.24.3",
+		Comment:    "First release on modernc.org/sqlite v1.37.0.",
+		Sqlite:     "v1.37.0",
+	},
+	{
+		Constraint: ">= 0.24.4, <= 0.25.0",
+		Comment:    "Releases on modernc.org/sqlite v1.37.1.",
+		Sqlite:     "v1.37.1",
+	},
+	{
+		Constraint: ">= 0.25.1, <= 0.26.2",
+		Comment:    "Releases on modernc.org/sqlite v1.38.2.",
+		Sqlite:     "v1.38.2",
+	},
+	{
+		Constraint: ">= 0.26.3, < 0.29.0",
+		Comment:    "Releases on modernc.org/sqlite v1.46.1.",
+		Sqlite:     "v1.46.1",
+	},
+	{
+		Constraint: "0.29.0",
+		Comment:    "First release on modernc.org/sqlite v1.50.0.",
+		Sqlite:     "v1.50.0",
+	},
+	{
+		Constraint: ">= 0.25.2, <= 0.26.1",
+		Comment:    "Release tags that do not match the published release.",
+		Commits: map[string]string{
+			"0.25.2": "bfad0708e2c8062664e852f6f18223fd943ad5f5",
+			"0.25.3": "07a030ddfdbe5ac8a22c235be7b5771cc01f8498",
+			"0.26.0": "43b5a51ec73214d3c56aa48c82783ccfeec1a127",
+			"0.26.1": "b623162d37f87f9f174d8f6cd8e54c7034cfc789",
+		},
+	},
+})
+
+// compiledProfile is a versionProfile with its constraint parsed.
+type compiledProfile struct {
+	versionProfile
+	constraint *semver.Constraints
+}
+
+// compileVersionProfiles parses and validates the profile table.
+func compileVersionProfiles(profiles []versionProfile) ([]compiledProfile, error) {
+	available := make([]string, 0, len(TARGETS))
+	for _, t := range TARGETS {
+		available = append(available, t.DockerPlatform())
+	}
+
+	compiled := make([]compiledProfile, 0, len(profiles))
+	for _, p := range profiles {
+		constraint, err := semver.NewConstraint(p.Constraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version profile constraint %q: %w", p.Constraint, err)
+		}
+		for _, platform := range p.ExcludePlatforms {
+			if !slices.Contains(available, platform) {
+				return nil, fmt.Errorf("version profile %q excludes unknown platform %q", p.Constraint, platform)
+			}
+		}
+		if _, err := parseToolchainOverrides(p.Toolchain); err != nil {
+			return nil, fmt.Errorf("version profile %q: %w", p.Constraint, err)
+		}
+		if _, ok := sqliteLibcMap[p.Sqlite]; p.Sqlite != "" && !ok {
+			return nil, fmt.Errorf("version profile %q requires modernc.org/sqlite %s, which is not in sqlite-libc.json", p.Constraint, p.Sqlite)
+		}
+		for _, dir := range p.Overlays {
+			if dir == "" || strings.ContainsAny(dir, `/\`) || strings.HasPrefix(dir, ".") {
+				return nil, fmt.Errorf("version profile %q lists overlay directory %q, which is not a plain directory name", p.Constraint, dir)
+			}
+		}
+		for version := range p.Commits {
+			v, err := semver.NewVersion(version)
+			if err != nil || !constraint.Check(v) {
+				return nil, fmt.Errorf("version profile %q pins a commit for %q, outside its range", p.Constraint, version)
+			}
+			if !commitHashPattern.MatchString(p.Commits[version]) {
+				return nil, fmt.Errorf("version profile %q pins an invalid commit for %q", p.Constraint, version)
+			}
+		}
+		compiled = append(compiled, compiledProfile{versionProfile: p, constraint: constraint})
+	}
+	return compiled, nil
+}
+
+func mustCompileVersionProfiles(profiles []versionProfile) []compiledProfile {
+	compiled, err := compileVersionProfiles(profiles)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// activeProfile is the combination of every profile matching an upstream version.
+type activeProfile struct {
+	// Upstream version the profiles were matched against.
+	Version string
+	// Constraints of the matching profiles.
+	Matched []string
+	// Unbuildable platforms, and why.
+	Excluded map[string]string
+	// Build image overrides, by tool.
+	Toolchain map[string]string
+	// Subdirectories of `patches/` to apply, in order.
+	Patches []string
+	// Subdirectories of `overlays/` to layer, in order.
+	Overlays []string
+	// Environment defaults for container images.
+	ContainerEnv map[string]string
+}
+
+// matchVersionProfiles combines the profiles matching an upstream version, later ones taking precedence.
+//
+// Pre-release and build metadata are ignored, so nightlies match the release they lead to.
+func matchVersionProfiles(profiles []compiledProfile, version *semver.Version) *activeProfile {
+	core := semver.New(version.Major(), version.Minor(), version.Patch(), "", "")
+	active := &activeProfile{
+		Version:      core.String(),
+		Excluded:     map[string]string{},
+		Toolchain:    map[string]string{},
+		ContainerEnv: map[string]string{},
+	}
+	for _, p := range profiles {
+		if !p.constraint.Check(core) {
+			continue
+		}
+		active.Matched = append(active.Matched, p.Constraint)
+		for _, platform := range p.ExcludePlatforms {
+			active.Excluded[platform] = p.Comment
+		}
+		// Validated by compileVersionProfiles.
+		overrides, _ := parseToolchainOverrides(p.Toolchain)
+		maps.Copy(active.Toolchain, overrides)
+		active.Patches = append(active.Patches, p.Patches...)
+		active.Overlays = append(active.Overlays, p.Overlays...)
+		maps.Copy(active.ContainerEnv, p.ContainerEnv)
+	}
+	return active
+}
+
+// overlayDirs returns the subdirectories of `overlays/` listed by any profile.
+func overlayDirs(profiles []compiledProfile) []string {
+	var dirs []string
+	for _, p := range profiles {
+		for _, dir := range p.Overlays {
+			if !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// pinnedCommit returns the commit to build instead of the tag of a release, if any.
+func pinnedCommit(profiles []compiledProfile, version string) (string, bool) {
+	for _, p := range profiles {
+		if commit, ok := p.Commits[version]; ok {
+			return commit, true
+		}
+	}
+	return "", false
+}
+
+// Buildable drops the targets excluded by the profile.
+//
+// Explicitly requested platforms are reported instead of being silently dropped.
+func (p *activeProfile) Buildable(targets []BuildMatrix, platforms string) ([]BuildMatrix, error) {
+	explicit := strings.TrimSpace(platforms) != "" && strings.TrimSpace(platforms) != "all"
+
+	var buildable []BuildMatrix
+	for _, t := range targets {
+		reason, excluded := p.Excluded[t.DockerPlatform()]
+		if !excluded {
+			buildable = append(buildable, t)
+			continue
+		}
+		if explicit {
+			return nil, fmt.Errorf("%s cannot be built for upstream %s: %s", t.DockerPlatform(), p.Version, reason)
+		}
+	}
+	if len(buildable) == 0 {
+		return nil, fmt.Errorf("no buildable platforms for upstream %s", p.Version)
+	}
+	return buildable, nil
+}
+
+// upstreamVersion returns the upstream release a build input corresponds to.
+//
+// Tags and release branches carry it in their name; other refs are read from the source.
+func (m *MemosBuilds) upstreamVersion(ctx context.Context, version string, source *dagger.Directory) *semver.Version {
+	if v, err := semver.NewVersion(strings.TrimPrefix(version, "release/")); err == nil {
+		return v
+	}
+	// extractVersionFromSource always returns a valid version.
+	return semver.MustParse(m.extractVersionFromSource(ctx, source))
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"dagger/memos-builds/buildconsts"
 	"dagger/memos-builds/internal/dagger"
@@ -20,70 +21,66 @@ type PublishedImage struct {
 	Ref      string `json:"ref"`              // full ref with digest
 }
 
-// createArchive creates a tar.gz or zip archive from a binary file.
+// createArchive creates the tar.gz or zip release archive of a target, holding its binary and
+// the bundled files under a versioned top-level directory.
 func (m *MemosBuilds) createArchive(
 	binary *dagger.File,
-	archiveName string,
+	bundle *archiveBundle,
+	t BuildMatrix,
+	version string,
+	archiver *dagger.Container,
 ) *dagger.File {
-	isZip := strings.HasSuffix(archiveName, ".zip")
-
-	binaryInArchive := "memos"
-	if isZip {
-		binaryInArchive = "memos.exe"
-	}
-
-	ctr := dag.Container().
-		From(buildconsts.PRIMARY_IMAGE).
-		WithWorkdir("/work").
-		WithFile("/work/"+binaryInArchive, binary).
-		WithExec([]string{"chmod", "+x", binaryInArchive})
-
-	if isZip {
-		ctr = ctr.
-			WithExec([]string{"apk", "add", "--no-cache", "zip"}).
-			WithExec([]string{"zip", "-9", archiveName, binaryInArchive})
-	} else {
-		ctr = ctr.WithExec([]string{"tar", "-czvf", archiveName, binaryInArchive})
-	}
-
-	return ctr.File("/work/" + archiveName)
+	contents := dag.Directory().WithDirectory(t.ArchiveDir(version), bundle.Contents(t, version, binary))
+	return m.createDirectoryArchive(contents, t.ArchiveName(version), archiver)
 }
 
-// createReleaseArchives creates release archives for the given targets.
+// createReleaseArchives creates release archives for the built targets,
+// plus a .pkg for FreeBSD targets and a -service.zip for Windows targets.
 // Returns a directory containing all archives.
 func (m *MemosBuilds) createReleaseArchives(
-	binaries *dagger.Directory,
+	ctx context.Context,
+	build *buildResult,
+	bundle *archiveBundle,
 	version string,
-	targets []BuildMatrix,
-) *dagger.Directory {
+	archiver *dagger.Container,
+) (*dagger.Directory, error) {
 	out := dag.Directory()
-
-	for _, t := range targets {
-		binaryName := t.BinaryName()
-		archiveName := t.ArchiveName(version)
-
-		binary := binaries.File(binaryName)
-		archive := m.createArchive(binary, archiveName)
-		out = out.WithFile(archiveName, archive)
+	buildTime := time.Now().UTC().Truncate(time.Second)
+
+	for _, t := range build.Targets {
+		binary := build.Binaries.File(t.BinaryName())
+		out = out.WithFile(t.ArchiveName(version), m.createArchive(binary, bundle, t, version, archiver))
+		switch t.OS {
+		case "freebsd":
+			pkg, err := m.createFreeBSDPackage(ctx, build.Prepared, bundle, t, binary, buildTime)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", t.PackageName(version, ".pkg"), err)
+			}
+			out = out.WithFile(t.PackageName(version, ".pkg"), pkg)
+		case "windows":
+			service, err := m.createServiceArchive(binary, bundle, t, version, archiver)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", t.ServiceArchiveName(version), err)
+			}
+			out = out.WithFile(t.ServiceArchiveName(version), service)
+		}
 	}
 
-	return out
+	return out, nil
 }
 
-// generateChecksums creates a SHA256SUMS file for all archives in the directory.
+// generateChecksums creates a SHA256SUMS file for all archives and packages in the directory.
 func (m *MemosBuilds) generateChecksums(
 	archives *dagger.Directory,
-	version string,
+	checksumFile string,
 ) *dagger.File {
-	checksumFile := fmt.Sprintf(buildconsts.CHECKSUM_FILE_FORMAT, version)
-
 	ctr := dag.Container().
 		From(buildconsts.PRIMARY_IMAGE).
 		WithWorkdir("/work").
 		WithDirectory("/work", archives).
-		// Generate checksums for all archive files
+		// Generate checksums for all archive and package files
 		// Output format: <hash>  <filename> (two spaces)
-		WithExec([]string{"sh", "-c", "sha256sum *.tar.gz *.zip 2>/dev/null | sort > " + checksumFile})
+		WithExec([]string{"sh", "-c", "sha256sum *.tar.gz *.zip *.deb *.rpm *.apk *.pkg.tar.zst *.pkg 2>/dev/null | sort > " + checksumFile})
 
 	return ctr.File("/work/" + checksumFile)
 }
@@ -152,6 +149,20 @@ func (m *MemosBuilds) tagsForRegistry(version string, registry string) []string
 	return []string{"nightly"}
 }
 
+// withTagSuffix appends build labels to container tags, keeping variant images apart from stock ones.
+// E.g. ["latest", "0.25"] with labels ["acme"] -> ["latest-acme", "0.25-acme"].
+func withTagSuffix(tags []string, labels []string) []string {
+	if len(labels) == 0 {
+		return tags
+	}
+	suffix := "-" + strings.Join(labels, "-")
+	suffixed := make([]string, len(tags))
+	for i, tag := range tags {
+		suffixed[i] = tag + suffix
+	}
+	return suffixed
+}
+
 // parsePublishedRef extracts the digest from a fully-qualified image reference.
 // Input: "ghcr.io/memospot/memos-builds:nightly@sha256:abc123..."
 // Output: "sha256:abc123..."
@@ -162,28 +173,28 @@ func parsePublishedRef(ref string) string {
 	return ""
 }
 
-// publishContainers builds and publishes multi-arch Docker images to registries.
+// publishContainers assembles and publishes multi-arch Docker images to registries.
 // Called internally by Publish — not exposed as a standalone Dagger function
 // to avoid redundant Build calls.
+//
+// Images are assembled from the binaries of the archive build; nothing is recompiled.
 func (m *MemosBuilds) publishContainers(
 	ctx context.Context,
 	source *dagger.Directory,
-	gitSrc *dagger.Directory,
-	buildVersion string,
-	releaseVersion string,
-	commit string,
+	build *buildResult,
 	dockerHubUser string,
 	dockerHubPassword *dagger.Secret,
 	ghcrUser string,
 	ghcrPassword *dagger.Secret,
+	retry retryPolicy,
 ) ([]PublishedImage, error) {
-	// Build binaries for Linux targets only (containers are Linux-only).
-	linuxTargets := filterLinuxTargets(TARGETS)
+	// Containers are Linux-only.
+	linuxTargets := filterLinuxTargets(build.Targets)
 	if len(linuxTargets) == 0 {
 		return nil, nil
 	}
 
-	platformVariants, err := m.buildContainers(ctx, source, gitSrc, buildVersion, commit, linuxTargets)
+	platformVariants, err := m.assembleContainers(ctx, source, build, linuxTargets, retry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build containers: %w", err)
 	}
@@ -212,14 +223,18 @@ func (m *MemosBuilds) publishContainers(
 	for _, target := range publishTargets {
 		if target.user != "" && target.password != nil {
 			address := strings.Split(target.registry, "/")[0]
-			tags := m.tagsForRegistry(releaseVersion, target.registry)
+			tags := withTagSuffix(m.tagsForRegistry(build.Prepared.ReleaseVersion, target.registry), build.Prepared.Labels())
 			publisher := platformVariants[0].
 				WithRegistryAuth(address, target.user, target.password)
 			for _, tag := range tags {
 				addr := fmt.Sprintf("%s:%s", target.registry, tag)
-				ref, err := publisher.Publish(ctx, addr, dagger.ContainerPublishOpts{
-					PlatformVariants: platformVariants[1:],
-					MediaTypes:       target.media,
+				var ref string
+				err := retry.Do(ctx, "publish "+addr, func() (err error) {
+					ref, err = publisher.Publish(ctx, addr, dagger.ContainerPublishOpts{
+						PlatformVariants: platformVariants[1:],
+						MediaTypes:       target.media,
+					})
+					return err
 				})
 				if err != nil {
 					return nil, fmt.Errorf("failed to publish to %s: %w", target.registry, err)
@@ -0,0 +1,388 @@
+// # Package repositories.
+//
+// Static APT and RPM repositories of the native packages, for any static host. Metadata
+// is generated with the distributions' own tooling and signed with an OpenPGP key; the
+// packages themselves are unsigned.
+//
+// Repositories are tested by installing from them in Debian and Fedora containers with
+// every other source removed and network access proxied to nowhere.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// Metadata of the APT repository, and the name of its only component.
+const (
+	aptOrigin    = "Memospot"
+	aptLabel     = "memos"
+	aptComponent = "main"
+)
+
+// Public key files written to the repositories.
+const (
+	aptKeyring = "memos-archive-keyring.gpg"
+	rpmKey     = "RPM-GPG-KEY-memos"
+)
+
+// Where the signing key and its passphrase are mounted.
+const (
+	signingKeyPath        = "/run/secrets/signing-key"
+	signingPassphrasePath = "/run/secrets/signing-passphrase"
+)
+
+// An unused local port: test containers reach nothing but the repository.
+const offlineProxy = "http://127.0.0.1:9"
+
+// Imports the signing key and defines `sign`, which signs with it. FPR is its fingerprint.
+const importSigningKeyScript = `
+	set -eu
+	export GNUPGHOME="$(mktemp -d)"
+	gpg --batch --quiet --import ` + signingKeyPath + `
+	FPR=$(gpg --batch --with-colons --list-secret-keys | awk -F: '$1 == "fpr" { print $10; exit }')
+	if [ -z "$FPR" ]; then
+		echo "the signing key holds no private key" >&2
+		exit 1
+	fi
+	sign() {
+		if [ -f ` + signingPassphrasePath + ` ]; then
+			set -- --passphrase-file ` + signingPassphrasePath + ` "$@"
+		fi
+		gpg --batch --yes --pinentry-mode loopback --local-user "$FPR" "$@"
+	}
+`
+
+// Defines ` + "`unique_packages`" + `, which reads "<name> <version> <arch>", checksum and path lines
+// separated by tabs, and prints the path of the first package of each name, version and
+// architecture. Package managers tell packages apart by these alone, so packages sharing
+// them with different contents are rejected.
+const uniquePackagesScript = `
+	unique_packages() {
+		awk -F '\t' '
+			!($1 in sum) { sum[$1] = $2; path[$1] = $3; print $3; next }
+			sum[$1] != $2 { printf "conflicting packages for %s: %s and %s\n", $1, path[$1], $3 > "/dev/stderr"; failed = 1 }
+			END { exit failed }
+		'
+	}
+`
+
+// Lays out the .deb files of /in in a pool, then writes and signs the suite indexes.
+const aptRepositoryScript = importSigningKeyScript + uniquePackagesScript + `
+	cd /repo
+	find /in -name '*.deb' | sort | while read -r deb; do
+		printf '%s\t%s\t%s\n' "$(dpkg-deb --show --showformat '${Package} ${Version} ${Architecture}' "$deb")" \
+			"$(sha256sum < "$deb" | cut -d ' ' -f 1)" "$deb"
+	done > /tmp/debs
+	unique_packages < /tmp/debs > /tmp/unique
+	while read -r deb; do
+		name=$(dpkg-deb --field "$deb" Package)
+		pool="pool/$COMPONENT/$(printf %.1s "$name")/$name"
+		mkdir -p "$pool"
+		cp "$deb" "$pool/"
+	done < /tmp/unique
+	if [ ! -d pool ]; then
+		echo "no .deb packages found" >&2
+		exit 1
+	fi
+
+	arches=$(find pool -name '*.deb' -exec dpkg-deb --field {} Architecture \; | sort -u | tr '\n' ' ')
+	for arch in $arches; do
+		dir="dists/$SUITE/$COMPONENT/binary-$arch"
+		mkdir -p "$dir"
+		apt-ftparchive --arch "$arch" packages pool > "$dir/Packages"
+		gzip -9nk "$dir/Packages"
+	done
+
+	prefix=APT::FTPArchive::Release
+	apt-ftparchive \
+		-o "$prefix::Origin=$ORIGIN" -o "$prefix::Label=$LABEL" \
+		-o "$prefix::Suite=$SUITE" -o "$prefix::Codename=$SUITE" \
+		-o "$prefix::Architectures=${arches% }" -o "$prefix::Components=$COMPONENT" \
+		-o "$prefix::Description=$DESCRIPTION" \
+		release "dists/$SUITE" > /tmp/Release
+	mv /tmp/Release "dists/$SUITE/Release"
+	sign --clearsign --output "dists/$SUITE/InRelease" "dists/$SUITE/Release"
+	sign --armor --detach-sign --output "dists/$SUITE/Release.gpg" "dists/$SUITE/Release"
+	gpg --batch --export "$FPR" > "$KEYRING"
+`
+
+// Copies the .rpm files of /in, then writes and signs the repodata.
+//
+// Metadata is gzip-compressed, which older dnf and yum versions read too.
+const rpmRepositoryScript = importSigningKeyScript + uniquePackagesScript + `
+	cd /repo
+	mkdir -p packages
+	find /in -name '*.rpm' | sort | while read -r rpm; do
+		printf '%s\t%s\t%s\n' "$(rpm -qp --queryformat '%{NAME} %{EPOCHNUM}:%{VERSION}-%{RELEASE} %{ARCH}' "$rpm")" \
+			"$(sha256sum < "$rpm" | cut -d ' ' -f 1)" "$rpm"
+	done > /tmp/rpms
+	unique_packages < /tmp/rpms > /tmp/unique
+	while read -r rpm; do
+		cp "$rpm" packages/
+	done < /tmp/unique
+	if [ -z "$(ls packages)" ]; then
+		echo "no .rpm packages found" >&2
+		exit 1
+	fi
+	createrepo_c --quiet --general-compress-type=gz .
+	sign --armor --detach-sign --output repodata/repomd.xml.asc repodata/repomd.xml
+	gpg --batch --armor --export "$FPR" > "$KEY"
+`
+
+// Installs the package with the highest version for the engine's architecture from the APT
+// repository at /repo, among memos and its FIPS or branded variants.
+const aptInstallTestScript = `
+	set -eu
+	rm -f /etc/apt/sources.list /etc/apt/sources.list.d/*
+	cp /memos.sources /etc/apt/sources.list.d/
+	apt-get update
+	name= version=
+	for candidate in $(apt-cache pkgnames memos); do
+		candidate_version=$(apt-cache policy "$candidate" | awk '$1 == "Candidate:" { print $2 }')
+		if [ -z "$name" ] || dpkg --compare-versions "$candidate_version" gt "$version"; then
+			name=$candidate version=$candidate_version
+		fi
+	done
+	if [ -z "$name" ]; then
+		echo "no package for $(dpkg --print-architecture)" >&2
+		exit 1
+	fi
+	apt-get install -y "$name=$version"
+	[ "$(dpkg-query --show --showformat '${Version}' "$name")" = "$version" ]
+` + installCheckScript
+
+// Installs the package with the highest version for the engine's architecture from the RPM
+// repository at /repo, among memos and its FIPS or branded variants.
+const rpmInstallTestScript = `
+	set -eu
+	rm -f /etc/yum.repos.d/*
+	cp /memos.repo /etc/yum.repos.d/
+	name= version=
+	for candidate in $(dnf -y repoquery --latest-limit 1 --queryformat '%{name}:%{evr}\n'); do
+		candidate_version=${candidate#*:}
+		if [ -z "$name" ] || [ "$(rpm --eval "%{lua: print(rpm.vercmp('$candidate_version', '$version'))}")" = 1 ]; then
+			name=${candidate%%:*} version=$candidate_version
+		fi
+	done
+	if [ -z "$name" ]; then
+		echo "no package for $(uname -m)" >&2
+		exit 1
+	fi
+	dnf -y install "$name-$version"
+	[ "$(rpm -q --queryformat '%{EVR}' "$name")" = "$version" ]
+` + installCheckScript
+
+// Checks what a package installs. Services are not started, as containers run no init.
+const installCheckScript = `
+	memos --version
+	test -f /etc/memos/memos.env
+	test -f /usr/lib/systemd/system/memos.service
+	[ "$(stat -c %U /var/opt/memos)" = memos ]
+`
+
+// aptSources returns a deb822 source of the APT repository.
+func aptSources(uri, keyring, suite string) string {
+	return fmt.Sprintf("Types: deb\nURIs: %s\nSuites: %s\nComponents: %s\nSigned-By: %s\n", uri, suite, aptComponent, keyring)
+}
+
+// rpmRepoFile returns a dnf/yum definition of the RPM repository.
+//
+// Only the metadata is signed: it holds the checksums of the packages.
+func rpmRepoFile(baseURL, keyURL string) string {
+	return fmt.Sprintf(`[memos]
+name=Memos
+baseurl=%s
+enabled=1
+gpgcheck=0
+repo_gpgcheck=1
+gpgkey=%s
+`, baseURL, keyURL)
+}
+
+// repositoryContainer returns a container of the given image with the tools to generate a
+// repository, installed by the given command, and the signing key and the packages mounted.
+func repositoryContainer(
+	ctx context.Context,
+	image string,
+	step string,
+	install string,
+	packages *dagger.Directory,
+	signingKey *dagger.Secret,
+	signingPassphrase *dagger.Secret,
+	retry retryPolicy,
+) (*dagger.Container, error) {
+	var ctr *dagger.Container
+	err := retry.Do(ctx, step, func() (err error) {
+		ctr, err = dag.Container().
+			From(image).
+			WithExec([]string{"sh", "-c", install}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctr = ctr.
+		WithMountedSecret(signingKeyPath, signingKey).
+		WithDirectory("/in", packages).
+		WithDirectory("/repo", dag.Directory())
+	if signingPassphrase != nil {
+		ctr = ctr.WithMountedSecret(signingPassphrasePath, signingPassphrase)
+	}
+	return ctr, nil
+}
+
+// createAptRepository returns a signed APT repository of the .deb files found in packages.
+func createAptRepository(
+	ctx context.Context,
+	packages *dagger.Directory,
+	suite string,
+	signingKey *dagger.Secret,
+	signingPassphrase *dagger.Secret,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
+	ctr, err := repositoryContainer(ctx, buildconsts.DEBIAN_IMAGE, "apt-get install (repository)",
+		"apt-get update && apt-get install -y --no-install-recommends apt-utils gnupg", packages, signingKey, signingPassphrase, retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the APT repository container: %w", err)
+	}
+	repo, err := ctr.
+		WithEnvVariable("SUITE", suite).
+		WithEnvVariable("COMPONENT", aptComponent).
+		WithEnvVariable("ORIGIN", aptOrigin).
+		WithEnvVariable("LABEL", aptLabel).
+		WithEnvVariable("DESCRIPTION", packageSummary).
+		WithEnvVariable("KEYRING", aptKeyring).
+		WithExec([]string{"sh", "-c", aptRepositoryScript}).
+		Directory("/repo").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the APT repository: %w", err)
+	}
+	return repo, nil
+}
+
+// createRPMRepository returns an RPM repository with signed metadata, of the .rpm files found in packages.
+func createRPMRepository(
+	ctx context.Context,
+	packages *dagger.Directory,
+	signingKey *dagger.Secret,
+	signingPassphrase *dagger.Secret,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
+	ctr, err := repositoryContainer(ctx, buildconsts.FEDORA_IMAGE, "dnf install (repository)",
+		"dnf install -y --setopt=install_weak_deps=False createrepo_c findutils gnupg2", packages, signingKey, signingPassphrase, retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the RPM repository container: %w", err)
+	}
+	repo, err := ctr.
+		WithEnvVariable("KEY", rpmKey).
+		WithExec([]string{"sh", "-c", rpmRepositoryScript}).
+		Directory("/repo").
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the RPM repository: %w", err)
+	}
+	return repo, nil
+}
+
+// testRepositories installs the highest version from each repository, in Debian and Fedora
+// containers of the engine's platform that can reach nothing else.
+func testRepositories(ctx context.Context, apt, rpm *dagger.Directory, suite string) error {
+	_, err := dag.Container().
+		From(buildconsts.DEBIAN_IMAGE).
+		WithDirectory("/repo", apt).
+		WithNewFile("/memos.sources", aptSources("file:/repo", "/repo/"+aptKeyring, suite)).
+		WithEnvVariable("http_proxy", offlineProxy).
+		WithEnvVariable("https_proxy", offlineProxy).
+		WithExec([]string{"sh", "-c", aptInstallTestScript}).
+		Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to install from the APT repository: %w", err)
+	}
+
+	_, err = dag.Container().
+		From(buildconsts.FEDORA_IMAGE).
+		WithDirectory("/repo", rpm).
+		WithNewFile("/memos.repo", rpmRepoFile("file:///repo", "file:///repo/"+rpmKey)).
+		WithEnvVariable("http_proxy", offlineProxy).
+		WithEnvVariable("https_proxy", offlineProxy).
+		WithExec([]string{"sh", "-c", rpmInstallTestScript}).
+		Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to install from the RPM repository: %w", err)
+	}
+	return nil
+}
+
+// PackageRepository generates static APT and RPM repositories from the packages of one or more
+// versions, with metadata signed by an OpenPGP key, ready to be synced to any static host.
+//
+// Both repositories are tested by installing from them offline in Debian and Fedora
+// containers of the engine's platform.
+func (m *MemosBuilds) PackageRepository(
+	ctx context.Context,
+	// Outputs of `packages` or `build --packages`, e.g. one per version. Other files are ignored.
+	packages []*dagger.Directory,
+	// ASCII-armored OpenPGP private key signing the repository metadata.
+	signingKey *dagger.Secret,
+	// Passphrase of the signing key, when it has one.
+	// +optional
+	signingPassphrase *dagger.Secret,
+	// URL the repositories are served from. Adds `apt/memos.sources` and `rpm/memos.repo` for clients.
+	// +optional
+	url string,
+	// APT suite (and codename). Defaults to "stable".
+	// +optional
+	suite string,
+	// Skip the installation tests, e.g. when there is no package for the engine's platform.
+	// +optional
+	skipTest bool,
+	// Retry policy for network-bound steps. See `build`.
+	// +optional
+	retry string,
+) (*dagger.Directory, error) {
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("at least one packages directory is required")
+	}
+	if suite == "" {
+		suite = "stable"
+	}
+	policy, err := parseRetryPolicy(retry)
+	if err != nil {
+		return nil, err
+	}
+
+	in := dag.Directory()
+	for i, dir := range packages {
+		in = in.WithDirectory(fmt.Sprint(i), dir, dagger.DirectoryWithDirectoryOpts{
+			Include: []string{"**/*.deb", "**/*.rpm"},
+		})
+	}
+
+	apt, err := createAptRepository(ctx, in, suite, signingKey, signingPassphrase, policy)
+	if err != nil {
+		return nil, err
+	}
+	rpm, err := createRPMRepository(ctx, in, signingKey, signingPassphrase, policy)
+	if err != nil {
+		return nil, err
+	}
+	if !skipTest {
+		if err := testRepositories(ctx, apt, rpm, suite); err != nil {
+			return nil, err
+		}
+	}
+
+	if url = strings.TrimSuffix(url, "/"); url != "" {
+		apt = apt.WithNewFile("memos.sources", aptSources(url+"/apt", "/etc/apt/keyrings/"+aptKeyring, suite))
+		rpm = rpm.WithNewFile("memos.repo", rpmRepoFile(url+"/rpm", url+"/rpm/"+rpmKey))
+	}
+	return dag.Directory().
+		WithDirectory("apt", apt).
+		WithDirectory("rpm", rpm), nil
+}
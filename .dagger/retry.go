@@ -0,0 +1,167 @@
+// # Retry policy.
+//
+// Retries network-bound pipeline steps (git clone, package installs, module proxy
+// requests, registry pushes) on transient errors, with exponential backoff.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/internal/dagger"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Used when the retry argument is empty.
+var defaultRetryPolicy = retryPolicy{
+	Attempts:   3,
+	Backoff:    2 * time.Second,
+	MaxBackoff: 30 * time.Second,
+}
+
+// Lowercase fragments of error output that indicate a transient network failure.
+var retryableMessages = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"could not resolve host",
+	"eai_again",
+	"econnreset",
+	"econnrefused",
+	"etimedout",
+	"i/o timeout",
+	"network is unreachable",
+	"no such host",
+	"temporary error",
+	"temporary failure",
+	"timeout awaiting response headers",
+	"tls handshake timeout",
+	"too many requests",
+	"unexpected eof",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// retryPolicy controls how often and how fast a failing step is retried.
+type retryPolicy struct {
+	// Total number of tries, including the first one. 1 disables retries.
+	Attempts int
+	// Delay before the first retry, doubled after each one.
+	Backoff time.Duration
+	// Upper bound of the delay between retries.
+	MaxBackoff time.Duration
+	// Where retries are logged. Nil selects stderr, which Dagger shows in the function trace.
+	Log io.Writer
+}
+
+// parseRetryPolicy parses comma-separated `key=value` pairs over the default policy.
+//
+// Keys: attempts, backoff, max-backoff. E.g. "attempts=5,backoff=1s".
+func parseRetryPolicy(policy string) (retryPolicy, error) {
+	p := defaultRetryPolicy
+	for pair := range strings.SplitSeq(policy, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return retryPolicy{}, fmt.Errorf("invalid retry setting %q, expected key=value", pair)
+		}
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "attempts":
+			p.Attempts, err = strconv.Atoi(value)
+			if err == nil && p.Attempts < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "backoff":
+			p.Backoff, err = time.ParseDuration(value)
+		case "max-backoff":
+			p.MaxBackoff, err = time.ParseDuration(value)
+		default:
+			return retryPolicy{}, fmt.Errorf("unknown retry setting %q (available: attempts, backoff, max-backoff)", key)
+		}
+		if err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid retry %s %q: %w", key, value, err)
+		}
+	}
+	if p.Backoff < 0 || p.MaxBackoff < p.Backoff {
+		return retryPolicy{}, fmt.Errorf("retry backoff must be between 0 and max-backoff")
+	}
+	return p, nil
+}
+
+// httpStatusError is an unexpected HTTP response status.
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// isRetryable reports whether an error looks transient.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errModuleNotFound) {
+		return false
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	text := err.Error()
+	var execErr *dagger.ExecError
+	if errors.As(err, &execErr) {
+		text += "\n" + execErr.Stdout + "\n" + execErr.Stderr
+	}
+	text = strings.ToLower(text)
+	for _, msg := range retryableMessages {
+		if strings.Contains(text, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do runs fn until it succeeds, fails with a non-retryable error, or runs out of attempts.
+//
+// Every retry is logged to p.Log.
+func (p retryPolicy) Do(ctx context.Context, step string, fn func() error) error {
+	log := p.Log
+	if log == nil {
+		log = os.Stderr
+	}
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !isRetryable(err) {
+			return err
+		}
+
+		summary, _, _ := strings.Cut(err.Error(), "\n")
+		fmt.Fprintf(log, "retry: %s failed (attempt %d/%d), retrying in %s: %s\n", step, attempt, p.Attempts, delay, summary)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, p.MaxBackoff)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy retries without waiting, logging to log.
+func testRetryPolicy(attempts int, log io.Writer) retryPolicy {
+	return retryPolicy{Attempts: attempts, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Log: log}
+}
+
+// flakyProxy serves a go.mod after failing the first failures requests with fail.
+// It returns the server and its request counter.
+func flakyProxy(t *testing.T, failures int, fail func(w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(requests.Add(1)) <= failures {
+			fail(w)
+			return
+		}
+		if r.URL.Path != "/modernc.org/sqlite/@v/v1.38.2.mod" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "module modernc.org/sqlite\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// Responds with 503 Service Unavailable.
+func unavailable(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+// Closes the connection without responding.
+func dropConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+// fetchWithRetry fetches the go.mod of modernc.org/sqlite v1.38.2 from a proxy, as getLibcVersionForSqlite does.
+func fetchWithRetry(srv *httptest.Server, policy retryPolicy, suffix string) (string, error) {
+	ctx := context.Background()
+	var contents string
+	err := policy.Do(ctx, "fetch sqlite go.mod", func() (err error) {
+		contents, err = fetchFromProxy(ctx, srv.Client(), nil, srv.URL, suffix)
+		return err
+	})
+	return contents, err
+}
+
+func TestRetryPolicyDoRecoversFromTransientFailures(t *testing.T) {
+	for name, fail := range map[string]func(http.ResponseWriter){
+		"503":                unavailable,
+		"dropped connection": dropConnection,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, requests := flakyProxy(t, 2, fail)
+			var log bytes.Buffer
+
+			contents, err := fetchWithRetry(srv, testRetryPolicy(5, &log), "modernc.org/sqlite/@v/v1.38.2.mod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contents != "module modernc.org/sqlite\n" {
+				t.Errorf("contents = %q", contents)
+			}
+			if n := requests.Load(); n != 3 {
+				t.Errorf("made %d requests, want 3", n)
+			}
+			lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("logged %d retries, want 2:\n%s", len(lines), log.String())
+			}
+			for i, line := range lines {
+				want := fmt.Sprintf("retry: fetch sqlite go.mod failed (attempt %d/5)", i+1)
+				if !strings.HasPrefix(line, want) {
+					t.Errorf("log line %q, want prefix %q", line, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsOnModuleNotFound(t *testing.T) {
+	srv, requests := flakyProxy(t, 0, nil)
+	var log bytes.Buffer
+
+	_, err := fetchWithRetry(srv, testRetryPolicy(5, &log), "modernc.org/sqlite/@v/v0.0.0.mod")
+	if !errors.Is(err, errModuleNotFound) {
+		t.Fatalf("err = %v, want errModuleNotFound", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+	if log.Len() != 0 {
+		t.Errorf("logged retries for a missing module:\n%s", log.String())
+	}
+}
+
+func TestRetryPolicyDoGivesUpAfterAttempts(t *testing.T) {
+	srv, requests := flakyProxy(t, 100, unavailable)
+	var log bytes.Buffer
+
+	_, err := fetchWithRetry(srv, testRetryPolicy(3, &log), "modernc.org/sqlite/@v/v1.38.2.mod")
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want the last 503", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+	if n := strings.Count(log.String(), "\n"); n != 2 {
+		t.Errorf("logged %d retries, want 2:\n%s", n, log.String())
+	}
+}
+
+func TestRetryPolicyDoStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	policy := retryPolicy{Attempts: 5, Backoff: time.Hour, MaxBackoff: time.Hour, Log: io.Discard}
+	err := policy.Do(ctx, "step", func() error {
+		calls++
+		cancel()
+		return &httpStatusError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the first error", err, calls)
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   retryPolicy
+	}{
+		{"", defaultRetryPolicy},
+		{"attempts=1", retryPolicy{Attempts: 1, Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}},
+		{" attempts=5 , backoff=1s ", retryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 30 * time.Second}},
+		{"backoff=0s,max-backoff=0s", retryPolicy{Attempts: 3}},
+		{"backoff=1m,max-backoff=2m", retryPolicy{Attempts: 3, Backoff: time.Minute, MaxBackoff: 2 * time.Minute}},
+	}
+	for _, tt := range tests {
+		got, err := parseRetryPolicy(tt.policy)
+		if err != nil {
+			t.Errorf("parseRetryPolicy(%q): %v", tt.policy, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRetryPolicy(%q) = %+v, want %+v", tt.policy, got, tt.want)
+		}
+	}
+
+	for _, policy := range []string{
+		"attempts",
+		"attempts=0",
+		"attempts=many",
+		"backoff=soon",
+		"backoff=-1s",
+		"jitter=1s",
+		// The default max-backoff is 30s.
+		"backoff=1m",
+		"backoff=10s,max-backoff=5s",
+	} {
+		if _, err := parseRetryPolicy(policy); err == nil {
+			t.Errorf("parseRetryPolicy(%q) succeeded, want an error", policy)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"canceled", fmt.Errorf("fetch: %w", context.Canceled), false},
+		{"module not found", fmt.Errorf("fetch: %w", errModuleNotFound), false},
+		{"429", &httpStatusError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}, true},
+		{"500", fmt.Errorf("fetch: %w", &httpStatusError{StatusCode: http.StatusInternalServerError, Status: "500 Internal Server Error"}), true},
+		{"403", &httpStatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}, false},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}, true},
+		{"unexpected EOF", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		{"transient message", errors.New("pnpm install: ECONNRESET while fetching"), true},
+		{"transient message case", errors.New("git clone: Could not resolve host: github.com"), true},
+		{"other", errors.New("go.mod: syntax error"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%s: %v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
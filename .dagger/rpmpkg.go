@@ -0,0 +1,440 @@
+// # RPM packages.
+//
+// The lead, a signature header holding digests, the main header and a gzip-compressed
+// cpio payload, as read by rpm 4. Packages are not signed with a key.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Header entry types.
+const (
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmBin         = 7
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+// Header tags.
+const (
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+	rpmTagI18NTable        = 100
+
+	rpmSigTagSHA1        = 269
+	rpmSigTagSHA256      = 273
+	rpmSigTagSize        = 1000
+	rpmSigTagMD5         = 1004
+	rpmSigTagPayloadSize = 1007
+
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagVendor            = 1011
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPreIn             = 1023
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagPostUn            = 1026
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMtimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagSourceRPM         = 1044
+	rpmTagFileVerifyFlags   = 1045
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagPreInProg         = 1085
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagPostUnProg        = 1088
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+)
+
+// File flags and dependency senses.
+const (
+	rpmFileConfig    = 1 << 0
+	rpmFileNoReplace = 1 << 4
+	rpmFileLicense   = 1 << 7
+
+	rpmSenseLess   = 1 << 1
+	rpmSenseEqual  = 1 << 3
+	rpmSenseRPMLib = 1 << 24
+
+	// PGPHASHALGO_SHA256.
+	rpmDigestSHA256 = 8
+)
+
+// Features of rpm the package relies on, with the rpm version introducing them.
+var rpmLibRequires = [][2]string{
+	{"rpmlib(CompressedFileNames)", "3.0.4-1"},
+	{"rpmlib(FileDigests)", "4.6.0-1"},
+	{"rpmlib(PayloadFilesHavePrefix)", "4.0-1"},
+}
+
+// rpmVersion returns the RPM version and release of a package.
+//
+// Pre-releases sort before releases with "~", e.g. "0.26.0~rc.1".
+func rpmVersion(v *semver.Version) (string, string) {
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if v.Prerelease() != "" {
+		version += "~" + strings.ReplaceAll(v.Prerelease(), "-", "_")
+	}
+	return version, "1"
+}
+
+// rpmEntry is a header entry, with its data encoded.
+type rpmEntry struct {
+	Type  uint32
+	Count uint32
+	Data  []byte
+}
+
+// rpmHeader is a header structure, encoded as an immutable region.
+type rpmHeader struct {
+	// Region tag: HEADERSIGNATURES or HEADERIMMUTABLE.
+	Region  uint32
+	Entries map[uint32]rpmEntry
+}
+
+func newRPMHeader(region uint32) *rpmHeader {
+	return &rpmHeader{Region: region, Entries: map[uint32]rpmEntry{}}
+}
+
+func (h *rpmHeader) String(tag uint32, s string) {
+	h.Entries[tag] = rpmEntry{Type: rpmString, Count: 1, Data: append([]byte(s), 0)}
+}
+
+func (h *rpmHeader) I18NString(tag uint32, s string) {
+	h.Entries[tag] = rpmEntry{Type: rpmI18NString, Count: 1, Data: append([]byte(s), 0)}
+}
+
+func (h *rpmHeader) StringArray(tag uint32, values ...string) {
+	var data []byte
+	for _, s := range values {
+		data = append(append(data, s...), 0)
+	}
+	h.Entries[tag] = rpmEntry{Type: rpmStringArray, Count: uint32(len(values)), Data: data}
+}
+
+func (h *rpmHeader) Int32(tag uint32, values ...uint32) {
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint32(data, v)
+	}
+	h.Entries[tag] = rpmEntry{Type: rpmInt32, Count: uint32(len(values)), Data: data}
+}
+
+func (h *rpmHeader) Int16(tag uint32, values ...uint16) {
+	data := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		data = binary.BigEndian.AppendUint16(data, v)
+	}
+	h.Entries[tag] = rpmEntry{Type: rpmInt16, Count: uint32(len(values)), Data: data}
+}
+
+func (h *rpmHeader) Bin(tag uint32, data []byte) {
+	h.Entries[tag] = rpmEntry{Type: rpmBin, Count: uint32(len(data)), Data: data}
+}
+
+// Bytes encodes the header: its magic, the index entries and the data store.
+//
+// The region entry comes first, and its trailer closes the data store.
+func (h *rpmHeader) Bytes() []byte {
+	tags := slices.Sorted(maps.Keys(h.Entries))
+	var store bytes.Buffer
+	offsets := make([]int, len(tags))
+	for i, tag := range tags {
+		e := h.Entries[tag]
+		for store.Len()%rpmAlignment(e.Type) != 0 {
+			store.WriteByte(0)
+		}
+		offsets[i] = store.Len()
+		store.Write(e.Data)
+	}
+	count := len(tags) + 1
+	trailerOffset := store.Len()
+	trailer := rpmIndexEntry(h.Region, rpmBin, uint32(-16*count), 16)
+	store.Write(trailer)
+
+	var b bytes.Buffer
+	b.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	b.Write(binary.BigEndian.AppendUint32(nil, uint32(count)))
+	b.Write(binary.BigEndian.AppendUint32(nil, uint32(store.Len())))
+	b.Write(rpmIndexEntry(h.Region, rpmBin, uint32(trailerOffset), 16))
+	for i, tag := range tags {
+		e := h.Entries[tag]
+		b.Write(rpmIndexEntry(tag, e.Type, uint32(offsets[i]), e.Count))
+	}
+	b.Write(store.Bytes())
+	return b.Bytes()
+}
+
+// rpmAlignment returns the alignment of the data of an entry type in the data store.
+func rpmAlignment(typ uint32) int {
+	switch typ {
+	case rpmInt16:
+		return 2
+	case rpmInt32:
+		return 4
+	}
+	return 1
+}
+
+// rpmIndexEntry encodes an index entry.
+func rpmIndexEntry(tag, typ, offset, count uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, tag)
+	b = binary.BigEndian.AppendUint32(b, typ)
+	b = binary.BigEndian.AppendUint32(b, offset)
+	return binary.BigEndian.AppendUint32(b, count)
+}
+
+// rpmScript runs a scriptlet body under a condition, e.g. on the number of installed instances.
+func rpmScript(body, condition string) string {
+	return fmt.Sprintf("if %s; then\n%sfi\n", condition, indentScript(body))
+}
+
+// writeRPM writes an RPM package.
+func writeRPM(spec *packageSpec) ([]byte, error) {
+	version, release := rpmVersion(spec.Version)
+	mtime := uint32(spec.BuildTime.Unix())
+
+	// Payload: a cpio archive of the owned files, in header order.
+	var cpio bytes.Buffer
+	for i, f := range spec.Files {
+		writeCpioEntry(&cpio, "."+f.Path, uint32(i+1), f, mtime)
+	}
+	writeCpioEntry(&cpio, "TRAILER!!!", 0, packageFile{}, 0)
+
+	var payload bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&payload, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(cpio.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	payloadDigest := sha256.Sum256(payload.Bytes())
+
+	h := newRPMHeader(rpmTagHeaderImmutable)
+	h.StringArray(rpmTagI18NTable, "C")
+	h.String(rpmTagName, spec.Name)
+	h.String(rpmTagVersion, version)
+	h.String(rpmTagRelease, release)
+	h.I18NString(rpmTagSummary, packageSummary)
+	h.I18NString(rpmTagDescription, packageDescription)
+	h.Int32(rpmTagBuildTime, mtime)
+	h.String(rpmTagBuildHost, "memos-builds")
+	h.Int32(rpmTagSize, uint32(spec.InstalledSize()))
+	h.String(rpmTagVendor, "Memospot")
+	h.String(rpmTagLicense, packageLicense)
+	h.String(rpmTagPackager, packageMaintainer)
+	h.I18NString(rpmTagGroup, "Unspecified")
+	h.String(rpmTagURL, packageHomepage)
+	h.String(rpmTagOS, "linux")
+	h.String(rpmTagArch, spec.Arch)
+	h.String(rpmTagSourceRPM, fmt.Sprintf("%s-%s-%s.src.rpm", spec.Name, version, release))
+
+	h.String(rpmTagPreIn, packagePreInstall)
+	h.String(rpmTagPostIn, packagePostInstall)
+	h.String(rpmTagPreUn, rpmScript(packagePreRemove, `[ "$1" -eq 0 ]`))
+	h.String(rpmTagPostUn, rpmScript(packagePostRemove, `[ "$1" -eq 0 ]`))
+	for _, tag := range []uint32{rpmTagPreInProg, rpmTagPostInProg, rpmTagPreUnProg, rpmTagPostUnProg} {
+		h.String(tag, "/bin/sh")
+	}
+
+	h.StringArray(rpmTagProvideName, spec.Name)
+	h.Int32(rpmTagProvideFlags, rpmSenseEqual)
+	h.StringArray(rpmTagProvideVersion, version+"-"+release)
+	var requireNames, requireVersions []string
+	var requireFlags []uint32
+	for _, r := range rpmLibRequires {
+		requireNames = append(requireNames, r[0])
+		requireVersions = append(requireVersions, r[1])
+		requireFlags = append(requireFlags, rpmSenseRPMLib|rpmSenseLess|rpmSenseEqual)
+	}
+	h.StringArray(rpmTagRequireName, requireNames...)
+	h.Int32(rpmTagRequireFlags, requireFlags...)
+	h.StringArray(rpmTagRequireVersion, requireVersions...)
+
+	var (
+		sizes, mtimes, flags, verify, devices, inodes, dirIndexes []uint32
+		modes, rdevs                                              []uint16
+		digests, links, users, groups, langs, baseNames, dirNames []string
+	)
+	for i, f := range spec.Files {
+		dir := path.Dir(f.Path) + "/"
+		index := slices.Index(dirNames, dir)
+		if index < 0 {
+			index = len(dirNames)
+			dirNames = append(dirNames, dir)
+		}
+		dirIndexes = append(dirIndexes, uint32(index))
+		baseNames = append(baseNames, path.Base(f.Path))
+
+		var flag uint32
+		if f.Config {
+			flag |= rpmFileConfig | rpmFileNoReplace
+		}
+		if f.License {
+			flag |= rpmFileLicense
+		}
+		digest := ""
+		if !f.IsDir() {
+			sum := sha256.Sum256(f.Data)
+			digest = hex.EncodeToString(sum[:])
+		}
+
+		sizes = append(sizes, uint32(len(f.Data)))
+		modes = append(modes, uint16(rpmFileMode(f)))
+		rdevs = append(rdevs, 0)
+		mtimes = append(mtimes, mtime)
+		digests = append(digests, digest)
+		links = append(links, "")
+		flags = append(flags, flag)
+		users = append(users, f.OwnerName())
+		groups = append(groups, f.OwnerName())
+		verify = append(verify, 0xffffffff)
+		devices = append(devices, 1)
+		inodes = append(inodes, uint32(i+1))
+		langs = append(langs, "")
+	}
+	h.Int32(rpmTagFileSizes, sizes...)
+	h.Int16(rpmTagFileModes, modes...)
+	h.Int16(rpmTagFileRdevs, rdevs...)
+	h.Int32(rpmTagFileMtimes, mtimes...)
+	h.StringArray(rpmTagFileDigests, digests...)
+	h.StringArray(rpmTagFileLinkTos, links...)
+	h.Int32(rpmTagFileFlags, flags...)
+	h.StringArray(rpmTagFileUserName, users...)
+	h.StringArray(rpmTagFileGroupName, groups...)
+	h.Int32(rpmTagFileVerifyFlags, verify...)
+	h.Int32(rpmTagFileDevices, devices...)
+	h.Int32(rpmTagFileInodes, inodes...)
+	h.StringArray(rpmTagFileLangs, langs...)
+	h.Int32(rpmTagDirIndexes, dirIndexes...)
+	h.StringArray(rpmTagBaseNames, baseNames...)
+	h.StringArray(rpmTagDirNames, dirNames...)
+	h.Int32(rpmTagFileDigestAlgo, rpmDigestSHA256)
+
+	h.String(rpmTagPayloadFormat, "cpio")
+	h.String(rpmTagPayloadCompressor, "gzip")
+	h.String(rpmTagPayloadFlags, "9")
+	h.StringArray(rpmTagPayloadDigest, hex.EncodeToString(payloadDigest[:]))
+	h.Int32(rpmTagPayloadDigestAlgo, rpmDigestSHA256)
+	header := h.Bytes()
+
+	// The signature header covers the main header and the payload.
+	headerSHA1 := sha1.Sum(header)
+	headerSHA256 := sha256.Sum256(header)
+	md5sum := md5.New()
+	md5sum.Write(header)
+	md5sum.Write(payload.Bytes())
+	sig := newRPMHeader(rpmTagHeaderSignatures)
+	sig.String(rpmSigTagSHA1, hex.EncodeToString(headerSHA1[:]))
+	sig.String(rpmSigTagSHA256, hex.EncodeToString(headerSHA256[:]))
+	sig.Int32(rpmSigTagSize, uint32(len(header)+payload.Len()))
+	sig.Bin(rpmSigTagMD5, md5sum.Sum(nil))
+	sig.Int32(rpmSigTagPayloadSize, uint32(cpio.Len()))
+	signature := sig.Bytes()
+
+	var b bytes.Buffer
+	b.Write(rpmLead(fmt.Sprintf("%s-%s-%s", spec.Name, version, release)))
+	b.Write(signature)
+	b.Write(make([]byte, (8-len(signature)%8)%8))
+	b.Write(header)
+	b.Write(payload.Bytes())
+	return b.Bytes(), nil
+}
+
+// rpmLead returns the lead of a binary package. Only its magic and types are still read by rpm.
+func rpmLead(name string) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	// Type 0 is a binary package; the architecture number is left at 0.
+	copy(lead[10:75], name)
+	binary.BigEndian.PutUint16(lead[76:], 1) // OS: Linux
+	binary.BigEndian.PutUint16(lead[78:], 5) // Signature type: header-style
+	return lead
+}
+
+// rpmFileMode returns the mode of a file, including its type bits.
+func rpmFileMode(f packageFile) uint32 {
+	mode := uint32(f.Mode.Perm())
+	if f.IsDir() {
+		return mode | 0o040000
+	}
+	return mode | 0o100000
+}
+
+// writeCpioEntry appends an entry in the "newc" cpio format, owned by root.
+func writeCpioEntry(w *bytes.Buffer, name string, ino uint32, f packageFile, mtime uint32) {
+	mode, nlink := uint32(0), uint32(1)
+	if name != "TRAILER!!!" {
+		mode = rpmFileMode(f)
+	}
+	if f.Mode&fs.ModeDir != 0 {
+		nlink = 2
+	}
+	fmt.Fprintf(w, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, mode, 0, 0, nlink, mtime, len(f.Data), 0, 0, 0, 0, len(name)+1, 0)
+	w.WriteString(name)
+	w.WriteByte(0)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+	w.Write(f.Data)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
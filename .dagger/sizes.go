@@ -0,0 +1,448 @@
+// # Binary size tracking.
+//
+// Records the size of every binary and archive, compares them with a baseline
+// and breaks down what makes up a binary.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Committed baseline, used when no baseline is passed.
+const sizeBaselinePath = ".dagger/size-baseline.json"
+
+// Directory of the frontend files in the embed.FS of the Memos binary.
+const frontendEmbedDir = "dist/"
+
+// Number of entries kept in the package and asset breakdowns.
+const sizeBreakdownEntries = 20
+
+// Used when the size budget argument is empty: warn only.
+var defaultSizeBudget = sizeBudget{Warn: 5}
+
+// sizeBudget is the allowed growth over the baseline, in percent. Zero disables a threshold.
+type sizeBudget struct {
+	Warn float64
+	Fail float64
+}
+
+// parseSizeBudget parses comma-separated `key=value` pairs over the default budget.
+//
+// Keys: warn, fail. Values are percentages, e.g. "warn=3%,fail=10%".
+func parseSizeBudget(budget string) (sizeBudget, error) {
+	b := defaultSizeBudget
+	for pair := range strings.SplitSeq(budget, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return sizeBudget{}, fmt.Errorf("invalid size budget setting %q, expected key=value", pair)
+		}
+
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+		if err == nil && percent < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			return sizeBudget{}, fmt.Errorf("invalid size budget %s %q: %w", key, value, err)
+		}
+		switch strings.TrimSpace(key) {
+		case "warn":
+			b.Warn = percent
+		case "fail":
+			b.Fail = percent
+		default:
+			return sizeBudget{}, fmt.Errorf("unknown size budget setting %q (available: warn, fail)", key)
+		}
+	}
+	return b, nil
+}
+
+// SizeReport records the artifact sizes of a build, in bytes.
+type SizeReport struct {
+	Version string       `json:"version"`
+	Targets []targetSize `json:"targets"`
+	// Target the breakdowns were taken from, and how.
+	Reference string      `json:"reference,omitempty"`
+	Breakdown string      `json:"breakdown,omitempty"` // "symbols" (debug build) or "pclntab" (code only)
+	Packages  []sizeEntry `json:"packages,omitempty"`  // largest Go packages of the reference binary
+	Assets    []sizeEntry `json:"assets,omitempty"`    // largest embedded frontend files
+}
+
+type targetSize struct {
+	Target  string `json:"target"`
+	Binary  int    `json:"binary"`
+	Archive int    `json:"archive,omitempty"`
+}
+
+type sizeEntry struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// largest returns the n largest entries of a map, largest first.
+func largest(sizes map[string]int, n int) []sizeEntry {
+	entries := make([]sizeEntry, 0, len(sizes))
+	for _, name := range slices.Sorted(maps.Keys(sizes)) {
+		entries = append(entries, sizeEntry{Name: name, Size: sizes[name]})
+	}
+	slices.SortStableFunc(entries, func(a, b sizeEntry) int { return cmp.Compare(b.Size, a.Size) })
+	return entries[:min(n, len(entries))]
+}
+
+// goPackageSizes attributes the size of an ELF binary's symbols to Go packages.
+//
+// Uses the symbol table when present, as in debug builds. Stripped release binaries
+// only keep the Go function table (.gopclntab), so data is not accounted for.
+func goPackageSizes(data []byte) (map[string]int, string, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("not an ELF executable: %w", err)
+	}
+	defer f.Close()
+
+	sizes := map[string]int{}
+	packageOf := func(name string) string {
+		if pkg := (&gosym.Sym{Name: name}).PackageName(); pkg != "" {
+			return pkg
+		}
+		return "(other)"
+	}
+
+	if symbols, err := f.Symbols(); err == nil && len(symbols) > 0 {
+		for _, s := range symbols {
+			sizes[packageOf(s.Name)] += int(s.Size)
+		}
+		return sizes, "symbols", nil
+	}
+
+	pclntab, text := f.Section(".gopclntab"), f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, "", fmt.Errorf("no symbol table nor .gopclntab section")
+	}
+	pcln, err := pclntab.Data()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read .gopclntab: %w", err)
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(pcln, text.Addr))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse .gopclntab: %w", err)
+	}
+	for _, fn := range table.Funcs {
+		sizes[packageOf(fn.Name)] += int(fn.End - fn.Entry)
+	}
+	return sizes, "pclntab", nil
+}
+
+// embeddedFileSizes returns the size of every file embedded with `//go:embed` into an
+// embed.FS of an ELF binary, by path.
+//
+// The compiler writes each embed.FS as a slice header pointing right past itself,
+// followed by one {name string; data string; hash [16]byte} entry per file. Read-only
+// data is scanned for such headers, so stripped binaries are covered too.
+func embeddedFileSizes(data []byte) (map[string]int, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not an ELF executable: %w", err)
+	}
+	defer f.Close()
+
+	ptrSize := 8
+	if f.Class == elf.ELFCLASS32 {
+		ptrSize = 4
+	}
+	type loaded struct {
+		addr uint64
+		data []byte
+	}
+	var sections []loaded
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_PROGBITS || s.Flags&elf.SHF_ALLOC == 0 || s.Flags&elf.SHF_EXECINSTR != 0 {
+			continue
+		}
+		contents, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", s.Name, err)
+		}
+		sections = append(sections, loaded{s.Addr, contents})
+	}
+	// bytesAt returns the n bytes at a virtual address, or nil when they are not in the file.
+	bytesAt := func(addr, n uint64) []byte {
+		for _, s := range sections {
+			if addr >= s.addr && addr-s.addr <= uint64(len(s.data)) && n <= uint64(len(s.data))-(addr-s.addr) {
+				return s.data[addr-s.addr : addr-s.addr+n]
+			}
+		}
+		return nil
+	}
+	word := func(b []byte) uint64 {
+		if ptrSize == 4 {
+			return uint64(f.ByteOrder.Uint32(b))
+		}
+		return f.ByteOrder.Uint64(b)
+	}
+
+	const hashSize = 16
+	header, entrySize := 3*ptrSize, 4*ptrSize+hashSize
+	sizes := map[string]int{}
+	for _, s := range sections {
+		for off := 0; off+header <= len(s.data); off += ptrSize {
+			start := s.addr + uint64(off)
+			n := word(s.data[off+ptrSize:])
+			if word(s.data[off:]) != start+uint64(header) || n == 0 || n != word(s.data[off+2*ptrSize:]) {
+				continue
+			}
+			if n > uint64(len(s.data)-off-header)/uint64(entrySize) {
+				continue
+			}
+			files, ok := map[string]int{}, true
+			for i := range int(n) {
+				entry := s.data[off+header+i*entrySize:]
+				name := bytesAt(word(entry), word(entry[ptrSize:]))
+				dataAddr, size := word(entry[2*ptrSize:]), word(entry[3*ptrSize:])
+				if len(name) == 0 || (size > 0 && bytesAt(dataAddr, size) == nil) {
+					ok = false
+					break
+				}
+				if !bytes.HasSuffix(name, []byte("/")) {
+					files[string(name)] = int(size)
+				}
+			}
+			if !ok {
+				continue
+			}
+			maps.Copy(sizes, files)
+			off += header + int(n)*entrySize - ptrSize
+		}
+	}
+	return sizes, nil
+}
+
+// fileSizes returns the size of every file in a directory, by path.
+func fileSizes(ctx context.Context, dir *dagger.Directory) (map[string]int, error) {
+	out, err := dag.Container().
+		From(buildconsts.PRIMARY_IMAGE).
+		WithDirectory("/work", dir).
+		WithWorkdir("/work").
+		WithExec([]string{"sh", "-c", "find . -type f -exec stat -c '%s %n' {} +"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int{}
+	for line := range strings.Lines(out) {
+		size, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected stat output %q", line)
+		}
+		sizes[strings.TrimPrefix(name, "./")] = n
+	}
+	return sizes, nil
+}
+
+// measureSizes records the size of the binaries and archives of a build, with the
+// breakdowns taken from its first Linux target.
+func (m *MemosBuilds) measureSizes(
+	ctx context.Context,
+	build *buildResult,
+	archives *dagger.Directory,
+	version string,
+) (*SizeReport, error) {
+	binaries, err := fileSizes(ctx, build.Binaries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure binaries: %w", err)
+	}
+	archived, err := fileSizes(ctx, archives)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure archives: %w", err)
+	}
+
+	report := &SizeReport{Version: version}
+	for _, t := range build.Targets {
+		report.Targets = append(report.Targets, targetSize{
+			Target:  t.DockerPlatform(),
+			Binary:  binaries[t.BinaryName()],
+			Archive: archived[t.ArchiveName(version)],
+		})
+	}
+
+	linux := filterLinuxTargets(build.Targets)
+	if len(linux) == 0 {
+		return report, nil
+	}
+	reference := linux[0]
+	binary := build.Binaries.File(reference.BinaryName())
+	if build.DebugSymbols != nil {
+		binary = build.DebugSymbols.Directory(reference.BinaryName()).File("memos")
+	}
+	data, err := readFileBytes(ctx, binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", reference.BinaryName(), err)
+	}
+	packages, method, err := goPackageSizes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to break down %s: %w", reference.BinaryName(), err)
+	}
+	embedded, err := embeddedFileSizes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files embedded in %s: %w", reference.BinaryName(), err)
+	}
+	assets := map[string]int{}
+	for name, size := range embedded {
+		if asset, ok := strings.CutPrefix(name, frontendEmbedDir); ok {
+			assets[asset] = size
+		}
+	}
+	report.Reference = reference.DockerPlatform()
+	report.Breakdown = method
+	report.Packages = largest(packages, sizeBreakdownEntries)
+	report.Assets = largest(assets, sizeBreakdownEntries)
+	return report, nil
+}
+
+// loadSizeBaseline returns the baseline to compare with: the given file, or the committed one.
+// Returns nil when there is none, which compareSizes warns about.
+func (m *MemosBuilds) loadSizeBaseline(ctx context.Context, source *dagger.Directory, baseline *dagger.File) (*SizeReport, error) {
+	if baseline == nil {
+		ok, _ := source.Exists(ctx, sizeBaselinePath, dagger.DirectoryExistsOpts{ExpectedType: dagger.ExistsTypeRegularType})
+		if !ok {
+			return nil, nil
+		}
+		baseline = source.File(sizeBaselinePath)
+	}
+	contents, err := baseline.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read size baseline: %w", err)
+	}
+	var report SizeReport
+	if err := json.Unmarshal([]byte(contents), &report); err != nil {
+		return nil, fmt.Errorf("invalid size baseline: %w", err)
+	}
+	return &report, nil
+}
+
+// growth returns the relative growth of a size, in percent.
+func growth(baseline, size int) float64 {
+	if baseline == 0 {
+		return 0
+	}
+	return float64(size-baseline) / float64(baseline) * 100
+}
+
+// mib formats a size in mebibytes.
+func mib(size int) string {
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+}
+
+// compareSizes renders a size report against its baseline, and reports whether the fail budget is exceeded.
+func compareSizes(report, baseline *SizeReport, budget sizeBudget) (string, bool) {
+	previous := map[string]targetSize{}
+	baselineVersion := "none"
+	if baseline != nil {
+		baselineVersion = baseline.Version
+		for _, t := range baseline.Targets {
+			previous[t.Target] = t
+		}
+	}
+
+	var b, warnings strings.Builder
+	exceeded := false
+	fmt.Fprintf(&b, "Sizes %s (baseline: %s)\n\n", report.Version, baselineVersion)
+	for _, t := range report.Targets {
+		old, ok := previous[t.Target]
+		if !ok {
+			fmt.Fprintf(&b, "%-22s binary %-10s archive %s\n", t.Target, mib(t.Binary), mib(t.Archive))
+			continue
+		}
+		binaryGrowth, archiveGrowth := growth(old.Binary, t.Binary), growth(old.Archive, t.Archive)
+		fmt.Fprintf(&b, "%-22s binary %-10s (%+.1f%%)  archive %-10s (%+.1f%%)\n",
+			t.Target, mib(t.Binary), binaryGrowth, mib(t.Archive), archiveGrowth)
+
+		for _, g := range []struct {
+			kind   string
+			growth float64
+		}{{"binary", binaryGrowth}, {"archive", archiveGrowth}} {
+			switch {
+			case budget.Fail > 0 && g.growth > budget.Fail:
+				exceeded = true
+				fmt.Fprintf(&warnings, "FAIL %s: %s grew %.1f%% (budget: %g%%)\n", t.Target, g.kind, g.growth, budget.Fail)
+			case budget.Warn > 0 && g.growth > budget.Warn:
+				fmt.Fprintf(&warnings, "WARN %s: %s grew %.1f%% (budget: %g%%)\n", t.Target, g.kind, g.growth, budget.Warn)
+			}
+		}
+	}
+	if baseline == nil {
+		fmt.Fprintf(&warnings, "WARN no size baseline: pass --size-baseline or commit %s to compare sizes\n", sizeBaselinePath)
+	}
+	if warnings.Len() > 0 {
+		b.WriteString("\n" + warnings.String())
+	}
+
+	if len(report.Packages) > 0 {
+		fmt.Fprintf(&b, "\nLargest Go packages of %s (from %s):\n", report.Reference, report.Breakdown)
+		for _, e := range report.Packages {
+			fmt.Fprintf(&b, "  %-10s %s\n", mib(e.Size), e.Name)
+		}
+	}
+	if len(report.Assets) > 0 {
+		b.WriteString("\nLargest embedded frontend files:\n")
+		for _, e := range report.Assets {
+			fmt.Fprintf(&b, "  %-10s %s\n", mib(e.Size), e.Name)
+		}
+	}
+	return b.String(), exceeded
+}
+
+// trackSizes measures a build and compares it with the baseline.
+//
+// Returns the JSON report and the comparison, or an error when the fail budget is exceeded.
+func (m *MemosBuilds) trackSizes(
+	ctx context.Context,
+	source *dagger.Directory,
+	build *buildResult,
+	archives *dagger.Directory,
+	version string,
+	opts buildOptions,
+) (string, string, error) {
+	report, err := m.measureSizes(ctx, build, archives, version)
+	if err != nil {
+		return "", "", err
+	}
+	baseline, err := m.loadSizeBaseline(ctx, source, opts.SizeBaseline)
+	if err != nil {
+		return "", "", err
+	}
+
+	comparison, exceeded := compareSizes(report, baseline, opts.SizeLimits())
+	if exceeded {
+		return "", "", fmt.Errorf("size budget exceeded:\n%s", comparison)
+	}
+	if strings.Contains(comparison, "\nWARN ") {
+		fmt.Fprint(os.Stderr, comparison)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to serialise size report: %w", err)
+	}
+	return string(data), comparison, nil
+}
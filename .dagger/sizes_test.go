@@ -0,0 +1,113 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildTestProgram builds a Go program for amd64 from its sources, and returns the binary.
+func buildTestProgram(t *testing.T, goos string, sources map[string]string, ldflags string) []byte {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+	dir := t.TempDir()
+	for name, contents := range sources {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goTool, "build", "-trimpath", "-ldflags", ldflags, "-o", "memos", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH=amd64", "CGO_ENABLED=0", "GOFLAGS=", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "memos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// buildEmbedProgram links a Linux program embedding files into an embed.FS, as the Memos
+// frontend is, and returns the binary.
+func buildEmbedProgram(t *testing.T, files map[string]string, ldflags string) []byte {
+	t.Helper()
+	sources := map[string]string{
+		"go.mod": "module embedtest\n\ngo 1.22\n",
+		"main.go": `package main
+
+import (
+	"embed"
+	"fmt"
+)
+
+//go:embed dist
+var dist embed.FS
+
+//go:embed migration.sql
+var migration string
+
+func main() { fmt.Println(dist, migration) }
+`,
+		"migration.sql": "CREATE TABLE memo (id INTEGER);\n",
+	}
+	maps.Copy(sources, files)
+	return buildTestProgram(t, "linux", sources, ldflags)
+}
+
+func TestEmbeddedFileSizes(t *testing.T) {
+	files := map[string]string{
+		"dist/index.html":           "<!doctype html><title>Memos</title>\n",
+		"dist/assets/app-1a2b3c.js": strings.Repeat("console.log(1);\n", 4096),
+		"dist/assets/empty.css":     "",
+	}
+	want := map[string]int{}
+	for name, contents := range files {
+		want[name] = len(contents)
+	}
+
+	for name, ldflags := range map[string]string{
+		"symbols":  "",
+		"stripped": "-s -w",
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := embeddedFileSizes(buildEmbedProgram(t, files, ldflags))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only embed.FS variables are listed, not the migration string.
+			if !maps.Equal(got, want) {
+				t.Errorf("embeddedFileSizes() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedFileSizesRejectsNonELF(t *testing.T) {
+	if _, err := embeddedFileSizes([]byte("MZ\x90\x00")); err == nil {
+		t.Error("embeddedFileSizes succeeded on a PE header")
+	}
+}
+
+func TestCompareSizesWarnsWithoutBaseline(t *testing.T) {
+	report := &SizeReport{Version: "v0.25.3", Targets: []targetSize{{Target: "linux/amd64", Binary: 1 << 20}}}
+	comparison, exceeded := compareSizes(report, nil, sizeBudget{Warn: 5, Fail: 10})
+	if exceeded {
+		t.Error("budget exceeded without a baseline")
+	}
+	if !strings.Contains(comparison, "\nWARN no size baseline") {
+		t.Errorf("comparison does not warn about the missing baseline:\n%s", comparison)
+	}
+}
@@ -0,0 +1,233 @@
+// # Smoke tests.
+//
+// Checks that the built binaries start. Linux binaries are run in a container of their
+// platform, natively or under emulation; the others have their executable format validated.
+package main
+
+import (
+	"bytes"
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Seconds to wait for the server to answer, generous for emulated platforms.
+const smokeTestStartTimeout = 180
+
+// Checks run inside the target container: version output, then an HTTP probe.
+//
+// Uses BusyBox tools only, as the ARMv5 image has no package manager.
+const smokeTestScript = `
+	set -u
+	out=$(memos --version 2>&1) || { echo "$out" >&2; echo "memos --version failed" >&2; exit 1; }
+	echo "$out"
+	case "$out" in
+		*"$EXPECTED_VERSION"*) ;;
+		*) echo "memos --version does not report $EXPECTED_VERSION" >&2; exit 1 ;;
+	esac
+
+	export MEMOS_DATA="$(mktemp -d)"
+	memos > /tmp/memos.log 2>&1 &
+	pid=$!
+	for _ in $(seq 1 "$START_TIMEOUT"); do
+		if wget -q -O /dev/null "http://127.0.0.1:$MEMOS_PORT/"; then
+			echo "server answered on port $MEMOS_PORT"
+			kill "$pid"
+			exit 0
+		fi
+		if ! kill -0 "$pid" 2>/dev/null; then
+			break
+		fi
+		sleep 1
+	done
+	cat /tmp/memos.log >&2
+	echo "server did not answer on port $MEMOS_PORT" >&2
+	exit 1
+`
+
+// Expected machine types of the non-Linux targets, by GOARCH.
+var (
+	peMachines = map[string]uint16{
+		"386":   pe.IMAGE_FILE_MACHINE_I386,
+		"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+		"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+	}
+	machoCPUs = map[string]macho.Cpu{
+		"amd64": macho.CpuAmd64,
+		"arm64": macho.CpuArm64,
+	}
+	elfMachines = map[string]elf.Machine{
+		"386":     elf.EM_386,
+		"amd64":   elf.EM_X86_64,
+		"arm":     elf.EM_ARM,
+		"arm64":   elf.EM_AARCH64,
+		"ppc64le": elf.EM_PPC64,
+		"riscv64": elf.EM_RISCV,
+		"s390x":   elf.EM_S390,
+	}
+)
+
+// smokeTestResult is the outcome of the smoke test of a single target.
+type smokeTestResult struct {
+	Target BuildMatrix
+	// "run" or "format".
+	Check string
+	// Summary of a passing check.
+	Output string
+	// Why the check failed, or nil.
+	Err error
+}
+
+// runSmokeTest starts a Linux binary in a container of its platform.
+//
+// The expected version is empty when the build version is not linked into the binary.
+func (m *MemosBuilds) runSmokeTest(
+	ctx context.Context,
+	binary *dagger.File,
+	t BuildMatrix,
+	expectedVersion string,
+	profileEnv map[string]string,
+) (string, error) {
+	image := buildconsts.PRIMARY_IMAGE
+	if t.DockerPlatform() == "linux/arm/v5" {
+		image = buildconsts.ALTERNATE_IMAGE
+	}
+
+	ctr := dag.Container(dagger.ContainerOpts{Platform: dagger.Platform(t.DockerPlatform())}).
+		From(image).
+		WithFile("/usr/local/bin/memos", binary, dagger.ContainerWithFileOpts{Permissions: 0755})
+	for _, k := range slices.Sorted(maps.Keys(profileEnv)) {
+		ctr = ctr.WithEnvVariable(k, profileEnv[k])
+	}
+	return ctr.
+		WithEnvVariable("MEMOS_PORT", "5230").
+		WithEnvVariable("EXPECTED_VERSION", expectedVersion).
+		WithEnvVariable("START_TIMEOUT", fmt.Sprint(smokeTestStartTimeout)).
+		WithExec([]string{"sh", "-c", smokeTestScript}).
+		Stdout(ctx)
+}
+
+// verifyExecutableFormat checks that a binary has the executable format and machine type of its target.
+func verifyExecutableFormat(t BuildMatrix, data []byte) (string, error) {
+	r := bytes.NewReader(data)
+	switch t.OS {
+	case "windows":
+		f, err := pe.NewFile(r)
+		if err != nil {
+			return "", fmt.Errorf("not a PE executable: %w", err)
+		}
+		defer f.Close()
+		if want := peMachines[t.Arch]; f.Machine != want {
+			return "", fmt.Errorf("PE machine is %#x, expected %#x", f.Machine, want)
+		}
+		if f.Characteristics&pe.IMAGE_FILE_EXECUTABLE_IMAGE == 0 {
+			return "", fmt.Errorf("PE file is not an executable image")
+		}
+		return fmt.Sprintf("PE executable, machine %#x", f.Machine), nil
+
+	case "darwin":
+		f, err := macho.NewFile(r)
+		if err != nil {
+			return "", fmt.Errorf("not a Mach-O executable: %w", err)
+		}
+		defer f.Close()
+		if want := machoCPUs[t.Arch]; f.Cpu != want {
+			return "", fmt.Errorf("Mach-O CPU is %s, expected %s", f.Cpu, want)
+		}
+		if f.Type != macho.TypeExec {
+			return "", fmt.Errorf("Mach-O file type is %s, expected %s", f.Type, macho.TypeExec)
+		}
+		return fmt.Sprintf("Mach-O executable, %s", f.Cpu), nil
+
+	default:
+		f, err := elf.NewFile(r)
+		if err != nil {
+			return "", fmt.Errorf("not an ELF executable: %w", err)
+		}
+		defer f.Close()
+		if want := elfMachines[t.Arch]; f.Machine != want {
+			return "", fmt.Errorf("ELF machine is %s, expected %s", f.Machine, want)
+		}
+		if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+			return "", fmt.Errorf("ELF file type is %s, expected an executable", f.Type)
+		}
+		if t.OS == "freebsd" && f.OSABI != elf.ELFOSABI_FREEBSD {
+			return "", fmt.Errorf("ELF OS/ABI is %s, expected %s", f.OSABI, elf.ELFOSABI_FREEBSD)
+		}
+		return fmt.Sprintf("ELF executable, %s, %s", f.Machine, f.OSABI), nil
+	}
+}
+
+// smokeTest runs the smoke tests of every built target, in parallel.
+//
+// Results are returned in target order.
+func (m *MemosBuilds) smokeTest(
+	ctx context.Context,
+	build *buildResult,
+	opts buildOptions,
+) []smokeTestResult {
+	expectedVersion, _ := appVersion(build.Prepared.BuildVersion)
+	maxConcurrent := opts.Concurrency
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultConcurrency()
+	}
+
+	results := make([]smokeTestResult, len(build.Targets))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrent)
+	for i, t := range build.Targets {
+		g.Go(func() error {
+			binary := build.Binaries.File(t.BinaryName())
+			result := smokeTestResult{Target: t, Check: "run"}
+			if t.OS == "linux" {
+				result.Output, result.Err = m.runSmokeTest(gctx, binary, t, expectedVersion, build.Prepared.ContainerEnv())
+			} else {
+				result.Check = "format"
+				data, err := readFileBytes(gctx, binary)
+				if err != nil {
+					result.Err = fmt.Errorf("failed to read %s: %w", t.BinaryName(), err)
+				} else {
+					result.Output, result.Err = verifyExecutableFormat(t, data)
+				}
+			}
+			results[i] = result
+			// Failures are collected, so that every target gets tested.
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results
+}
+
+// formatSmokeTestReport renders smoke test results as a plain-text report.
+func formatSmokeTestReport(version string, results []smokeTestResult) string {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Smoke tests %s: %d passed, %d failed.\n", version, len(results)-failed, failed)
+	for _, r := range results {
+		status, detail := "PASS", r.Output
+		if r.Err != nil {
+			status, detail = "FAIL", newTargetFailure(r.Target, failureStageSmokeTest, r.Err).Excerpt
+		}
+		fmt.Fprintf(&b, "\n%s %s (%s)\n", status, r.Target.DockerPlatform(), r.Check)
+		for line := range strings.Lines(tailLines(detail, failureExcerptLines)) {
+			b.WriteString("    " + strings.TrimRight(line, "\n") + "\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,188 @@
+// # Toolchain selection.
+//
+// Picks the build images matching what the upstream source declares,
+// so older tags build with older toolchains and newer tags never use outdated ones.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var (
+	reGoDirective        = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+(?:\.\d+)?)\s*$`)
+	reToolchainDirective = regexp.MustCompile(`(?m)^toolchain\s+go(\d+\.\d+(?:\.\d+)?)\s*$`)
+	reBufConfigVersion   = regexp.MustCompile(`(?m)^version:\s*["']?(v\w+)["']?\s*$`)
+)
+
+// toolchainImage is a selected build image, and why it was selected.
+type toolchainImage struct {
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+}
+
+// Toolchain lists the images used to build a given upstream source.
+type Toolchain struct {
+	Go   toolchainImage `json:"go"`
+	Node toolchainImage `json:"node"`
+	Buf  toolchainImage `json:"buf"`
+	// pnpm version pinned by `packageManager`, activated by corepack. Informational.
+	Pnpm string `json:"pnpm,omitempty"`
+}
+
+// parseToolchainOverrides parses a comma-separated list of `tool=image` pairs.
+//
+// E.g. "go=golang:1.26.2-alpine,node=node:22-alpine".
+func parseToolchainOverrides(overrides string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for pair := range strings.SplitSeq(overrides, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tool, image, ok := strings.Cut(pair, "=")
+		if !ok || image == "" {
+			return nil, fmt.Errorf("invalid toolchain override %q, expected tool=image", pair)
+		}
+		if !slices.Contains([]string{"go", "node", "buf"}, tool) {
+			return nil, fmt.Errorf("unknown toolchain %q (available: go, node, buf)", tool)
+		}
+		parsed[tool] = image
+	}
+	return parsed, nil
+}
+
+// detectGoImage selects the Go image from the `toolchain` or `go` directive of go.mod.
+func detectGoImage(goMod string) toolchainImage {
+	if match := reToolchainDirective.FindStringSubmatch(goMod); match != nil {
+		return toolchainImage{
+			Image:  fmt.Sprintf(buildconsts.GOLANG_IMAGE_FORMAT, match[1]),
+			Reason: "go.mod toolchain go" + match[1],
+		}
+	}
+	if match := reGoDirective.FindStringSubmatch(goMod); match != nil {
+		return toolchainImage{
+			Image:  fmt.Sprintf(buildconsts.GOLANG_IMAGE_FORMAT, match[1]),
+			Reason: "go.mod go " + match[1],
+		}
+	}
+	return toolchainImage{Image: buildconsts.GOLANG_BUILD_IMAGE, Reason: "default"}
+}
+
+// detectNodeImage selects the newest known Node.js major release satisfying `engines.node`.
+// Also returns the pnpm version from `packageManager`, if any.
+func detectNodeImage(packageJSON string) (toolchainImage, string, error) {
+	var pkg struct {
+		Engines        map[string]string `json:"engines"`
+		PackageManager string            `json:"packageManager"`
+	}
+	if err := json.Unmarshal([]byte(packageJSON), &pkg); err != nil {
+		return toolchainImage{}, "", fmt.Errorf("failed to parse web/package.json: %w", err)
+	}
+
+	var pnpm string
+	if name, version, ok := strings.Cut(pkg.PackageManager, "@"); ok && name == "pnpm" {
+		// Strip the optional integrity hash, e.g. "10.12.1+sha512.abc".
+		pnpm, _, _ = strings.Cut(version, "+")
+	}
+
+	engine := strings.TrimSpace(pkg.Engines["node"])
+	if engine == "" {
+		return toolchainImage{Image: buildconsts.NODE_BUILD_IMAGE, Reason: "default"}, pnpm, nil
+	}
+
+	constraint, err := semver.NewConstraint(engine)
+	if err != nil {
+		return toolchainImage{}, "", fmt.Errorf("invalid engines.node %q: %w", engine, err)
+	}
+	for _, major := range buildconsts.NODE_MAJOR_VERSIONS {
+		// Any release of the major line satisfying the constraint will do, so test a late one.
+		if constraint.Check(semver.New(uint64(major), 99, 0, "", "")) {
+			return toolchainImage{
+				Image:  fmt.Sprintf(buildconsts.NODE_IMAGE_FORMAT, major),
+				Reason: "package.json engines.node " + engine,
+			}, pnpm, nil
+		}
+	}
+	return toolchainImage{}, "", fmt.Errorf("no known Node.js release satisfies engines.node %q", engine)
+}
+
+// bufConfigVersion returns the `version` of a buf configuration file.
+//
+// buf treats files without one as v1beta1.
+func bufConfigVersion(config string) string {
+	if match := reBufConfigVersion.FindStringSubmatch(config); match != nil {
+		return match[1]
+	}
+	return "v1beta1"
+}
+
+// detectBufImage selects the buf image from the configuration versions of buf.yaml and
+// buf.gen.yaml, through BUF_VERSIONS.
+func detectBufImage(bufYAML string, bufGenYAML string) (toolchainImage, error) {
+	key := bufConfigVersion(bufYAML) + "/" + bufConfigVersion(bufGenYAML)
+	version, ok := buildconsts.BUF_VERSIONS[key]
+	if !ok {
+		return toolchainImage{}, fmt.Errorf("no known buf release for buf.yaml and buf.gen.yaml versions %s; add one to BUF_VERSIONS", key)
+	}
+	buf, gen, _ := strings.Cut(key, "/")
+	return toolchainImage{
+		Image:  fmt.Sprintf(buildconsts.BUF_IMAGE_FORMAT, version),
+		Reason: fmt.Sprintf("buf.yaml %s, buf.gen.yaml %s", buf, gen),
+	}, nil
+}
+
+// detectToolchain selects build images from the upstream source, applying user overrides.
+func (m *MemosBuilds) detectToolchain(
+	ctx context.Context,
+	source *dagger.Directory,
+	overrides map[string]string,
+) (*Toolchain, error) {
+	goMod, err := source.File("go.mod").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	tc := &Toolchain{Go: detectGoImage(goMod)}
+
+	tc.Node = toolchainImage{Image: buildconsts.NODE_BUILD_IMAGE, Reason: "default"}
+	if packageJSON, err := source.File("web/package.json").Contents(ctx); err == nil {
+		tc.Node, tc.Pnpm, err = detectNodeImage(packageJSON)
+		if err != nil && overrides["node"] == "" {
+			return nil, err
+		}
+	}
+
+	tc.Buf = toolchainImage{Image: buildconsts.BUF_IMAGE, Reason: "default"}
+	if bufYAML, err := source.File("proto/buf.yaml").Contents(ctx); err == nil {
+		bufGenYAML, err := source.File("proto/buf.gen.yaml").Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proto/buf.gen.yaml: %w", err)
+		}
+		tc.Buf, err = detectBufImage(bufYAML, bufGenYAML)
+		if err != nil && overrides["buf"] == "" {
+			return nil, err
+		}
+	}
+
+	for tool, image := range overrides {
+		override := toolchainImage{Image: image, Reason: "override"}
+		switch tool {
+		case "go":
+			tc.Go = override
+		case "node":
+			tc.Node = override
+		case "buf":
+			tc.Buf = override
+		}
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,260 @@
+// # Upstream tests.
+//
+// Runs the upstream Go test suite on the patched source, for the engine's platform
+// and under emulation for exotic targets, where byte order and alignment bugs surface.
+package main
+
+import (
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// Linux platforms tested under emulation when none are given.
+const defaultTestPlatforms = "linux/s390x"
+
+// Upper bound of a `go test` run, per package. Emulated runs are slow.
+const upstreamTestTimeout = "30m"
+
+// testRun is the outcome of the upstream tests on one platform.
+type testRun struct {
+	Platform string
+	Report   *junitTestSuites
+	// Exit code of `go test`. Non-zero when a test or a package build failed.
+	ExitCode int
+	// `go test` stderr, holding errors that are not reported as test events.
+	Stderr string
+}
+
+// Failed reports whether the run did not pass.
+func (r testRun) Failed() bool {
+	return r.ExitCode != 0 || r.Report.Failures > 0
+}
+
+// testPlatforms returns the engine's platform followed by the emulated ones.
+func testPlatforms(ctx context.Context, emulated string) ([]string, error) {
+	host, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the engine platform: %w", err)
+	}
+	platforms := []string{string(host)}
+
+	if strings.TrimSpace(emulated) == "none" {
+		return platforms, nil
+	}
+	if strings.TrimSpace(emulated) == "" {
+		emulated = defaultTestPlatforms
+	}
+	targets, err := filterTargets(emulated)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test platforms: %w", err)
+	}
+	for _, t := range targets {
+		if t.OS != "linux" {
+			return nil, fmt.Errorf("%s cannot be emulated, only Linux platforms can", t.DockerPlatform())
+		}
+		if t.DockerPlatform() != platforms[0] {
+			platforms = append(platforms, t.DockerPlatform())
+		}
+	}
+	return platforms, nil
+}
+
+// goModuleContainer returns a Go container of the given platform holding a prepared source,
+// with its modules downloaded.
+//
+// The frontend is replaced by the headless placeholder, as only the Go code is used.
+func (m *MemosBuilds) goModuleContainer(
+	ctx context.Context,
+	prepared *preparedSource,
+	platform string,
+	retry retryPolicy,
+) (*dagger.Container, error) {
+	source := m.generateProto(prepared.Src, prepared.Toolchain.Buf.Image)
+	ctr := dag.Container(dagger.ContainerOpts{Platform: dagger.Platform(platform)}).
+		From(prepared.Toolchain.Go.Image).
+		WithMountedCache("/go/pkg/mod", dag.CacheVolume("go-mod")).
+		WithMountedCache("/root/.cache/go-build", dag.CacheVolume("go-build")).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithWorkdir("/src").
+		WithDirectory("/src", source).
+		WithNewFile("/src/server/router/frontend/dist/index.html", headlessIndexHTML)
+	ctr = prepared.GoProxy.Apply(ctr)
+
+	err := retry.Do(ctx, "go mod download ("+platform+")", func() (err error) {
+		ctr, err = ctr.WithExec([]string{"go", "mod", "download"}).Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download modules for %s: %w", platform, err)
+	}
+	return ctr, nil
+}
+
+// runUpstreamTests runs `go test ./...` on a prepared source, in a Go container of the given platform.
+func (m *MemosBuilds) runUpstreamTests(
+	ctx context.Context,
+	prepared *preparedSource,
+	platform string,
+	retry retryPolicy,
+) (testRun, error) {
+	ctr, err := m.goModuleContainer(ctx, prepared, platform, retry)
+	if err != nil {
+		return testRun{}, err
+	}
+	ctr, overlayFlags, err := prepared.Overlay.Mount(ctr, "/src")
+	if err != nil {
+		return testRun{}, err
+	}
+	args := []string{"go", "test", "-json", "-mod=readonly", "-timeout", upstreamTestTimeout}
+	args = append(append(args, overlayFlags...), "./...")
+
+	ran, err := ctr.
+		WithExec(args, dagger.ContainerWithExecOpts{
+			RedirectStdout: "/tmp/test.json",
+			Expect:         dagger.ReturnTypeAny,
+		}).
+		Sync(ctx)
+	if err != nil {
+		return testRun{}, fmt.Errorf("failed to run tests on %s: %w", platform, err)
+	}
+	exitCode, err := ran.ExitCode(ctx)
+	if err != nil {
+		return testRun{}, err
+	}
+	stderr, err := ran.Stderr(ctx)
+	if err != nil {
+		return testRun{}, err
+	}
+	events, err := ran.File("/tmp/test.json").Contents(ctx)
+	if err != nil {
+		return testRun{}, fmt.Errorf("failed to read test results for %s: %w", platform, err)
+	}
+
+	return testRun{
+		Platform: platform,
+		Report:   parseTestEvents("memos "+platform, []byte(events)),
+		ExitCode: exitCode,
+		Stderr:   stderr,
+	}, nil
+}
+
+// testUpstream runs the upstream tests on every platform, one at a time, as emulated runs are CPU-bound.
+func (m *MemosBuilds) testUpstream(
+	ctx context.Context,
+	prepared *preparedSource,
+	platforms []string,
+	retry retryPolicy,
+) ([]testRun, error) {
+	runs := make([]testRun, 0, len(platforms))
+	for _, platform := range platforms {
+		run, err := m.runUpstreamTests(ctx, prepared, platform, retry)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// formatTestSummary renders the outcome of test runs as a plain-text report.
+func formatTestSummary(version string, runs []testRun) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Upstream tests %s\n", version)
+	for _, r := range runs {
+		status := "PASS"
+		if r.Failed() {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "\n%s %s: %d tests, %d failed, %d skipped (exit code %d)\n",
+			status, r.Platform, r.Report.Tests, r.Report.Failures, r.Report.Skipped, r.ExitCode)
+		for _, name := range r.Report.FailedTests() {
+			b.WriteString("    " + name + "\n")
+		}
+		if r.Failed() && r.Report.Failures == 0 {
+			for line := range strings.Lines(tailLines(r.Stderr, failureExcerptLines)) {
+				b.WriteString("    " + strings.TrimRight(line, "\n") + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// testResults returns the JUnit reports of the runs, and a summary when any failed.
+func testResults(artifactVersion string, runs []testRun) (*dagger.Directory, bool, error) {
+	out := dag.Directory()
+	failed := false
+	for _, r := range runs {
+		report, err := r.Report.XML()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to serialise test report: %w", err)
+		}
+		platform := strings.ReplaceAll(r.Platform, "/", "-")
+		out = out.WithNewFile(fmt.Sprintf(buildconsts.TEST_REPORT_FILE_FORMAT, artifactVersion, platform), report)
+		failed = failed || r.Failed()
+	}
+	if failed {
+		out = out.WithNewFile(fmt.Sprintf(buildconsts.TEST_FAILURES_FILE_FORMAT, artifactVersion), formatTestSummary(artifactVersion, runs))
+	}
+	return out, failed, nil
+}
+
+// Test runs the upstream Go tests on the patched source and returns JUnit XML reports.
+//
+// Tests run for the engine's platform, then under emulation for the given Linux platforms.
+// Failures do not fail the call, so the reports can be exported: a `_test-failures.txt`
+// summary is added instead.
+func (m *MemosBuilds) Test(
+	ctx context.Context,
+	source *dagger.Directory,
+	// +optional
+	version string,
+	// Comma-separated Linux platforms to also test under emulation, or "none". Defaults to "linux/s390x".
+	// +optional
+	platforms string,
+	// Build image overrides as comma-separated `tool=image` pairs (e.g. "go=golang:1.26.2-alpine,node=node:22-alpine").
+	// Images are otherwise derived from the upstream source.
+	// +optional
+	toolchain string,
+	// Retry policy for network-bound steps, as comma-separated `key=value` pairs
+	// (attempts, backoff, max-backoff). Defaults to "attempts=3,backoff=2s,max-backoff=30s".
+	// +optional
+	retry string,
+	// GOPROXY list of the module downloads (e.g. "https://goproxy.example.com,off"). Defaults to the Go toolchain's.
+	// +optional
+	goproxy string,
+	// Local module proxy tree, referred to as "file:///goproxy" in --goproxy (the default when only this is given).
+	// +optional
+	goproxyDir *dagger.Directory,
+) (*dagger.Directory, error) {
+	if version == "" {
+		version = "nightly"
+	}
+	opts := buildOptions{
+		Headless:   true,
+		Toolchain:  toolchain,
+		Retry:      retry,
+		GoProxy:    goproxy,
+		GoProxyDir: goproxyDir,
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	testOn, err := testPlatforms(ctx, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	prepared, err := m.prepareSource(ctx, source, version, opts)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := m.testUpstream(ctx, prepared, testOn, opts.RetryPolicy())
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := testResults(prepared.ArtifactVersion(), runs)
+	return out, err
+}
@@ -0,0 +1,458 @@
+// # Windows resources.
+//
+// Version information and the application icon of memos.exe, linked from a COFF object
+// (`.syso`) that `go build` picks up from the main package. Like the package formats,
+// the object is written in Go; only the icon conversion runs in a container.
+//
+// See <https://learn.microsoft.com/en-us/windows/win32/menurc/resource-file-formats>.
+package main
+
+import (
+	"bytes"
+	"context"
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Resource types. See <https://learn.microsoft.com/en-us/windows/win32/menurc/resource-types>.
+const (
+	rtIcon      = 3
+	rtGroupIcon = 14
+	rtVersion   = 16
+)
+
+// Language of the resources and of the version strings: U.S. English, Unicode.
+const (
+	resourceLanguage = 0x0409
+	resourceCodePage = 0x04B0
+)
+
+// COFF machine types and relocations to the resource section (image-relative, 32-bit),
+// by Windows architecture. These are the relocations the Go linker resolves in `.rsrc`.
+var cofMachines = map[string]struct{ Machine, Reloc uint16 }{
+	"386":   {0x014c, 0x0007}, // IMAGE_REL_I386_DIR32NB
+	"amd64": {0x8664, 0x0003}, // IMAGE_REL_AMD64_ADDR32NB
+	"arm64": {0xaa64, 0x0002}, // IMAGE_REL_ARM64_ADDR32NB
+}
+
+// Icon sizes rendered from the favicon, in pixels.
+const windowsIconSizes = "256,64,48,32,24,16"
+
+// winResource is a resource of a Windows executable.
+type winResource struct {
+	Type uint16
+	ID   uint16
+	Data []byte
+}
+
+// versionBlock is a node of a VS_VERSIONINFO resource: a key with a binary or text value.
+type versionBlock struct {
+	Key      string
+	Value    []byte
+	Text     bool
+	Children []versionBlock
+}
+
+// utf16z encodes a string as NUL-terminated UTF-16LE.
+func utf16z(s string) []byte {
+	units := append(utf16.Encode([]rune(s)), 0)
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+// pad4 pads a buffer to a 32-bit boundary.
+func pad4(b *bytes.Buffer) {
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+}
+
+// Bytes encodes the block. Blocks start on 32-bit boundaries, relative to the resource.
+func (v versionBlock) Bytes() []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 6))
+	b.Write(utf16z(v.Key))
+	pad4(&b)
+	b.Write(v.Value)
+	for _, child := range v.Children {
+		pad4(&b)
+		b.Write(child.Bytes())
+	}
+
+	out := b.Bytes()
+	valueLength, valueType := len(v.Value), 0
+	if v.Text {
+		// Text values are measured in WCHARs.
+		valueLength, valueType = len(v.Value)/2, 1
+	}
+	binary.LittleEndian.PutUint16(out[0:], uint16(len(out)))
+	binary.LittleEndian.PutUint16(out[2:], uint16(valueLength))
+	binary.LittleEndian.PutUint16(out[4:], uint16(valueType))
+	return out
+}
+
+// versionInfo holds the fields of a VS_VERSIONINFO resource.
+type versionInfo struct {
+	Version *semver.Version
+	// Ordered name and value pairs of the string table. Empty values are left out.
+	Strings [][2]string
+}
+
+// Resource encodes the VS_VERSIONINFO resource.
+func (v versionInfo) Resource() []byte {
+	// Each component of the binary version is 16-bit.
+	part := func(n uint64) uint32 { return uint32(min(n, 0xffff)) }
+	ms := part(v.Version.Major())<<16 | part(v.Version.Minor())
+	ls := part(v.Version.Patch()) << 16
+	flags := uint32(0)
+	if v.Version.Prerelease() != "" {
+		flags |= 0x2 // VS_FF_PRERELEASE
+	}
+
+	fixed := make([]byte, 52)
+	for i, field := range []uint32{
+		0xFEEF04BD, // dwSignature
+		0x00010000, // dwStrucVersion
+		ms,         // dwFileVersionMS
+		ls,         // dwFileVersionLS
+		ms,         // dwProductVersionMS
+		ls,         // dwProductVersionLS
+		0x3F,       // dwFileFlagsMask
+		flags,      // dwFileFlags
+		0x40004,    // dwFileOS: VOS_NT_WINDOWS32
+		0x1,        // dwFileType: VFT_APP
+		0,          // dwFileSubtype
+		0,          // dwFileDateMS
+		0,          // dwFileDateLS
+	} {
+		binary.LittleEndian.PutUint32(fixed[4*i:], field)
+	}
+
+	var table []versionBlock
+	for _, kv := range v.Strings {
+		if kv[1] != "" {
+			table = append(table, versionBlock{Key: kv[0], Value: utf16z(kv[1]), Text: true})
+		}
+	}
+	translation := make([]byte, 4)
+	binary.LittleEndian.PutUint16(translation[0:], resourceLanguage)
+	binary.LittleEndian.PutUint16(translation[2:], resourceCodePage)
+
+	return versionBlock{Key: "VS_VERSION_INFO", Value: fixed, Children: []versionBlock{
+		{Key: "StringFileInfo", Text: true, Children: []versionBlock{
+			{Key: fmt.Sprintf("%04X%04X", resourceLanguage, resourceCodePage), Text: true, Children: table},
+		}},
+		{Key: "VarFileInfo", Text: true, Children: []versionBlock{
+			{Key: "Translation", Value: translation},
+		}},
+	}}.Bytes()
+}
+
+// iconResources splits an ICO file into RT_ICON resources, numbered from 1, and the
+// RT_GROUP_ICON resource listing them.
+func iconResources(ico []byte) ([]winResource, error) {
+	if len(ico) < 6 || binary.LittleEndian.Uint16(ico[0:]) != 0 || binary.LittleEndian.Uint16(ico[2:]) != 1 {
+		return nil, fmt.Errorf("not an ICO file")
+	}
+	count := int(binary.LittleEndian.Uint16(ico[4:]))
+	if count == 0 || len(ico) < 6+16*count {
+		return nil, fmt.Errorf("truncated ICO directory")
+	}
+
+	var group bytes.Buffer
+	group.Write(ico[:6])
+	var resources []winResource
+	for i := range count {
+		entry := ico[6+16*i : 6+16*(i+1)]
+		size := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(ico)) {
+			return nil, fmt.Errorf("ICO image %d is out of bounds", i+1)
+		}
+		id := uint16(i + 1)
+		resources = append(resources, winResource{Type: rtIcon, ID: id, Data: ico[offset : offset+size]})
+		// GRPICONDIRENTRY: the ICONDIRENTRY, with the image offset replaced by the resource ID.
+		group.Write(entry[:12])
+		binary.Write(&group, binary.LittleEndian, id)
+	}
+	return append(resources, winResource{Type: rtGroupIcon, ID: 1, Data: group.Bytes()}), nil
+}
+
+// writeResourceObject writes a COFF object with a `.rsrc` section holding the resources.
+func writeResourceObject(goarch string, resources []winResource) ([]byte, error) {
+	machine, ok := cofMachines[goarch]
+	if !ok {
+		return nil, fmt.Errorf("no COFF machine type for %s", goarch)
+	}
+	resources = slices.Clone(resources)
+	slices.SortFunc(resources, func(a, b winResource) int {
+		if a.Type != b.Type {
+			return int(a.Type) - int(b.Type)
+		}
+		return int(a.ID) - int(b.ID)
+	})
+	var types []uint16
+	for _, r := range resources {
+		if !slices.Contains(types, r.Type) {
+			types = append(types, r.Type)
+		}
+	}
+
+	// Layout: the type directory, a name directory per type, a language directory per
+	// resource, the data entries, then the data.
+	const dirSize, entrySize, dataEntrySize = 16, 8, 16
+	nameDirs := map[uint16]int{}
+	offset := dirSize + entrySize*len(types)
+	for _, typ := range types {
+		nameDirs[typ] = offset
+		offset += dirSize + entrySize*countResources(resources, typ)
+	}
+	langDirs := offset
+	dataEntries := langDirs + (dirSize+entrySize)*len(resources)
+	dataOffsets := make([]int, len(resources))
+	offset = dataEntries + dataEntrySize*len(resources)
+	for i, r := range resources {
+		offset = (offset + 7) &^ 7
+		dataOffsets[i] = offset
+		offset += len(r.Data)
+	}
+	section := make([]byte, (offset+7)&^7)
+
+	le := binary.LittleEndian
+	directory := func(at, entries int) {
+		le.PutUint16(section[at+14:], uint16(entries)) // NumberOfIdEntries
+	}
+	directory(0, len(types))
+	for i, typ := range types {
+		le.PutUint32(section[dirSize+entrySize*i:], uint32(typ))
+		le.PutUint32(section[dirSize+entrySize*i+4:], uint32(nameDirs[typ])|0x80000000)
+		directory(nameDirs[typ], countResources(resources, typ))
+	}
+	var relocations []int
+	first := 0
+	for i, r := range resources {
+		// Resources are sorted, so those of a type are contiguous.
+		if i > 0 && resources[i-1].Type != r.Type {
+			first = i
+		}
+		at := nameDirs[r.Type] + dirSize + entrySize*(i-first)
+		lang := langDirs + (dirSize+entrySize)*i
+		le.PutUint32(section[at:], uint32(r.ID))
+		le.PutUint32(section[at+4:], uint32(lang)|0x80000000)
+
+		directory(lang, 1)
+		data := dataEntries + dataEntrySize*i
+		le.PutUint32(section[lang+dirSize:], resourceLanguage)
+		le.PutUint32(section[lang+dirSize+4:], uint32(data))
+
+		// OffsetToData is an RVA: the linker adds the address of the section.
+		le.PutUint32(section[data:], uint32(dataOffsets[i]))
+		le.PutUint32(section[data+4:], uint32(len(r.Data)))
+		relocations = append(relocations, data)
+		copy(section[dataOffsets[i]:], r.Data)
+	}
+
+	const fileHeaderSize, sectionHeaderSize, relocationSize = 20, 40, 10
+	rawData := fileHeaderSize + sectionHeaderSize
+	relocationTable := rawData + len(section)
+	symbolTable := relocationTable + relocationSize*len(relocations)
+
+	var b bytes.Buffer
+	// IMAGE_FILE_HEADER
+	binary.Write(&b, le, machine.Machine)
+	binary.Write(&b, le, uint16(1))           // NumberOfSections
+	binary.Write(&b, le, uint32(0))           // TimeDateStamp
+	binary.Write(&b, le, uint32(symbolTable)) // PointerToSymbolTable
+	binary.Write(&b, le, uint32(1))           // NumberOfSymbols
+	binary.Write(&b, le, uint16(0))           // SizeOfOptionalHeader
+	binary.Write(&b, le, uint16(0))           // Characteristics
+	// IMAGE_SECTION_HEADER
+	b.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(&b, le, uint32(0))                // VirtualSize
+	binary.Write(&b, le, uint32(0))                // VirtualAddress
+	binary.Write(&b, le, uint32(len(section)))     // SizeOfRawData
+	binary.Write(&b, le, uint32(rawData))          // PointerToRawData
+	binary.Write(&b, le, uint32(relocationTable))  // PointerToRelocations
+	binary.Write(&b, le, uint32(0))                // PointerToLinenumbers
+	binary.Write(&b, le, uint16(len(relocations))) // NumberOfRelocations
+	binary.Write(&b, le, uint16(0))                // NumberOfLinenumbers
+	binary.Write(&b, le, uint32(0x40000040))       // IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ
+	b.Write(section)
+	for _, at := range relocations {
+		binary.Write(&b, le, uint32(at))    // VirtualAddress
+		binary.Write(&b, le, uint32(0))     // SymbolTableIndex: .rsrc
+		binary.Write(&b, le, machine.Reloc) // Type
+	}
+	// Symbol of the section, which the relocations are relative to.
+	b.WriteString(".rsrc\x00\x00\x00")
+	binary.Write(&b, le, uint32(0)) // Value
+	binary.Write(&b, le, int16(1))  // SectionNumber
+	binary.Write(&b, le, uint16(0)) // Type
+	b.WriteByte(3)                  // StorageClass: IMAGE_SYM_CLASS_STATIC
+	b.WriteByte(0)                  // NumberOfAuxSymbols
+	// Empty string table.
+	binary.Write(&b, le, uint32(4))
+	return b.Bytes(), nil
+}
+
+// countResources returns the number of resources of a type.
+func countResources(resources []winResource, typ uint16) int {
+	n := 0
+	for _, r := range resources {
+		if r.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// copyrightLine returns the copyright notice of a license, e.g. "Copyright (c) 2025 Memos".
+func copyrightLine(license string) string {
+	for line := range strings.Lines(license) {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "Copyright") {
+			return line
+		}
+	}
+	return ""
+}
+
+// windowsVersionInfo returns the version information of a build's executables.
+func windowsVersionInfo(prepared *preparedSource, license string) versionInfo {
+	product := "Memos"
+	if prepared.Branding != nil {
+		product = prepared.Branding.Title
+	}
+	version, ok := appVersion(prepared.BuildVersion)
+	if !ok {
+		version = prepared.BuildVersion
+	}
+	comments := "Built by memos-builds: https://github.com/memospot/memos-builds"
+	if short := shortCommitHash(prepared.Commit); short != "" {
+		version += " (" + short + ")"
+		comments = "Commit " + prepared.Commit + ". " + comments
+	}
+	return versionInfo{
+		Version: packageVersion(prepared.BuildVersion),
+		Strings: [][2]string{
+			{"CompanyName", "Memospot"},
+			{"FileDescription", product},
+			{"FileVersion", version},
+			{"InternalName", "memos"},
+			{"LegalCopyright", copyrightLine(license)},
+			{"OriginalFilename", "memos.exe"},
+			{"ProductName", product},
+			{"ProductVersion", version},
+			{"Comments", comments},
+		},
+	}
+}
+
+// windowsIcon converts the favicon of the (branded) frontend to a multi-size ICO file.
+//
+// Returns nil when index.html references no local favicon.
+func (m *MemosBuilds) windowsIcon(ctx context.Context, src *dagger.Directory, retry retryPolicy) (*dagger.File, error) {
+	indexHTML, err := src.File("web/index.html").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read web/index.html: %w", err)
+	}
+	icon, ok := iconTarget(indexHTML)
+	if !ok {
+		return nil, nil
+	}
+	if strings.EqualFold(path.Ext(icon), ".ico") {
+		return src.File(path.Join("web", icon)), nil
+	}
+
+	var ctr *dagger.Container
+	err = retry.Do(ctx, "apk add (icon)", func() (err error) {
+		ctr, err = dag.Container().
+			From(buildconsts.PRIMARY_IMAGE).
+			WithExec([]string{"apk", "add", "--no-cache", "imagemagick", "imagemagick-jpeg", "imagemagick-svg", "imagemagick-webp"}).
+			Sync(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the icon converter: %w", err)
+	}
+
+	source := "/work/favicon" + path.Ext(icon)
+	return ctr.
+		WithFile(source, src.File(path.Join("web", icon))).
+		// Non-square images are centered on a transparent square.
+		WithExec([]string{
+			"magick", source + "[0]", "-background", "none",
+			"-resize", "256x256", "-gravity", "center", "-extent", "256x256",
+			"-define", "icon:auto-resize=" + windowsIconSizes, "/work/memos.ico",
+		}).
+		File("/work/memos.ico").
+		Sync(ctx)
+}
+
+// windowsResourceObjects returns a `.syso` per Windows architecture of the targets, holding
+// the version information and the icon, to be placed in the main package.
+//
+// Returns nil when no target is a Windows one.
+func (m *MemosBuilds) windowsResourceObjects(
+	ctx context.Context,
+	prepared *preparedSource,
+	targets []BuildMatrix,
+	retry retryPolicy,
+) (*dagger.Directory, error) {
+	var arches []string
+	for _, t := range targets {
+		if t.OS == "windows" && !slices.Contains(arches, t.Arch) {
+			arches = append(arches, t.Arch)
+		}
+	}
+	if len(arches) == 0 {
+		return nil, nil
+	}
+
+	license, err := prepared.Src.File("LICENSE").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the license: %w", err)
+	}
+	resources := []winResource{{Type: rtVersion, ID: 1, Data: windowsVersionInfo(prepared, license).Resource()}}
+
+	icon, err := m.windowsIcon(ctx, prepared.Src, retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the favicon: %w", err)
+	}
+	if icon != nil {
+		ico, err := readFileBytes(ctx, icon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the icon: %w", err)
+		}
+		icons, err := iconResources(ico)
+		if err != nil {
+			return nil, fmt.Errorf("invalid icon: %w", err)
+		}
+		resources = append(resources, icons...)
+	}
+
+	out := dag.Directory()
+	for _, arch := range arches {
+		obj, err := writeResourceObject(arch, resources)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("rsrc_windows_%s.syso", arch)
+		file, err := newFileFromBytes(ctx, name, obj)
+		if err != nil {
+			return nil, err
+		}
+		out = out.WithFile(name, file)
+	}
+	return out, nil
+}
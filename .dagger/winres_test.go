@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// testIcon returns an ICO file with an image per size, each holding its size as data.
+func testIcon(sizes ...byte) []byte {
+	var ico bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&ico, le, []uint16{0, 1, uint16(len(sizes))})
+	offset := 6 + 16*len(sizes)
+	for _, size := range sizes {
+		ico.Write([]byte{size, size, 0, 0})
+		binary.Write(&ico, le, []uint16{1, 32})
+		binary.Write(&ico, le, []uint32{uint32(size), uint32(offset)})
+		offset += int(size)
+	}
+	for _, size := range sizes {
+		ico.Write(bytes.Repeat([]byte{size}, int(size)))
+	}
+	return ico.Bytes()
+}
+
+// peResources reads the resource directory of an executable, by type, ID and language.
+func peResources(t *testing.T, exe []byte) map[[3]uint32][]byte {
+	t.Helper()
+	f, err := pe.NewFile(bytes.NewReader(exe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, ok := f.OptionalHeader.(*pe.OptionalHeader64)
+	if !ok {
+		t.Fatalf("optional header is %T, want a PE32+ one", f.OptionalHeader)
+	}
+	dir := header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		t.Fatal("no resource directory")
+	}
+	var rsrc []byte
+	for _, s := range f.Sections {
+		if dir.VirtualAddress >= s.VirtualAddress && dir.VirtualAddress+dir.Size <= s.VirtualAddress+s.VirtualSize {
+			data, err := s.Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsrc = data[dir.VirtualAddress-s.VirtualAddress:]
+			break
+		}
+	}
+	if rsrc == nil {
+		t.Fatalf("resource directory at %#x is in no section", dir.VirtualAddress)
+	}
+
+	le := binary.LittleEndian
+	u32 := func(at uint32) uint32 {
+		if uint64(at)+4 > uint64(len(rsrc)) {
+			t.Fatalf("resource offset %#x is out of bounds", at)
+		}
+		return le.Uint32(rsrc[at:])
+	}
+	// entries returns the IDs of a directory and what they point to.
+	entries := func(at uint32, subdirectories bool) [][2]uint32 {
+		if uint64(at)+16 > uint64(len(rsrc)) {
+			t.Fatalf("resource directory %#x is out of bounds", at)
+		}
+		if named := le.Uint16(rsrc[at+12:]); named != 0 {
+			t.Errorf("resource directory %#x has %d named entries", at, named)
+		}
+		var out [][2]uint32
+		for i := range uint32(le.Uint16(rsrc[at+14:])) {
+			id, target := u32(at+16+8*i), u32(at+16+8*i+4)
+			if isDir := target&0x80000000 != 0; isDir != subdirectories {
+				t.Fatalf("resource entry %d of %#x: subdirectory %v, want %v", id, at, isDir, subdirectories)
+			}
+			out = append(out, [2]uint32{id, target &^ 0x80000000})
+		}
+		return out
+	}
+
+	resources := map[[3]uint32][]byte{}
+	for _, typ := range entries(0, true) {
+		for _, id := range entries(typ[1], true) {
+			for _, lang := range entries(id[1], false) {
+				// IMAGE_RESOURCE_DATA_ENTRY: the data is addressed by RVA.
+				rva, size := u32(lang[1]), u32(lang[1]+4)
+				at := rva - dir.VirtualAddress
+				if rva < dir.VirtualAddress || uint64(at)+uint64(size) > uint64(len(rsrc)) {
+					t.Fatalf("resource %d/%d/%d at RVA %#x is out of bounds", typ[0], id[0], lang[0], rva)
+				}
+				resources[[3]uint32{typ[0], id[0], lang[0]}] = rsrc[at : at+size]
+			}
+		}
+	}
+	return resources
+}
+
+// versionInfoKey returns the key of a VS_VERSIONINFO block.
+func versionInfoKey(block []byte) string {
+	var units []uint16
+	for at := 6; at+1 < len(block); at += 2 {
+		u := binary.LittleEndian.Uint16(block[at:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+// Links the resource object into a Windows program, as `go build` does with the `.syso`
+// of the main package, and reads the resources back from the executable.
+func TestWriteResourceObject(t *testing.T) {
+	version := windowsVersionInfo(testPreparedSource(), "MIT License\n\nCopyright (c) 2025 Memos\n").Resource()
+	icons, err := iconResources(testIcon(16, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := append([]winResource{{Type: rtVersion, ID: 1, Data: version}}, icons...)
+	obj, err := writeResourceObject("amd64", resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe := buildTestProgram(t, "windows", map[string]string{
+		"go.mod":                  "module winrestest\n\ngo 1.22\n",
+		"main.go":                 "package main\n\nfunc main() {}\n",
+		"rsrc_windows_amd64.syso": string(obj),
+	}, "-s -w")
+	got := peResources(t, exe)
+
+	want := map[[3]uint32][]byte{}
+	for _, r := range resources {
+		want[[3]uint32{uint32(r.Type), uint32(r.ID), resourceLanguage}] = r.Data
+	}
+	for key, data := range want {
+		if !bytes.Equal(got[key], data) {
+			t.Errorf("resource %d/%d/%#x: got %d bytes, want %d", key[0], key[1], key[2], len(got[key]), len(data))
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("executable has %d resources, want %d", len(got), len(want))
+	}
+
+	info := got[[3]uint32{rtVersion, 1, resourceLanguage}]
+	if key := versionInfoKey(info); key != "VS_VERSION_INFO" {
+		t.Errorf("RT_VERSION resource key = %q, want VS_VERSION_INFO", key)
+	}
+	if len(info) >= 2 && int(binary.LittleEndian.Uint16(info)) != len(info) {
+		t.Errorf("VS_VERSIONINFO length = %d, resource size %d", binary.LittleEndian.Uint16(info), len(info))
+	}
+	// The fixed file info follows the padded key, starting with its signature.
+	if at := bytes.Index(info, []byte{0xBD, 0x04, 0xEF, 0xFE}); at != 40 {
+		t.Errorf("VS_FIXEDFILEINFO signature at %d, want 40", at)
+	}
+}
+
+func TestWriteResourceObjectRejectsUnknownArch(t *testing.T) {
+	if _, err := writeResourceObject("riscv64", nil); err == nil {
+		t.Error("writeResourceObject succeeded for riscv64")
+	}
+}
@@ -0,0 +1,181 @@
+// # Windows service archives.
+//
+// A zip next to each Windows release archive, installing Memos as a service in one step:
+// memos.exe, WinSW as memos-service.exe with the configuration of the Windows service
+// guide, and PowerShell scripts that install or remove the service and its firewall rule.
+package main
+
+import (
+	"dagger/memos-builds/buildconsts"
+	"dagger/memos-builds/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// Program path of the firewall rule in the Windows service guide, replaced by the
+// installation directory of the scripts.
+const guideProgramPath = `"$Env:ProgramData\memos\memos.exe"`
+
+// Installs or upgrades the service. `{{FIREWALL_RULE}}` is replaced by the rule of the guide.
+const installServiceScript = `#Requires -RunAsAdministrator
+<#
+.SYNOPSIS
+Installs Memos as a Windows service, or upgrades it.
+
+.DESCRIPTION
+Copies memos.exe and WinSW to the installation directory, registers the "memos" service
+from memos-service.xml, allows memos.exe through Windows Firewall, then starts the service.
+Memos stores its data in $Env:ProgramData\memos, as set in memos-service.xml.
+#>
+param(
+    # Where the program files are installed.
+    [string]$InstallDir = "$Env:ProgramData\memos",
+    # Do not add the Windows Firewall rule.
+    [switch]$NoFirewall
+)
+$ErrorActionPreference = 'Stop'
+
+$Files = 'memos.exe', 'memos-service.exe', 'memos-service.xml', 'uninstall.ps1'
+$Service = Join-Path $InstallDir 'memos-service.exe'
+
+# Upgrades replace memos.exe, which is locked while running.
+if (Get-Service -Name memos -ErrorAction SilentlyContinue) {
+    Stop-Service -Name memos
+}
+
+New-Item -ItemType Directory -Force -Path $InstallDir | Out-Null
+if ((Resolve-Path $PSScriptRoot).Path -ne (Resolve-Path $InstallDir).Path) {
+    foreach ($File in $Files) {
+        Copy-Item -Force -Path (Join-Path $PSScriptRoot $File) -Destination $InstallDir
+    }
+}
+
+if (-not (Get-Service -Name memos -ErrorAction SilentlyContinue)) {
+    & $Service install
+    if ($LASTEXITCODE -ne 0) {
+        throw "memos-service.exe install failed with exit code $LASTEXITCODE"
+    }
+}
+
+if (-not $NoFirewall -and -not (Get-NetFirewallRule -DisplayName 'Memos' -ErrorAction SilentlyContinue)) {
+    # Allow memos.exe on Windows Firewall
+    {{FIREWALL_RULE}} | Out-Null
+}
+
+Start-Service -Name memos
+Write-Host "Memos is running at http://localhost:5230 (see MEMOS_PORT in $InstallDir\memos-service.xml)."
+`
+
+// Removes the service, its firewall rule and the program files. Data is kept.
+const uninstallServiceScript = `#Requires -RunAsAdministrator
+<#
+.SYNOPSIS
+Removes the Memos Windows service.
+
+.DESCRIPTION
+Stops and unregisters the "memos" service, removes its Windows Firewall rule and the
+program files. The data in $Env:ProgramData\memos is kept.
+#>
+param(
+    # Where the program files were installed.
+    [string]$InstallDir = "$Env:ProgramData\memos"
+)
+$ErrorActionPreference = 'Stop'
+
+$Service = Join-Path $InstallDir 'memos-service.exe'
+if (Get-Service -Name memos -ErrorAction SilentlyContinue) {
+    Stop-Service -Name memos
+    & $Service uninstall
+    if ($LASTEXITCODE -ne 0) {
+        throw "memos-service.exe uninstall failed with exit code $LASTEXITCODE"
+    }
+}
+
+Get-NetFirewallRule -DisplayName 'Memos' -ErrorAction SilentlyContinue | Remove-NetFirewallRule
+
+foreach ($File in 'memos.exe', 'memos-service.exe', 'memos-service.xml', 'uninstall.ps1') {
+    Remove-Item -Force -ErrorAction SilentlyContinue -Path (Join-Path $InstallDir $File)
+}
+Write-Host "Memos was removed. Its data was kept in $Env:ProgramData\memos."
+`
+
+// serviceScripts returns the install and uninstall scripts, using the firewall rule of the guide.
+func serviceScripts(firewallRule string) (install, uninstall string, err error) {
+	if !strings.Contains(firewallRule, guideProgramPath) {
+		return "", "", fmt.Errorf("firewall rule does not allow %s", guideProgramPath)
+	}
+	rule := strings.Replace(firewallRule, guideProgramPath, "(Join-Path $InstallDir 'memos.exe')", 1)
+	return strings.Replace(installServiceScript, "{{FIREWALL_RULE}}", rule, 1), uninstallServiceScript, nil
+}
+
+// serviceReadme returns the README of a Windows service archive.
+func serviceReadme(t BuildMatrix, version string, files []bundledFile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Memos %s Windows service (%s)\n\n", version, t.DockerPlatform())
+	b.WriteString("Memos, set up as a Windows service with [WinSW](https://github.com/winsw/winsw).\n\n")
+	b.WriteString("Built by memos-builds: <https://github.com/memospot/memos-builds>\n\n")
+
+	b.WriteString("## Contents\n\n")
+	b.WriteString("- `memos.exe`: server binary.\n")
+	b.WriteString("- `memos-service.exe`: WinSW service wrapper.\n")
+	b.WriteString("- `LICENSE`: Memos license.\n")
+	for _, f := range files {
+		fmt.Fprintf(&b, "- `%s`: %s.\n", f.Name, f.Description)
+	}
+
+	b.WriteString("\n## Installing\n\n")
+	b.WriteString("Extract the archive, then, in PowerShell as an administrator:\n\n")
+	b.WriteString("```powershell\n")
+	b.WriteString("Get-ChildItem *.ps1 | Unblock-File\n")
+	b.WriteString(".\\install.ps1\n")
+	b.WriteString("```\n\n")
+	b.WriteString("The service is installed to `$Env:ProgramData\\memos`, which also holds its data, and\n")
+	b.WriteString("starts at boot. Then open <http://localhost:5230>.\n\n")
+	b.WriteString("- `-InstallDir <path>` installs the program files elsewhere.\n")
+	b.WriteString("- `-NoFirewall` skips the inbound Windows Firewall rule for `memos.exe`.\n\n")
+	b.WriteString("Settings are `<env>` entries of `memos-service.xml`; run `install.ps1` again after editing it.\n")
+	b.WriteString("To upgrade, run `install.ps1` from a newer archive.\n\n")
+
+	b.WriteString("## Removing\n\n")
+	b.WriteString("```powershell\n")
+	b.WriteString("& \"$Env:ProgramData\\memos\\uninstall.ps1\"\n")
+	b.WriteString("```\n\n")
+	b.WriteString("The data in `$Env:ProgramData\\memos` is kept.\n")
+	b.WriteString("See the [Windows service guide](https://github.com/memospot/memos-builds/blob/main/docs/service-windows.md).\n")
+	return b.String()
+}
+
+// createServiceArchive creates the Windows service archive of a target, under a versioned
+// top-level directory.
+func (m *MemosBuilds) createServiceArchive(
+	binary *dagger.File,
+	bundle *archiveBundle,
+	t BuildMatrix,
+	version string,
+	archiver *dagger.Container,
+) (*dagger.File, error) {
+	install, uninstall, err := serviceScripts(bundle.FirewallRule)
+	if err != nil {
+		return nil, err
+	}
+	files := []bundledFile{
+		{Name: "memos-service.xml", Contents: bundle.WinSWConfig, Permissions: 0644, Description: "WinSW service configuration"},
+		{Name: "install.ps1", Contents: install, Permissions: 0644, Description: "installs or upgrades the service"},
+		{Name: "uninstall.ps1", Contents: uninstall, Permissions: 0644, Description: "removes the service, keeping its data"},
+	}
+
+	// The URL pins the WinSW release.
+	winsw := dag.HTTP(buildconsts.WINSW_URL)
+	dir := dag.Directory().
+		WithFile("memos.exe", binary, dagger.DirectoryWithFileOpts{Permissions: 0755}).
+		WithFile("memos-service.exe", winsw, dagger.DirectoryWithFileOpts{Permissions: 0755}).
+		WithFile("LICENSE", bundle.License, dagger.DirectoryWithFileOpts{Permissions: 0644}).
+		WithNewFile("README.md", serviceReadme(t, version, files), dagger.DirectoryWithNewFileOpts{Permissions: 0644})
+	for _, f := range files {
+		dir = dir.WithNewFile(f.Name, f.Contents, dagger.DirectoryWithNewFileOpts{Permissions: f.Permissions})
+	}
+
+	name := t.ServiceArchiveName(version)
+	contents := dag.Directory().WithDirectory(strings.TrimSuffix(name, ".zip"), dir)
+	return m.createDirectoryArchive(contents, name, archiver), nil
+}
@@ -0,0 +1,35 @@
+#!/bin/sh
+# shellcheck shell=sh
+#
+# Default workload for `dagger call collect-pgo-profile` and `dagger call collect-coverage`.
+#
+# Exercises the request paths of a typical instance against $MEMOS_URL for $DURATION seconds.
+# Calls are best-effort, so the script keeps working across upstream API changes.
+
+set -u
+
+jar=/tmp/pgo-cookies
+end=$(($(date +%s) + DURATION))
+
+call() {
+        curl -sS -o /dev/null -b "$jar" -c "$jar" -H "Content-Type: application/json" "$@" || true
+}
+
+# First user becomes the host. Sign-in routes differ between releases, so try both.
+credentials='{"username":"pgo","password":"pgo-workload-password"}'
+call -X POST "$MEMOS_URL/api/v1/auth/signup" -d "$credentials"
+call -X POST "$MEMOS_URL/api/v1/users" -d '{"username":"pgo","password":"pgo-workload-password","role":"HOST"}'
+call -X POST "$MEMOS_URL/api/v1/auth/signin" -d "$credentials"
+call -X POST "$MEMOS_URL/api/v1/auth/sessions" -d '{"passwordCredentials":{"username":"pgo","password":"pgo-workload-password"}}'
+
+i=0
+while [ "$(date +%s)" -lt "$end" ]; do
+        i=$((i + 1))
+        call -X POST "$MEMOS_URL/api/v1/memos" \
+                -d "{\"content\":\"Workload memo $i with #tag$((i % 10)) and a [link](https://usememos.com).\\n\\n- [ ] task\\n- item\"}"
+        call "$MEMOS_URL/api/v1/memos?pageSize=50"
+        call "$MEMOS_URL/api/v1/memos?filter=content.contains(%22memo%20$((i % 100))%22)"
+        call "$MEMOS_URL/api/v1/workspace/profile"
+        call "$MEMOS_URL/api/v1/auth/status"
+        call "$MEMOS_URL/"
+done